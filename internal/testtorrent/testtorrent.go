@@ -0,0 +1,186 @@
+// Package testtorrent makes torrents, and a tracker for
+// them, for the tests of the packages that use them.
+package testtorrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/aot"
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+// Pattern is n bytes of data that differ by seed.
+func Pattern(n int, seed byte) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i*7) + seed
+	}
+
+	return p
+}
+
+// File is a file of a torrent, and its data.
+type File struct {
+	// Slash separated, empty for the one
+	// file of a single file torrent.
+	Path string
+	Data []byte
+}
+
+// Torrent describes a torrent to make.
+type Torrent struct {
+	Name        string
+	PieceLength uint64
+	Files       []File
+	// Left out if empty.
+	Announce string
+}
+
+// Data is the data of all the files, in order.
+func (tt *Torrent) Data() []byte {
+	var all []byte
+	for _, f := range tt.Files {
+		all = append(all, f.Data...)
+	}
+
+	return all
+}
+
+// Info is the info dictionary of the torrent.
+func (tt *Torrent) Info() *metainfo.Info {
+	info := &metainfo.Info{Name: []byte(tt.Name), PieceLength: tt.PieceLength}
+
+	if len(tt.Files) == 1 && tt.Files[0].Path == "" {
+		info.Length = uint64(len(tt.Files[0].Data))
+	} else {
+		for _, f := range tt.Files {
+			path := bytes.Split([]byte(f.Path), []byte("/"))
+			info.Files = append(info.Files, metainfo.File{Length: uint64(len(f.Data)), Path: path})
+		}
+	}
+
+	all := tt.Data()
+	for off := 0; off < len(all); off += int(tt.PieceLength) {
+		sum := sha1.Sum(all[off:min(off+int(tt.PieceLength), len(all))])
+		info.Pieces = append(info.Pieces, sum[:]...)
+	}
+
+	return info
+}
+
+// MetaInfo is the torrent's metainfo file, decoded.
+func (tt *Torrent) MetaInfo(tb testing.TB) *metainfo.MetaInfoPreCompute {
+	tb.Helper()
+
+	p := []byte("d")
+	if tt.Announce != "" {
+		p = encode.KeyString(p, "announce", tt.Announce)
+	}
+
+	p = append(p, "4:info"...)
+	p = tt.Info().AppendBencode(p)
+	p = append(p, 'e')
+
+	mi := &metainfo.MetaInfoPreCompute{}
+	if err := aot.DecodeMetaInfoFile(mi, p); err.IsError() {
+		tb.Fatal(err)
+	}
+
+	return mi
+}
+
+// Path is where the file at path is stored under dir,
+// as storage.OpenDir lays the torrent out.
+func (tt *Torrent) Path(dir, path string) string {
+	return filepath.Join(dir, tt.Name, filepath.FromSlash(path))
+}
+
+// Write writes the torrent's files under dir.
+func (tt *Torrent) Write(tb testing.TB, dir string) {
+	tb.Helper()
+
+	for _, f := range tt.Files {
+		name := tt.Path(dir, f.Path)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+
+		if err := os.WriteFile(name, f.Data, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// Check checks the files under dir are the torrent's.
+func (tt *Torrent) Check(tb testing.TB, dir string) {
+	tb.Helper()
+
+	for _, f := range tt.Files {
+		got, err := os.ReadFile(tt.Path(dir, f.Path))
+		if err != nil || !bytes.Equal(got, f.Data) {
+			tb.Fatalf("%s: %d bytes, %v, want %d", f.Path, len(got), err, len(f.Data))
+		}
+	}
+}
+
+// Announcer is a tracker that gives every peer that
+// announced a torrent to every other, on 127.0.0.1,
+// and Peers besides. It keeps the requests it got.
+type Announcer struct {
+	Peers []netip.AddrPort
+
+	mu    sync.Mutex
+	reqs  []tracker.AnnounceRequest
+	swarm map[[20]byte]map[netip.AddrPort]bool
+}
+
+func (a *Announcer) Announce(ctx context.Context, announce []byte, req *tracker.AnnounceRequest) (*tracker.AnnounceResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.reqs = append(a.reqs, *req)
+
+	if a.swarm == nil {
+		a.swarm = make(map[[20]byte]map[netip.AddrPort]bool)
+	}
+
+	swarm := a.swarm[req.InfoHash]
+	if swarm == nil {
+		swarm = make(map[netip.AddrPort]bool)
+		a.swarm[req.InfoHash] = swarm
+	}
+
+	addr := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(req.Port))
+	swarm[addr] = true
+
+	resp := &tracker.AnnounceResponse{Interval: time.Hour}
+	for _, p := range a.Peers {
+		resp.Peers = append(resp.Peers, tracker.Peer{Addr: p})
+	}
+
+	for p := range swarm {
+		if p != addr {
+			resp.Peers = append(resp.Peers, tracker.Peer{Addr: p})
+		}
+	}
+
+	return resp, nil
+}
+
+// Requests is the requests announced so far, in order.
+func (a *Announcer) Requests() []tracker.AnnounceRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]tracker.AnnounceRequest(nil), a.reqs...)
+}
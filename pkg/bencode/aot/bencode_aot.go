@@ -1,10 +1,37 @@
 package aot
 
 import (
+	"time"
+
 	"github.com/joelancaster/bytepour/pkg/bencode/parse"
 	"github.com/joelancaster/bytepour/pkg/metainfo"
 )
 
+// Depths of the dictionaries and lists
+// we decode, the top level dictionary being 1.
+const (
+	depthTop   = 1
+	depthInfo  = 2
+	depthFiles = 3
+	depthFile  = 4
+	depthPath  = 5
+
+	depthNodes = 2
+	depthNode  = 3
+)
+
+// What the value of a key we care about is.
+const (
+	wantNone = iota
+	// A value kept some other way.
+	wantValue
+	wantStr
+	wantInt
+	wantFiles
+	wantPath
+	wantNodes
+)
+
 // DecodeMetaInfoFile parses a bencode representation of a meta info file
 // a.k.a. .torrent files.
 func DecodeMetaInfoFile(mi *metainfo.MetaInfoPreCompute, p []byte) parse.Error {
@@ -17,38 +44,104 @@ func DecodeMetaInfoFile(mi *metainfo.MetaInfoPreCompute, p []byte) parse.Error {
 	}
 
 	// Valid bencodings should have a dictionary at the top level.
-	if p[0] != 'd' {
+	if len(p) == 0 || p[0] != 'd' {
 		return parse.MakeError(parse.ErrNoTopLevelDict, 0, 0)
 	}
 
 	var s stack
 	s.push(parse.Dict)
 
-	var nextStr *[]byte
-	var nextInt *uint64
+	// What the value of the last key we care
+	// about is, and where it should be stored.
+	// The values of other keys are skipped
+	// whole, so a term in a dictionary is a
+	// key unless next is set.
+	var next uint8
+	var str *[]byte
+	var num *uint64
 
 	var startInfoDict, endInfoDict uint32
 
-	for i = 1; i < uint32(len(p)); {
+	// Whether we are inside the info dict's
+	// "files" list, or a file's "path" list.
+	var inFiles, inPath bool
+	var file *metainfo.File
+
+	// Whether we are inside the "nodes" list.
+	var inNodes bool
+	var node *metainfo.Node
+
+	var creationDate uint64
+
+	// Clear the fields of extensions, and the lists, keeping
+	// the space we allocated last time. The announce, comment
+	// and info fields are set as found.
+	mi.CommentUTF8 = nil
+	mi.CreationDate = time.Time{}
+	mi.CreatedBy = nil
+	mi.Encoding = nil
+	mi.Nodes = mi.Nodes[:0]
+	mi.Extra = mi.Extra[:0]
+	mi.Info.NameUTF8 = nil
+	mi.Info.Files = mi.Info.Files[:0]
+	mi.Info.Extra = mi.Info.Extra[:0]
+
+	for i = 1; i < uint32(len(p)) && s.depth() > 0; {
 		numeric := (p[i] - '0') < 10
 
+		// The key we saw last only applies
+		// to the term that follows it.
+		want := next
+		next = wantNone
+
+		// Keys must be strings.
+		if !numeric && want == wantNone && p[i] != parse.EndTerm && s.topType() == parse.Dict {
+			return parse.MakeError(parse.ErrConfusion, i, 0)
+		}
+
 		switch {
 		case p[i] == parse.OpenList:
 			i++
 			s.push(parse.List)
+
+			inFiles = inFiles || want == wantFiles
+			inPath = inPath || want == wantPath
+			inNodes = inNodes || want == wantNodes
+
+			// A [host, port] pair.
+			if inNodes && s.depth() == depthNode {
+				mi.Nodes = append(mi.Nodes, metainfo.Node{})
+				node = &mi.Nodes[len(mi.Nodes)-1]
+			}
 		case p[i] == parse.OpenDict:
 			i++
 			s.push(parse.Dict)
+
+			if inFiles && s.depth() == depthFile {
+				mi.Info.Files = append(mi.Info.Files, metainfo.File{})
+				file = &mi.Info.Files[len(mi.Info.Files)-1]
+			}
 		case p[i] == parse.OpenInt:
 			n, j := parse.ParseInt(p[i+1:])
 
-			if nextInt != nil {
-				*nextInt = uint64(n)
-				nextInt = nil
+			if want == wantInt {
+				*num = uint64(n)
+			}
+
+			// Of a [host, port] pair node, other
+			// shapes of node being skipped.
+			if inNodes && node != nil && s.depth() == depthNode && s.topType() == parse.List {
+				if n < 0 || n > 0xFFFF {
+					// Not a port, so not a node.
+					mi.Nodes = mi.Nodes[:len(mi.Nodes)-1]
+					node = nil
+				} else {
+					node.Port = uint16(n)
+				}
 			}
 
 			i += uint32(j) + 1
-			if p[i] != 'e' {
+			if i >= uint32(len(p)) || p[i] != 'e' {
 				return parse.MakeError(parse.ErrUnexpectedEndOfTerm, i, parse.Int)
 			}
 			i++
@@ -60,58 +153,78 @@ func DecodeMetaInfoFile(mi *metainfo.MetaInfoPreCompute, p []byte) parse.Error {
 
 			i += uint32(j)
 
-			// We only care about dictionaries for
-			// a metainfo file.
-			if s.topType() != parse.Dict {
+			// A component of a file's path.
+			if inPath && s.depth() == depthPath && s.topType() == parse.List {
+				file.Path = append(file.Path, bs)
 				break
 			}
 
-			// This is a string value of a key in a
-			// dictionary.
-			if nextStr != nil {
-				*nextStr = bs
-				nextStr = nil
-
+			if inNodes && node != nil && s.depth() == depthNode && s.topType() == parse.List {
+				node.Host = bs
 				break
 			}
 
-			if string(bs) == "info" && s.topType() == parse.Dict &&
-				s.depth() == 1 && p[i] == 'd' {
-				startInfoDict = i
-			}
-
-			if string(bs) == "length" {
-				nextInt = &mi.Info.Length
+			// Other than paths, we only care about
+			// dictionaries for a metainfo file.
+			if s.topType() != parse.Dict {
 				break
 			}
 
-			if string(bs) == "piece length" {
-				nextInt = &mi.Info.PieceLength
+			// This is a string value of a key in a
+			// dictionary.
+			if want != wantNone {
+				if want == wantStr {
+					*str = bs
+				}
+
 				break
 			}
 
 			// this is a key
 			// possibly for an element we care about
-			if string(bs) == "announce" {
-				nextStr = &mi.Announce
-				break
+			var extra *[]metainfo.RawField
+
+			switch {
+			case s.depth() == depthTop:
+				next, str, num, extra = topKey(mi, bs, &creationDate)
+
+				if next == wantValue && startInfoDict == 0 && i < uint32(len(p)) && p[i] == 'd' {
+					startInfoDict = i
+				}
+			case s.depth() == depthInfo && startInfoDict != 0 && endInfoDict == 0:
+				next, str, num, extra = infoKey(&mi.Info, bs)
+			case s.depth() == depthFile && inFiles:
+				next, num, extra = fileKey(file, bs)
 			}
 
-			if string(bs) == "comment" {
-				nextStr = &mi.Comment
-				break
+			// Not something we care about, keep it,
+			// if it's somewhere we keep such keys,
+			// as it is and skip past its value.
+			if next == wantNone {
+				n := parse.Skip(p[i:])
+				if n < 0 {
+					return parse.MakeError(parse.ErrUnexpectedEndOfTerm, i, 0)
+				}
+
+				if extra != nil {
+					*extra = append(*extra, metainfo.RawField{Key: bs, Value: p[i : i+uint32(n)]})
+				}
+
+				i += uint32(n)
 			}
-			if string(bs) == "name" {
-				nextStr = &mi.Info.Name
-				break
+		case p[i] == 'e':
+			if s.depth() == depthPath {
+				inPath = false
 			}
-			if string(bs) == "pieces" {
-				nextStr = &mi.Info.Pieces
-				break
+
+			if s.depth() == depthFiles {
+				inFiles = false
+			}
+
+			if s.depth() == depthNodes {
+				inNodes = false
 			}
 
-			// Not something we care about, ignore it
-		case p[i] == 'e':
 			s.pop()
 			i++
 
@@ -132,5 +245,67 @@ func DecodeMetaInfoFile(mi *metainfo.MetaInfoPreCompute, p []byte) parse.Error {
 		mi.InfoDict = p[startInfoDict:endInfoDict]
 	}
 
+	if creationDate != 0 {
+		mi.CreationDate = time.Unix(int64(creationDate), 0).UTC()
+	}
+
 	return parse.ErrOk
 }
+
+// topKey is what the value of the key of the top level
+// dictionary is, and where it should be stored: in mi,
+// in creationDate, or with the keys not decoded.
+func topKey(mi *metainfo.MetaInfoPreCompute, key []byte, creationDate *uint64) (uint8, *[]byte, *uint64, *[]metainfo.RawField) {
+	switch string(key) {
+	case "announce":
+		return wantStr, &mi.Announce, nil, nil
+	case "comment":
+		return wantStr, &mi.Comment, nil, nil
+	case "comment.utf-8":
+		return wantStr, &mi.CommentUTF8, nil, nil
+	case "created by":
+		return wantStr, &mi.CreatedBy, nil, nil
+	case "creation date":
+		return wantInt, nil, creationDate, nil
+	case "encoding":
+		return wantStr, &mi.Encoding, nil, nil
+	case "nodes":
+		return wantNodes, nil, nil, nil
+	case "info":
+		return wantValue, nil, nil, nil
+	}
+
+	return wantNone, nil, nil, &mi.Extra
+}
+
+// infoKey is topKey for the info dictionary.
+func infoKey(info *metainfo.Info, key []byte) (uint8, *[]byte, *uint64, *[]metainfo.RawField) {
+	switch string(key) {
+	case "length":
+		return wantInt, nil, &info.Length, nil
+	case "piece length":
+		return wantInt, nil, &info.PieceLength, nil
+	case "name":
+		return wantStr, &info.Name, nil, nil
+	case "name.utf-8":
+		return wantStr, &info.NameUTF8, nil, nil
+	case "pieces":
+		return wantStr, &info.Pieces, nil, nil
+	case "files":
+		return wantFiles, nil, nil, nil
+	}
+
+	return wantNone, nil, nil, &info.Extra
+}
+
+// fileKey is topKey for a file of the files list.
+func fileKey(file *metainfo.File, key []byte) (uint8, *uint64, *[]metainfo.RawField) {
+	switch string(key) {
+	case "length":
+		return wantInt, &file.Length, nil
+	case "path":
+		return wantPath, nil, nil
+	}
+
+	return wantNone, nil, &file.Extra
+}
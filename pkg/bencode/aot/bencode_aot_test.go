@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/joelancaster/bytepour/pkg/bencode/aot/testdata"
 	"github.com/joelancaster/bytepour/pkg/metainfo"
@@ -35,6 +36,184 @@ func TestAOTMetaInfo(t *testing.T) {
 	}
 }
 
+func TestAOTMultiFile(t *testing.T) {
+	const torrent = "d8:announce20:http://a.example/ann4:infod" +
+		"5:filesl" +
+		"d6:lengthi5e4:pathl3:dir5:a.txtee" +
+		"d4:pathl5:b.txte6:lengthi7ee" +
+		"e" +
+		// a "name" that is a value of another key should not
+		// be mistaken for the name key.
+		"4:name4:root12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaa" +
+		"1:x4:name" +
+		"ee"
+
+	var mi metainfo.MetaInfoPreCompute
+
+	err := DecodeMetaInfoFile(&mi, []byte(torrent))
+	if err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	want := metainfo.MetaInfoPreCompute{
+		Announce: []byte("http://a.example/ann"),
+		InfoDict: []byte(torrent[len("d8:announce20:http://a.example/ann4:info") : len(torrent)-1]),
+		Info: metainfo.Info{
+			Name:        []byte("root"),
+			PieceLength: 16384,
+			Pieces:      []byte("aaaaaaaaaaaaaaaaaaaa"),
+			Files: []metainfo.File{
+				{Length: 5, Path: [][]byte{[]byte("dir"), []byte("a.txt")}},
+				{Length: 7, Path: [][]byte{[]byte("b.txt")}},
+			},
+			Extra: []metainfo.RawField{{Key: []byte("x"), Value: []byte("4:name")}},
+		},
+	}
+
+	if !mi.Eq(&want) {
+		t.Fatalf("got: %s, want: %s", &mi, &want)
+	}
+
+	if got := mi.Info.TotalLength(); got != 12 {
+		t.Fatalf("total length got: %d, want: 12", got)
+	}
+
+	if fs := metainfo.Validate(&mi); len(fs) != 0 {
+		t.Fatalf("unexpected findings: %v", fs)
+	}
+}
+
+func TestAOTOptionalKeys(t *testing.T) {
+	const info = "d6:lengthi1e4:name1:a10:name.utf-83:\xc3\xa5a12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+	const torrent = "d7:comment2:hi13:comment.utf-83:h\xc3\xad" +
+		"10:created by13:mktorrent 1.113:creation datei1707570148e" +
+		"8:encoding5:UTF-8" +
+		"4:info" + info +
+		"5:nodesl" +
+		"l11:router.testi6881ee" +
+		"l9:127.0.0.1i1ee" +
+		"e" +
+		"e"
+
+	var mi metainfo.MetaInfoPreCompute
+
+	err := DecodeMetaInfoFile(&mi, []byte(torrent))
+	if err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	want := metainfo.MetaInfoPreCompute{
+		Comment:      []byte("hi"),
+		CommentUTF8:  []byte("h\xc3\xad"),
+		CreatedBy:    []byte("mktorrent 1.1"),
+		CreationDate: time.Date(2024, time.February, 10, 13, 2, 28, 0, time.UTC),
+		Encoding:     []byte("UTF-8"),
+		InfoDict:     []byte(info),
+		Nodes: []metainfo.Node{
+			{Host: []byte("router.test"), Port: 6881},
+			{Host: []byte("127.0.0.1"), Port: 1},
+		},
+		Info: metainfo.Info{
+			Length:      1,
+			Name:        []byte("a"),
+			NameUTF8:    []byte("\xc3\xa5a"),
+			PieceLength: 16384,
+			Pieces:      []byte("aaaaaaaaaaaaaaaaaaaa"),
+		},
+	}
+
+	if !mi.Eq(&want) {
+		t.Fatalf("got: %s, want: %s", &mi, &want)
+	}
+
+	// Decoding into a used struct should not
+	// keep stale fields.
+	if err := DecodeMetaInfoFile(&mi, debian); err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	if !mi.Eq(&testdata.WantDebianMetaInfo) {
+		t.Fatalf("reused metainfo not equal")
+	}
+}
+
+func TestAOTKeyLikeValues(t *testing.T) {
+	// Values that are the names of keys we decode.
+	const torrent = "d7:comment6:length1:xl8:announcee1:y8:announcee"
+
+	var mi metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&mi, []byte(torrent)); err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	if string(mi.Comment) != "length" || mi.Announce != nil || mi.Info.Length != 0 || len(mi.Extra) != 2 {
+		t.Fatalf("got: %s", &mi)
+	}
+}
+
+func TestAOTMalformedNodes(t *testing.T) {
+	const info = "d6:lengthi1e4:name1:a12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+
+	tests := []struct {
+		nodes string
+		want  []metainfo.Node
+	}{
+		{"ld1:ai1eee", nil},
+		{"l1:ai1ee", nil},
+		{"ll1:ai1eed1:bi2eee", []metainfo.Node{{Host: []byte("a"), Port: 1}}},
+		{"ll1:ai-1eel1:bi65536eel1:ci65535eee", []metainfo.Node{{Host: []byte("c"), Port: 65535}}},
+	}
+
+	for _, tt := range tests {
+		var mi metainfo.MetaInfoPreCompute
+
+		torrent := "d4:info" + info + "5:nodes" + tt.nodes + "e"
+		if err := DecodeMetaInfoFile(&mi, []byte(torrent)); err.IsError() {
+			t.Fatalf("%s: error: %s", tt.nodes, err.Error())
+		}
+
+		if len(mi.Nodes) != len(tt.want) {
+			t.Fatalf("%s: nodes = %v, want %v", tt.nodes, mi.Nodes, tt.want)
+		}
+
+		for i := range tt.want {
+			if string(mi.Nodes[i].Host) != string(tt.want[i].Host) || mi.Nodes[i].Port != tt.want[i].Port {
+				t.Fatalf("%s: nodes = %v, want %v", tt.nodes, mi.Nodes, tt.want)
+			}
+		}
+	}
+}
+
+func TestAOTDebianValidates(t *testing.T) {
+	var mi metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&mi, debian); err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	if fs := metainfo.Validate(&mi); len(fs) != 0 {
+		t.Fatalf("unexpected findings: %v", fs)
+	}
+}
+
+func TestAOTMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"l4:spame",
+		"di1ei2ee",
+		"d3:fooi12",
+	}
+
+	for _, tc := range tests {
+		var mi metainfo.MetaInfoPreCompute
+
+		if err := DecodeMetaInfoFile(&mi, []byte(tc)); !err.IsError() {
+			t.Fatalf("%q: want error", tc)
+		}
+	}
+}
+
 func whereDebug(source []byte, where int) (string, string) {
 	var from, to int
 	to = where + 10
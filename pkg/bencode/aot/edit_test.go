@@ -0,0 +1,92 @@
+package aot
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+)
+
+// TestEditPreservesInfoHash guarantees that editing the
+// top-level keys of a torrent does not change its info hash.
+func TestEditPreservesInfoHash(t *testing.T) {
+	var before, after metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&before, debian); err.IsError() {
+		t.Fatalf("decode original: %s", err)
+	}
+
+	e, err := metainfo.NewEditor(debian)
+	if err != nil {
+		t.Fatalf("NewEditor: %s", err)
+	}
+
+	newAnnounce := []byte("https://tracker.example/announce?passkey=abc")
+
+	for _, err := range []error{
+		e.SetAnnounce(newAnnounce),
+		e.SetComment([]byte("edited")),
+		e.AddWebSeed([]byte("https://mirror.example/debian.iso")),
+		e.Delete("created by"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edited := e.Bytes()
+
+	if err := DecodeMetaInfoFile(&after, edited); err.IsError() {
+		t.Fatalf("decode edited: %s", err)
+	}
+
+	if sha1.Sum(before.InfoDict) != sha1.Sum(after.InfoDict) {
+		t.Fatalf("info hash changed: %x != %x", sha1.Sum(before.InfoDict), sha1.Sum(after.InfoDict))
+	}
+
+	if !before.Info.Eq(&after.Info) {
+		t.Fatalf("info changed")
+	}
+
+	if !bytes.Equal(after.Announce, newAnnounce) || string(after.Comment) != "edited" {
+		t.Fatalf("edits not applied: %s", &after)
+	}
+
+	if len(e.WebSeeds()) != 3 {
+		t.Fatalf("want 3 web seeds, got: %q", e.WebSeeds())
+	}
+}
+
+func TestSignedDebian(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := metainfo.NewEditor(debian)
+	if err != nil {
+		t.Fatalf("NewEditor: %s", err)
+	}
+
+	if err := metainfo.Sign(e, "cdimage.debian.org", priv, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var mi metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&mi, e.Bytes()); err.IsError() {
+		t.Fatalf("decode signed: %s", err)
+	}
+
+	sigs, perr := mi.Signatures()
+	if perr.IsError() || len(sigs) != 1 {
+		t.Fatalf("Signatures: %v, %s", sigs, perr)
+	}
+
+	if err := sigs[0].Verify(mi.InfoDict, pub); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package aot
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+)
+
+func TestRoundTripDebian(t *testing.T) {
+	var mi metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&mi, debian); err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	got := mi.AppendBencode(nil)
+
+	if !bytes.Equal(got, debian) {
+		t.Fatalf("round trip not byte for byte equal")
+	}
+}
+
+func TestRoundTripUnknownKeys(t *testing.T) {
+	const (
+		info = "d5:filesl" +
+			"d5:crc328:deadbeef6:lengthi5e4:pathl1:aee" +
+			"e" +
+			"4:name1:r12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaa" +
+			"7:privatei1e6:source3:abce"
+		torrent = "d8:announce1:u4:info" + info +
+			"1:zl1:xd1:y1:zee" +
+			"1:ai1e" +
+			"e"
+		// Unknown keys come back sorted.
+		want = "d1:ai1e8:announce1:u4:info" + info + "1:zl1:xd1:y1:zeee"
+	)
+
+	var mi metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&mi, []byte(torrent)); err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	if got := metainfo.Lookup(mi.Info.Extra, "private"); string(got) != "i1e" {
+		t.Fatalf("private: got: %s", got)
+	}
+
+	if got := metainfo.Lookup(mi.Info.Files[0].Extra, "crc32"); string(got) != "8:deadbeef" {
+		t.Fatalf("crc32: got: %s", got)
+	}
+
+	got := mi.AppendBencode(nil)
+
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if !bytes.Equal(mi.Info.AppendBencode(nil), mi.InfoDict) {
+		t.Fatalf("info dict does not round trip")
+	}
+}
+
+func TestRoundTripNonCanonicalInfo(t *testing.T) {
+	// Keys out of order, and an integer with a leading zero.
+	const (
+		info    = "d4:name1:r6:lengthi05e12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+		torrent = "d8:announce1:u4:info" + info + "e"
+	)
+
+	var mi metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&mi, []byte(torrent)); err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	var again metainfo.MetaInfoPreCompute
+
+	if err := DecodeMetaInfoFile(&again, mi.AppendBencode(nil)); err.IsError() {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	if sha1.Sum(again.InfoDict) != sha1.Sum([]byte(info)) {
+		t.Fatalf("info hash changed: info dict %s", again.InfoDict)
+	}
+}
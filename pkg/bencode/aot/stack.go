@@ -30,7 +30,7 @@ func (s *stack) pop() {
 func (s *stack) push(t parse.Term) {
 	s.sp++
 
-	if s.sp > maxDepth {
+	if s.sp >= maxDepth {
 		panic("term limit reached")
 	}
 
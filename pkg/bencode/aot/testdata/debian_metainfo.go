@@ -2,6 +2,7 @@ package testdata
 
 import (
 	_ "embed"
+	"time"
 
 	"github.com/joelancaster/bytepour/pkg/metainfo"
 )
@@ -13,9 +14,20 @@ var pieces []byte
 var infodict []byte
 
 var WantDebianMetaInfo = metainfo.MetaInfoPreCompute{
-	Announce: []byte("http://bttracker.debian.org:6969/announce"),
-	Comment:  []byte(`"Debian CD from cdimage.debian.org"`),
-	InfoDict: infodict,
+	Announce:     []byte("http://bttracker.debian.org:6969/announce"),
+	Comment:      []byte(`"Debian CD from cdimage.debian.org"`),
+	CreatedBy:    []byte("mktorrent 1.1"),
+	CreationDate: time.Unix(1707570148, 0).UTC(),
+	InfoDict:     infodict,
+	Extra: []metainfo.RawField{
+		{
+			Key: []byte("url-list"),
+			Value: []byte("l" +
+				"94:https://cdimage.debian.org/cdimage/release/12.5.0/amd64/iso-cd/debian-12.5.0-amd64-netinst.iso" +
+				"94:https://cdimage.debian.org/cdimage/archive/12.5.0/amd64/iso-cd/debian-12.5.0-amd64-netinst.iso" +
+				"e"),
+		},
+	},
 	Info: metainfo.Info{
 		Length:      659554304,
 		Name:        []byte("debian-12.5.0-amd64-netinst.iso"),
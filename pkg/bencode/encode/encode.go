@@ -0,0 +1,61 @@
+// Package encode appends bencoded terms to byte slices.
+//
+// Dictionaries and lists are written by the caller
+// as parse.OpenDict/parse.OpenList, their elements,
+// then parse.EndTerm. The caller is responsible for
+// writing dictionary keys in sorted order.
+package encode
+
+import (
+	"strconv"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+// String appends the bencoding of s to dst.
+func String[T string | []byte](dst []byte, s T) []byte {
+	dst = strconv.AppendInt(dst, int64(len(s)), 10)
+	dst = append(dst, ':')
+
+	return append(dst, s...)
+}
+
+// Int appends the bencoding of n to dst.
+func Int(dst []byte, n int64) []byte {
+	dst = append(dst, parse.OpenInt)
+	dst = strconv.AppendInt(dst, n, 10)
+
+	return append(dst, parse.EndTerm)
+}
+
+// Uint appends the bencoding of n to dst.
+func Uint(dst []byte, n uint64) []byte {
+	dst = append(dst, parse.OpenInt)
+	dst = strconv.AppendUint(dst, n, 10)
+
+	return append(dst, parse.EndTerm)
+}
+
+// KeyString appends a dictionary entry
+// with a string value to dst.
+func KeyString[T string | []byte](dst []byte, key string, value T) []byte {
+	dst = String(dst, key)
+
+	return String(dst, value)
+}
+
+// KeyInt appends a dictionary entry
+// with an integer value to dst.
+func KeyInt(dst []byte, key string, value int64) []byte {
+	dst = String(dst, key)
+
+	return Int(dst, value)
+}
+
+// KeyUint appends a dictionary entry
+// with an unsigned integer value to dst.
+func KeyUint(dst []byte, key string, value uint64) []byte {
+	dst = String(dst, key)
+
+	return Uint(dst, value)
+}
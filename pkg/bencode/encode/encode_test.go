@@ -0,0 +1,41 @@
+package encode
+
+import (
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+func TestEncode(t *testing.T) {
+	var b []byte
+
+	b = append(b, parse.OpenDict)
+	b = KeyString(b, "a", []byte("spam"))
+	b = KeyInt(b, "b", -42)
+	b = KeyUint(b, "c", 18446744073709551615)
+	b = String(b, "d")
+	b = append(b, parse.OpenList)
+	b = String(b, "")
+	b = Int(b, 0)
+	b = append(b, parse.EndTerm)
+	b = append(b, parse.EndTerm)
+
+	const want = "d1:a4:spam1:bi-42e1:ci18446744073709551615e1:dl0:i0eee"
+
+	if string(b) != want {
+		t.Fatalf("got: %s, want: %s", b, want)
+	}
+
+	if n := parse.Skip(b); n != len(b) {
+		t.Fatalf("encoding does not skip as one term: %d != %d", n, len(b))
+	}
+}
+
+func BenchmarkEncodeString(b *testing.B) {
+	buf := make([]byte, 0, 64)
+	s := []byte("http://bttracker.debian.org:6969/announce")
+
+	for i := 0; i < b.N; i++ {
+		buf = String(buf[:0], s)
+	}
+}
@@ -0,0 +1,172 @@
+package parse
+
+// DictIter walks the entries of a bencoded
+// dictionary without decoding their values.
+//
+//	it := parse.NewDictIter(p)
+//	for it.Next() {
+//		key, value := it.Key(), it.Value()
+//	}
+//	if it.Err().IsError() { ... }
+type DictIter struct {
+	p          []byte
+	i          int
+	key, value []byte
+	done       bool
+	err        Error
+}
+
+// NewDictIter constructs a DictIter for the
+// dictionary at the start of p.
+func NewDictIter(p []byte) DictIter {
+	it := DictIter{p: p, i: 1}
+
+	if len(p) == 0 || p[0] != OpenDict {
+		it.err = MakeError(ErrUnexpectedEndOfTerm, 0, Dict)
+	}
+
+	return it
+}
+
+// Next advances to the next entry, reporting
+// whether there was one.
+func (it *DictIter) Next() bool {
+	if it.err.IsError() || it.done {
+		return false
+	}
+
+	if it.i >= len(it.p) {
+		it.err = MakeError(ErrUnexpectedEndOfTerm, uint32(it.i), Dict)
+		return false
+	}
+
+	if it.p[it.i] == EndTerm {
+		it.i++
+		it.done = true
+		return false
+	}
+
+	key, n := ParseString(it.p[it.i:])
+	if n < 0 {
+		it.err = MakeError(ErrUnexpectedEndOfTerm, uint32(it.i), String)
+		return false
+	}
+
+	it.i += n
+
+	n = Skip(it.p[it.i:])
+	if n < 0 {
+		it.err = MakeError(ErrUnexpectedEndOfTerm, uint32(it.i), Dict)
+		return false
+	}
+
+	it.key = key
+	it.value = it.p[it.i : it.i+n]
+	it.i += n
+
+	return true
+}
+
+// Key is the key of the current entry.
+func (it *DictIter) Key() []byte {
+	return it.key
+}
+
+// Value is the raw bencoding of the
+// current entry's value.
+func (it *DictIter) Value() []byte {
+	return it.value
+}
+
+// Offset is the position in the input
+// the iterator has reached.
+func (it *DictIter) Offset() int {
+	return it.i
+}
+
+// Err is the error that stopped iteration, if any.
+func (it *DictIter) Err() Error {
+	return it.err
+}
+
+// ListIter walks the elements of a bencoded
+// list without decoding them.
+type ListIter struct {
+	p     []byte
+	i     int
+	value []byte
+	done  bool
+	err   Error
+}
+
+// NewListIter constructs a ListIter for the
+// list at the start of p.
+func NewListIter(p []byte) ListIter {
+	it := ListIter{p: p, i: 1}
+
+	if len(p) == 0 || p[0] != OpenList {
+		it.err = MakeError(ErrUnexpectedEndOfTerm, 0, List)
+	}
+
+	return it
+}
+
+// Next advances to the next element, reporting
+// whether there was one.
+func (it *ListIter) Next() bool {
+	if it.err.IsError() || it.done {
+		return false
+	}
+
+	if it.i >= len(it.p) {
+		it.err = MakeError(ErrUnexpectedEndOfTerm, uint32(it.i), List)
+		return false
+	}
+
+	if it.p[it.i] == EndTerm {
+		it.i++
+		it.done = true
+		return false
+	}
+
+	n := Skip(it.p[it.i:])
+	if n < 0 {
+		it.err = MakeError(ErrUnexpectedEndOfTerm, uint32(it.i), List)
+		return false
+	}
+
+	it.value = it.p[it.i : it.i+n]
+	it.i += n
+
+	return true
+}
+
+// Value is the raw bencoding of the current element.
+func (it *ListIter) Value() []byte {
+	return it.value
+}
+
+// Err is the error that stopped iteration, if any.
+func (it *ListIter) Err() Error {
+	return it.err
+}
+
+// Bytes decodes the bencoded string at
+// the start of p.
+func Bytes(p []byte) ([]byte, bool) {
+	s, n := ParseString(p)
+
+	return s, n == len(p)
+}
+
+// Integer decodes the bencoded integer at
+// the start of p.
+func Integer(p []byte) (int64, bool) {
+	if len(p) < 3 || p[0] != OpenInt {
+		return 0, false
+	}
+
+	n, j := ParseInt(p[1:])
+
+	return n, j > 0 && p[j] != '-' && j+2 == len(p) && p[j+1] == EndTerm
+}
@@ -0,0 +1,112 @@
+package parse
+
+import "testing"
+
+func TestSkip(t *testing.T) {
+	tests := []struct {
+		name string
+		p    string
+		want int
+	}{
+		{name: "String", p: "4:spamXYZ", want: 6},
+		{name: "Int", p: "i-42eXYZ", want: 5},
+		{name: "List", p: "l4:spami1eeXYZ", want: 11},
+		{name: "Dict", p: "d1:ad1:bl1:ceeeXYZ", want: 15},
+		{name: "EmptyList", p: "leXYZ", want: 2},
+		{name: "TruncatedList", p: "l4:spam", want: -1},
+		{name: "TruncatedString", p: "5:spam", want: -1},
+		{name: "TruncatedInt", p: "i42", want: -1},
+		{name: "EmptyInt", p: "ie", want: -1},
+		{name: "StrayEnd", p: "e", want: -1},
+		{name: "Garbage", p: "x", want: -1},
+		{name: "Empty", p: "", want: -1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Skip([]byte(tc.p)); got != tc.want {
+				t.Fatalf("%s: got: %d, want: %d", tc.name, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDictIter(t *testing.T) {
+	p := []byte("d8:announce3:url4:infod6:lengthi1ee5:nodesl1:aee")
+
+	wantKeys := []string{"announce", "info", "nodes"}
+	wantValues := []string{"3:url", "d6:lengthi1ee", "l1:ae"}
+
+	it := NewDictIter(p)
+
+	var n int
+	for ; it.Next(); n++ {
+		if string(it.Key()) != wantKeys[n] || string(it.Value()) != wantValues[n] {
+			t.Fatalf("entry %d: got: %s=%s, want: %s=%s",
+				n, it.Key(), it.Value(), wantKeys[n], wantValues[n])
+		}
+	}
+
+	if it.Err().IsError() {
+		t.Fatalf("error: %s", it.Err())
+	}
+
+	if n != len(wantKeys) || it.Offset() != len(p) {
+		t.Fatalf("stopped early after %d entries at %d", n, it.Offset())
+	}
+
+	for _, bad := range []string{"", "l1:ae", "d1:a", "d1:ai1e", "di1ei2ee"} {
+		it := NewDictIter([]byte(bad))
+		for it.Next() {
+		}
+
+		if !it.Err().IsError() {
+			t.Fatalf("%q: want error", bad)
+		}
+	}
+}
+
+func TestListIter(t *testing.T) {
+	it := NewListIter([]byte("l1:ai2eli3eee"))
+
+	var got []string
+	for it.Next() {
+		got = append(got, string(it.Value()))
+	}
+
+	if it.Err().IsError() {
+		t.Fatalf("error: %s", it.Err())
+	}
+
+	if len(got) != 3 || got[0] != "1:a" || got[1] != "i2e" || got[2] != "li3ee" {
+		t.Fatalf("got: %q", got)
+	}
+
+	it = NewListIter([]byte("l1:a"))
+	for it.Next() {
+	}
+
+	if !it.Err().IsError() {
+		t.Fatalf("truncated list: want error")
+	}
+}
+
+func TestBytesInteger(t *testing.T) {
+	if s, ok := Bytes([]byte("4:spam")); !ok || string(s) != "spam" {
+		t.Fatalf("Bytes: got: %s, %v", s, ok)
+	}
+
+	if _, ok := Bytes([]byte("4:spamX")); ok {
+		t.Fatalf("Bytes: trailing data accepted")
+	}
+
+	if n, ok := Integer([]byte("i-3e")); !ok || n != -3 {
+		t.Fatalf("Integer: got: %d, %v", n, ok)
+	}
+
+	for _, bad := range []string{"i3", "ie", "i3eX", "3:abc", "i-e"} {
+		if _, ok := Integer([]byte(bad)); ok {
+			t.Fatalf("Integer: %q accepted", bad)
+		}
+	}
+}
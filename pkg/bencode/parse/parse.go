@@ -11,6 +11,10 @@ func ParseInt(p []byte) (int64, int) {
 
 	var magnitude int64 = 1
 
+	if len(p) == 0 {
+		return 0, 0
+	}
+
 	if p[0] == '-' {
 		magnitude = -1
 		i++
@@ -35,14 +39,69 @@ func ParseInt(p []byte) (int64, int) {
 func ParseString(p []byte) ([]byte, int) {
 	slen, i := ParseInt(p)
 
-	if p[i] != stringDelimiter {
+	if i >= len(p) || p[i] != stringDelimiter || slen < 0 {
 		return nil, -1
 	}
 
-	if int(slen) > len(p) {
+	if slen > int64(len(p)-i-1) {
 		return nil, -2
 	}
 
 	idx := int(slen) + i
 	return p[i+1 : idx+1], idx + 1
 }
+
+// Skip yields the length of the term at the
+// start of p, or -1 if the term is malformed
+// or truncated.
+func Skip(p []byte) int {
+	var depth, i int
+
+	for i < len(p) {
+		switch c := p[i]; {
+		case c == OpenList || c == OpenDict:
+			depth++
+			i++
+
+			if depth > maxSkipDepth {
+				return -1
+			}
+
+			continue
+		case c == EndTerm:
+			if depth == 0 {
+				return -1
+			}
+
+			depth--
+			i++
+		case c == OpenInt:
+			_, j := ParseInt(p[i+1:])
+
+			i += j + 1
+			if j == 0 || p[i-1] == '-' || i >= len(p) || p[i] != EndTerm {
+				return -1
+			}
+			i++
+		case c-'0' < 10:
+			_, j := ParseString(p[i:])
+			if j < 0 {
+				return -1
+			}
+
+			i += j
+		default:
+			return -1
+		}
+
+		if depth == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// maxSkipDepth is how deeply nested
+// terms may be before Skip gives up.
+const maxSkipDepth = 256
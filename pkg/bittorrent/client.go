@@ -0,0 +1,199 @@
+package bittorrent
+
+import "strconv"
+
+// Client is the software a peer
+// says it runs, in its peer ID.
+type Client struct {
+	Name string
+	// Dotted components, or empty if
+	// the peer ID has no version.
+	Version string
+}
+
+// String implements the Stringer interface for Client.
+func (c Client) String() string {
+	if c.Version == "" {
+		return c.Name
+	}
+
+	return c.Name + " " + c.Version
+}
+
+// Clients known by their Azureus-style
+// two character codes, "-XX1234-".
+var azureusClients = map[string]string{
+	"AG": "Ares",
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BP": "bytepour",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "rTorrent",
+	"qB": "qBittorrent",
+	"SD": "Thunder",
+	"TL": "Tribler",
+	"TR": "Transmission",
+	"UM": "µTorrent Mac",
+	"UT": "µTorrent",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+}
+
+// Clients known by their Shadow-style
+// one character codes, "X1234-".
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+// ParsePeerId tells which client made id, and its version,
+// from the conventions clients follow: Azureus-style
+// "-XX1234-", Shadow-style "X1234-" and Mainline "M1-2-3--".
+// Clients with an unknown Azureus-style code are named by
+// the code. It reports false if id follows no convention.
+func ParsePeerId(id [20]byte) (Client, bool) {
+	if c, ok := parseAzureus(&id); ok {
+		return c, true
+	}
+
+	if c, ok := parseMainline(&id); ok {
+		return c, true
+	}
+
+	return parseShadow(&id)
+}
+
+func parseAzureus(id *[20]byte) (Client, bool) {
+	if id[0] != '-' || id[7] != '-' || !isAlnum(id[1]) || !isAlnum(id[2]) {
+		return Client{}, false
+	}
+
+	var v Version
+	for i := 0; i < len(v); i++ {
+		c, ok := versionDigit(id[3+i])
+		if !ok {
+			return Client{}, false
+		}
+
+		v[i] = c
+	}
+
+	code := string(id[1:3])
+
+	name, ok := azureusClients[code]
+	if !ok {
+		name = code
+	}
+
+	return Client{Name: name, Version: v.String()}, true
+}
+
+// parseMainline parses "M1-2-3--", whose
+// version numbers may be more than one digit.
+func parseMainline(id *[20]byte) (Client, bool) {
+	if id[0] != 'M' {
+		return Client{}, false
+	}
+
+	var version []byte
+
+	i := 1
+	for part := 0; part < 3; part++ {
+		start := i
+		for i < len(id) && '0' <= id[i] && id[i] <= '9' {
+			i++
+		}
+
+		if i == start || i == len(id) || id[i] != '-' {
+			return Client{}, false
+		}
+
+		if part != 0 {
+			version = append(version, '.')
+		}
+
+		version = append(version, id[start:i]...)
+		i++
+	}
+
+	// The last number ends with "--".
+	if i == len(id) || id[i] != '-' {
+		return Client{}, false
+	}
+
+	return Client{Name: "Mainline", Version: string(version)}, true
+}
+
+// parseShadow parses "X1234-", whose version is up to five
+// characters, 0-9, A-Z, a-z, '.' and '-' for 0 to 63,
+// padded with '-' to at least "---" after it.
+func parseShadow(id *[20]byte) (Client, bool) {
+	name, ok := shadowClients[id[0]]
+	if !ok || id[6] != '-' || id[7] != '-' || id[8] != '-' {
+		return Client{}, false
+	}
+
+	n := 5
+	for n > 0 && id[n] == '-' {
+		n--
+	}
+
+	if n == 0 {
+		return Client{}, false
+	}
+
+	var version []byte
+	for i := 1; i <= n; i++ {
+		var c byte
+
+		switch d := id[i]; {
+		case '0' <= d && d <= '9':
+			c = d - '0'
+		case 'A' <= d && d <= 'Z':
+			c = d - 'A' + 10
+		case 'a' <= d && d <= 'z':
+			c = d - 'a' + 36
+		case d == '.':
+			c = 62
+		case d == '-':
+			c = 63
+		default:
+			return Client{}, false
+		}
+
+		if i != 1 {
+			version = append(version, '.')
+		}
+
+		version = strconv.AppendUint(version, uint64(c), 10)
+	}
+
+	return Client{Name: name, Version: string(version)}, true
+}
+
+// versionDigit decodes a character of an
+// Azureus-style version, 0-9 then A-Z.
+func versionDigit(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'A' <= c && c <= 'Z':
+		return c - 'A' + 10, true
+	default:
+		return 0, false
+	}
+}
+
+func isAlnum(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
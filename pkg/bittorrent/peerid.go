@@ -0,0 +1,70 @@
+// Package bittorrent identifies BitTorrent clients
+// by their peer IDs, including our own.
+package bittorrent
+
+import (
+	"math/rand/v2"
+	"strconv"
+)
+
+// ClientCode is the two characters that identify
+// bytepour in an Azureus-style peer ID.
+const ClientCode = "BP"
+
+// Version is the version of a client, as written in
+// Azureus-style peer IDs: one character for each of
+// four components, 0-9 then A-Z for 10-35.
+type Version [4]byte
+
+// DefaultVersion is the version in IdBP.
+var DefaultVersion = Version{0, 1, 0, 0}
+
+// IdBP is the peer ID we announce with, "-BP0100-"
+// then a random suffix chosen when the program starts.
+var IdBP = NewPeerId(DefaultVersion)
+
+// String implements the Stringer interface for Version,
+// as dotted components without trailing zeros beyond
+// the minor version.
+func (v Version) String() string {
+	n := len(v)
+	for n > 2 && v[n-1] == 0 {
+		n--
+	}
+
+	var b []byte
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			b = append(b, '.')
+		}
+
+		b = strconv.AppendUint(b, uint64(v[i]), 10)
+	}
+
+	return string(b)
+}
+
+// NewPeerId makes an Azureus-style peer ID for bytepour at
+// version: "-BP", the version, '-', then 12 random letters
+// and digits, which need no escaping in announce URLs.
+// Version components above 35 are written as 35.
+func NewPeerId(version Version) [20]byte {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	var id [20]byte
+
+	id[0] = '-'
+	copy(id[1:], ClientCode)
+
+	for i, c := range version {
+		id[3+i] = alphabet[min(c, 35)]
+	}
+
+	id[7] = '-'
+
+	for i := 8; i < len(id); i++ {
+		id[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+
+	return id
+}
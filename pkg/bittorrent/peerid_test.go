@@ -0,0 +1,81 @@
+package bittorrent
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNewPeerId(t *testing.T) {
+	if got := string(IdBP[:8]); got != "-BP0100-" {
+		t.Fatalf("IdBP prefix = %q, want -BP0100-", got)
+	}
+
+	id := NewPeerId(Version{1, 2, 10, 40})
+	if got := string(id[:8]); got != "-BP12AZ-" {
+		t.Fatalf("prefix = %q, want -BP12AZ-", got)
+	}
+
+	// The suffix is random, and never needs escaping.
+	if other := NewPeerId(Version{1, 2, 10, 40}); other == id {
+		t.Fatalf("same peer ID twice: %s", id)
+	}
+
+	if escaped := url.QueryEscape(string(id[:])); escaped != string(id[:]) {
+		t.Fatalf("peer ID %q needs escaping", id)
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	tests := []struct {
+		v    Version
+		want string
+	}{
+		{Version{0, 1, 0, 0}, "0.1"},
+		{Version{0, 0, 0, 0}, "0.0"},
+		{Version{2, 9, 4, 0}, "2.9.4"},
+		{Version{1, 0, 0, 12}, "1.0.0.12"},
+	}
+
+	for _, test := range tests {
+		if got := test.v.String(); got != test.want {
+			t.Fatalf("%v.String() = %q, want %q", [4]byte(test.v), got, test.want)
+		}
+	}
+}
+
+func TestParsePeerId(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+		ok   bool
+	}{
+		{"-BP0100-abcdefghijkl", "bytepour 0.1", true},
+		{"-TR2940-k1l2m3n4o5p6", "Transmission 2.9.4", true},
+		{"-qB4250-xxxxxxxxxxxx", "qBittorrent 4.2.5", true},
+		{"-lt0D60-xxxxxxxxxxxx", "rTorrent 0.13.6", true},
+		{"-ZZ1000-xxxxxxxxxxxx", "ZZ 1.0", true},
+		{"M4-3-6--xxxxxxxxxxxx", "Mainline 4.3.6", true},
+		{"M7-10-2--xxxxxxxxxxx", "Mainline 7.10.2", true},
+		{"T03I-----xxxxxxxxxxx", "BitTornado 0.3.18", true},
+		{"S58B-----xxxxxxxxxxx", "Shadow 5.8.11", true},
+		// Not following any convention.
+		{"-TR29!0-xxxxxxxxxxxx", "", false},
+		{"M4-3-6-xxxxxxxxxxxxx", "", false},
+		{"T---------xxxxxxxxxx", "", false},
+		{"X03I-----xxxxxxxxxxx", "", false},
+		{"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", "", false},
+	}
+
+	for _, test := range tests {
+		c, ok := ParsePeerId([20]byte([]byte(test.id)))
+		if ok != test.ok || ok && c.String() != test.want {
+			t.Fatalf("ParsePeerId(%q) = %q, %v, want %q, %v", test.id, c, ok, test.want, test.ok)
+		}
+	}
+}
+
+func BenchmarkNewPeerId(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		NewPeerId(DefaultVersion)
+	}
+}
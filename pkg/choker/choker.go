@@ -0,0 +1,448 @@
+// Package choker decides which peers we upload to: tit-for-tat,
+// the peers that give us the most, and an optimistic unchoke to
+// find better ones.
+package choker
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+	"github.com/joelancaster/bytepour/pkg/peer"
+)
+
+const (
+	defaultSlots              = 3
+	defaultOptimisticSlots    = 1
+	defaultInterval           = 10 * time.Second
+	defaultOptimisticInterval = 30 * time.Second
+
+	// Peers connected for less than this are
+	// newOdds times as likely to be unchoked
+	// optimistically, to get them started.
+	newPeer = time.Minute
+	newOdds = 3
+)
+
+// Peer is a connection the choker may choke and unchoke.
+// *peer.Conn is a Peer.
+type Peer interface {
+	// PeerInterested reports whether the
+	// peer wants to download from us.
+	PeerInterested() bool
+	// Snubbed reports whether the peer has gone
+	// too long without sending what we requested.
+	Snubbed() bool
+	// Stats is the total block data
+	// exchanged, to measure rates by.
+	Stats() peer.Stats
+	Choke() error
+	Unchoke() error
+}
+
+// Choker chokes and unchokes peers: every Interval, the
+// interested peers with the best rates are unchoked, their
+// download rate to us or, when seeding, our upload rate to
+// them; every OptimisticInterval, other interested peers are
+// unchoked at random, to find ones better still. Peers start
+// choked, as connections do.
+type Choker struct {
+	// The number of peers unchoked for their rates, 3 if zero.
+	Slots int
+	// The number of peers unchoked at random, 1 if zero.
+	OptimisticSlots int
+	// How often to choose by rates, 10 seconds if zero.
+	Interval time.Duration
+	// How often to choose at random, 30 seconds if zero.
+	OptimisticInterval time.Duration
+	// If nil, the system clock.
+	Clock clock.Clock
+	// If nil, the global random source.
+	Rand *rand.Rand
+
+	// Held by Rechoke and Fill from choosing to choking and
+	// unchoking, so each is applied before the next chooses.
+	apply sync.Mutex
+
+	mu    sync.Mutex
+	peers map[Peer]*state
+	// The peers, in the order added.
+	order          []Peer
+	seeding        bool
+	lastOptimistic time.Time
+}
+
+// state is what the choker knows of a peer.
+type state struct {
+	added time.Time
+	// The stats at the last rechoke, and when.
+	last   peer.Stats
+	lastAt time.Time
+	// Bytes a second, over the last interval.
+	download, upload float64
+	unchoked         bool
+	optimistic       bool
+}
+
+// Add adds p, a choked peer, to be considered.
+func (c *Choker) Add(p Peer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.peers == nil {
+		c.peers = make(map[Peer]*state)
+	}
+
+	if c.peers[p] != nil {
+		return
+	}
+
+	now := c.clock().Now()
+	c.peers[p] = &state{added: now, last: p.Stats(), lastAt: now}
+	c.order = append(c.order, p)
+}
+
+// Remove stops considering p, as it disconnected. Its
+// slot is filled at the next rechoke, or by Fill.
+func (c *Choker) Remove(p Peer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.peers[p] == nil {
+		return
+	}
+
+	delete(c.peers, p)
+
+	for k, q := range c.order {
+		if q == p {
+			c.order = append(c.order[:k], c.order[k+1:]...)
+			break
+		}
+	}
+}
+
+// SetSeeding sets whether we are seeding, so
+// peers are chosen by our upload rate to them.
+func (c *Choker) SetSeeding(seeding bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seeding = seeding
+}
+
+// Unchoked reports whether p is unchoked,
+// and whether optimistically.
+func (c *Choker) Unchoked(p Peer) (unchoked, optimistic bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.peers[p]
+	if st == nil {
+		return false, false
+	}
+
+	return st.unchoked, st.optimistic
+}
+
+// Rates is how fast we download from p and upload to it,
+// in bytes a second, as measured at the last rechoke.
+func (c *Choker) Rates(p Peer) (download, upload float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.peers[p]
+	if st == nil {
+		return 0, 0
+	}
+
+	return st.download, st.upload
+}
+
+// Run rechokes every Interval until ctx is done.
+func (c *Choker) Run(ctx context.Context) error {
+	clk := c.clock()
+
+	for {
+		c.Rechoke()
+
+		timer := clk.NewTimer(orDefault(c.Interval, defaultInterval))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// Rechoke measures rates since the last rechoke and chooses
+// whom to unchoke, as Run does every Interval. Optimistic
+// unchokes are chosen anew once OptimisticInterval has
+// passed, and empty optimistic slots are filled. To fill a
+// free slot between rechokes, use Fill, which leaves the
+// rates' measure alone.
+func (c *Choker) Rechoke() {
+	c.apply.Lock()
+	defer c.apply.Unlock()
+
+	c.mu.Lock()
+
+	now := c.clock().Now()
+
+	peers := make([]Peer, len(c.order))
+	for k, p := range c.order {
+		c.measure(p, c.peers[p], now)
+		peers[k] = p
+	}
+
+	// By rate, fastest first; peers are kept in
+	// place of those equally fast, at random.
+	c.shuffle(peers)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return c.rate(c.peers[peers[i]]) > c.rate(c.peers[peers[j]])
+	})
+
+	unchoke := make(map[Peer]bool)
+
+	slots := orDefault(c.Slots, defaultSlots)
+	for _, p := range peers {
+		if len(unchoke) == slots {
+			break
+		}
+
+		// Snubbing peers give us nothing, seeding or not.
+		if p.PeerInterested() && (c.seeding || !p.Snubbed()) {
+			unchoke[p] = true
+		}
+	}
+
+	rotate := now.Sub(c.lastOptimistic) >= orDefault(c.OptimisticInterval, defaultOptimisticInterval)
+	if rotate {
+		c.lastOptimistic = now
+	}
+
+	c.chooseOptimistic(peers, unchoke, rotate, now)
+
+	var choke, unchoked []Peer
+	for _, p := range peers {
+		st := c.peers[p]
+
+		want := unchoke[p]
+		switch {
+		case want && !st.unchoked:
+			unchoked = append(unchoked, p)
+		case !want && st.unchoked:
+			choke = append(choke, p)
+		}
+
+		st.unchoked = want
+	}
+
+	c.mu.Unlock()
+
+	// Choke first, so uploads never
+	// run over the number of slots.
+	for _, p := range choke {
+		p.Choke()
+	}
+
+	for _, p := range unchoked {
+		p.Unchoke()
+	}
+}
+
+// Fill unchokes interested peers into free slots, as when a
+// peer becomes interested: by the rates measured at the last
+// rechoke, then at random for optimistic slots. No rates are
+// measured and no peer is choked, so peers can't have every
+// one ranked anew at will.
+func (c *Choker) Fill() {
+	c.apply.Lock()
+	defer c.apply.Unlock()
+
+	c.mu.Lock()
+
+	var regular, optimistic int
+	var candidates []Peer
+
+	for _, p := range c.order {
+		st := c.peers[p]
+
+		switch {
+		case st.optimistic:
+			optimistic++
+		case st.unchoked:
+			regular++
+		case p.PeerInterested():
+			candidates = append(candidates, p)
+		}
+	}
+
+	c.shuffle(candidates)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return c.rate(c.peers[candidates[i]]) > c.rate(c.peers[candidates[j]])
+	})
+
+	var unchoked, left []Peer
+
+	slots := orDefault(c.Slots, defaultSlots)
+	for _, p := range candidates {
+		if regular < slots && (c.seeding || !p.Snubbed()) {
+			regular++
+			c.peers[p].unchoked = true
+			unchoked = append(unchoked, p)
+
+			continue
+		}
+
+		left = append(left, p)
+	}
+
+	now := c.clock().Now()
+
+	slots = orDefault(c.OptimisticSlots, defaultOptimisticSlots)
+	for ; optimistic < slots && len(left) != 0; optimistic++ {
+		k := c.pickNew(left, now)
+		p := left[k]
+
+		c.peers[p].unchoked = true
+		c.peers[p].optimistic = true
+		unchoked = append(unchoked, p)
+
+		left = append(left[:k], left[k+1:]...)
+	}
+
+	c.mu.Unlock()
+
+	for _, p := range unchoked {
+		p.Unchoke()
+	}
+}
+
+// chooseOptimistic adds to unchoke the optimistic unchokes:
+// those chosen before, if still interested, unless rotating,
+// then new ones at random from the interested peers left.
+func (c *Choker) chooseOptimistic(peers []Peer, unchoke map[Peer]bool, rotate bool, now time.Time) {
+	slots := orDefault(c.OptimisticSlots, defaultOptimisticSlots)
+
+	var kept int
+	for _, p := range peers {
+		st := c.peers[p]
+
+		if st.optimistic && !rotate && !unchoke[p] && p.PeerInterested() && kept < slots {
+			unchoke[p] = true
+			kept++
+			continue
+		}
+
+		st.optimistic = false
+	}
+
+	var candidates []Peer
+	for _, p := range peers {
+		if !unchoke[p] && p.PeerInterested() {
+			candidates = append(candidates, p)
+		}
+	}
+
+	for ; kept < slots && len(candidates) != 0; kept++ {
+		k := c.pickNew(candidates, now)
+
+		unchoke[candidates[k]] = true
+		c.peers[candidates[k]].optimistic = true
+
+		candidates = append(candidates[:k], candidates[k+1:]...)
+	}
+}
+
+// pickNew picks one of candidates at random, returning its
+// index, weighted so new peers are more likely.
+func (c *Choker) pickNew(candidates []Peer, now time.Time) int {
+	var total int
+	for _, p := range candidates {
+		total += c.odds(p, now)
+	}
+
+	pick := c.intN(total)
+
+	for k, p := range candidates {
+		odds := c.odds(p, now)
+		if pick < odds {
+			return k
+		}
+
+		pick -= odds
+	}
+
+	return len(candidates) - 1
+}
+
+// measure updates the rates of p since the last rechoke.
+func (c *Choker) measure(p Peer, st *state, now time.Time) {
+	stats := p.Stats()
+
+	if elapsed := now.Sub(st.lastAt).Seconds(); elapsed > 0 {
+		st.download = float64(stats.Downloaded-st.last.Downloaded) / elapsed
+		st.upload = float64(stats.Uploaded-st.last.Uploaded) / elapsed
+	}
+
+	st.last = stats
+	st.lastAt = now
+}
+
+func (c *Choker) rate(st *state) float64 {
+	if c.seeding {
+		return st.upload
+	}
+
+	return st.download
+}
+
+func (c *Choker) odds(p Peer, now time.Time) int {
+	if now.Sub(c.peers[p].added) < newPeer {
+		return newOdds
+	}
+
+	return 1
+}
+
+func (c *Choker) clock() clock.Clock {
+	if c.Clock == nil {
+		return clock.System()
+	}
+
+	return c.Clock
+}
+
+func (c *Choker) shuffle(peers []Peer) {
+	swap := func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	}
+
+	if c.Rand != nil {
+		c.Rand.Shuffle(len(peers), swap)
+	} else {
+		rand.Shuffle(len(peers), swap)
+	}
+}
+
+func (c *Choker) intN(n int) int {
+	if c.Rand != nil {
+		return c.Rand.IntN(n)
+	}
+
+	return rand.IntN(n)
+}
+
+func orDefault[T time.Duration | int](v, def T) T {
+	if v == 0 {
+		return def
+	}
+
+	return v
+}
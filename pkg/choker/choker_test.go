@@ -0,0 +1,373 @@
+package choker
+
+import (
+	"context"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+	"github.com/joelancaster/bytepour/pkg/peer"
+)
+
+type fakePeer struct {
+	mu         sync.Mutex
+	name       string
+	interested bool
+	snubbed    bool
+	stats      peer.Stats
+	unchoked   bool
+}
+
+func (p *fakePeer) PeerInterested() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.interested
+}
+
+func (p *fakePeer) Snubbed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.snubbed
+}
+
+func (p *fakePeer) Stats() peer.Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats
+}
+
+func (p *fakePeer) Choke() error {
+	// Let others run, as sending would.
+	runtime.Gosched()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.unchoked = false
+
+	return nil
+}
+
+func (p *fakePeer) Unchoke() error {
+	// Let others run, as sending would.
+	runtime.Gosched()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.unchoked = true
+
+	return nil
+}
+
+// transfer adds a second's worth of
+// traffic at the given rates.
+func (p *fakePeer) transfer(download, upload uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stats.Downloaded += download
+	p.stats.Uploaded += upload
+}
+
+func unchokedPeers(peers []*fakePeer) string {
+	var s string
+	for _, p := range peers {
+		p.mu.Lock()
+		if p.unchoked {
+			s += p.name
+		}
+		p.mu.Unlock()
+	}
+
+	return s
+}
+
+func newPeers(names string) []*fakePeer {
+	peers := make([]*fakePeer, len(names))
+	for i := range names {
+		peers[i] = &fakePeer{name: names[i : i+1], interested: true}
+	}
+
+	return peers
+}
+
+func TestChoker(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_000_000, 0))
+	c := &Choker{Slots: 2, Clock: clk, Rand: rand.New(rand.NewPCG(1, 2))}
+
+	peers := newPeers("abcde")
+	for _, p := range peers {
+		c.Add(p)
+	}
+
+	// Nothing measured yet: two at random, and one optimistically.
+	c.Rechoke()
+
+	if got := unchokedPeers(peers); len(got) != 3 {
+		t.Fatalf("unchoked %q, want three", got)
+	}
+
+	// d and e give us the most; c is snubbing us.
+	clk.Advance(10 * time.Second)
+	peers[2].transfer(1e6, 0)
+	peers[2].snubbed = true
+	peers[3].transfer(500e3, 0)
+	peers[4].transfer(200e3, 0)
+	c.Rechoke()
+
+	if down, _ := c.Rates(peers[3]); down != 50e3 {
+		t.Fatalf("download rate = %v, want 50000", down)
+	}
+
+	got := unchokedPeers(peers)
+	if len(got) != 3 || got[1:] != "de" {
+		t.Fatalf("unchoked %q, want d, e and one optimistically", got)
+	}
+
+	var optimistic *fakePeer
+	for _, p := range peers {
+		if _, o := c.Unchoked(p); o {
+			optimistic = p
+		}
+	}
+
+	if optimistic == nil || (optimistic != peers[0] && optimistic != peers[1] && optimistic != peers[2]) {
+		t.Fatalf("optimistic unchoke = %v", optimistic)
+	}
+
+	// The optimistic unchoke lasts 30 seconds.
+	clk.Advance(10 * time.Second)
+	c.Rechoke()
+
+	if _, o := c.Unchoked(optimistic); !o {
+		t.Fatal("optimistic unchoke rotated after 20 seconds")
+	}
+
+	// Peers not interested are choked.
+	clk.Advance(10 * time.Second)
+	peers[4].mu.Lock()
+	peers[4].interested = false
+	peers[4].mu.Unlock()
+
+	// d leaving frees a slot, which c,
+	// snubbing, is only unchoked in at random.
+	c.Remove(peers[3])
+	c.Rechoke()
+
+	rest := []*fakePeer{peers[0], peers[1], peers[2], peers[4]}
+	if got := unchokedPeers(rest); got != "abc" {
+		t.Fatalf("unchoked %q, want abc", got)
+	}
+
+	if _, o := c.Unchoked(peers[2]); !o {
+		t.Fatal("snubbing peer unchoked but not optimistically")
+	}
+}
+
+func TestChokerSeeding(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_000_000, 0))
+	c := &Choker{Slots: 1, OptimisticSlots: 1, Clock: clk}
+	c.SetSeeding(true)
+
+	peers := newPeers("abc")
+	for _, p := range peers {
+		c.Add(p)
+	}
+
+	clk.Advance(10 * time.Second)
+	peers[0].transfer(1e6, 0)
+	peers[1].transfer(0, 1e6)
+	peers[1].snubbed = true
+	c.Rechoke()
+
+	if u, o := c.Unchoked(peers[1]); !u || o {
+		t.Fatalf("peer we upload to fastest unchoked = %v, optimistically = %v", u, o)
+	}
+
+	if got := unchokedPeers(peers); len(got) != 2 {
+		t.Fatalf("unchoked %q, want two", got)
+	}
+}
+
+func TestChokerNewPeers(t *testing.T) {
+	var chosen int
+
+	for seed := uint64(0); seed < 300; seed++ {
+		clk := clock.NewFake(time.Unix(1_000_000, 0))
+		c := &Choker{Slots: 1, Clock: clk, Rand: rand.New(rand.NewPCG(seed, 0))}
+
+		// A fast peer, for the one slot, and eight old ones.
+		old := newPeers("abcdefghi")
+		for _, p := range old {
+			c.Add(p)
+		}
+
+		clk.Advance(time.Hour)
+
+		fresh := &fakePeer{name: "z", interested: true}
+		c.Add(fresh)
+
+		clk.Advance(time.Second)
+		old[0].transfer(1e6, 0)
+		c.Rechoke()
+
+		if _, o := c.Unchoked(fresh); o {
+			chosen++
+		}
+	}
+
+	// Three chances in eleven, not one in nine.
+	if chosen < 60 || chosen > 110 {
+		t.Fatalf("new peer chosen %d times of 300", chosen)
+	}
+}
+
+func TestChokerFill(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_000_000, 0))
+	c := &Choker{Slots: 2, Clock: clk}
+
+	peers := newPeers("abcd")
+	peers[2].interested = false
+	peers[3].interested = false
+
+	for _, p := range peers {
+		c.Add(p)
+	}
+
+	clk.Advance(10 * time.Second)
+	peers[0].transfer(500e3, 0)
+	c.Rechoke()
+
+	if got := unchokedPeers(peers); got != "ab" {
+		t.Fatalf("unchoked %q, want ab", got)
+	}
+
+	// c and d take up the free optimistic slot and b's,
+	// without what a sent since being measured.
+	clk.Advance(time.Second)
+	peers[0].transfer(1e6, 0)
+	c.Remove(peers[1])
+
+	for _, p := range peers[2:] {
+		p.mu.Lock()
+		p.interested = true
+		p.mu.Unlock()
+	}
+
+	c.Fill()
+
+	rest := []*fakePeer{peers[0], peers[2], peers[3]}
+	if got := unchokedPeers(rest); got != "acd" {
+		t.Fatalf("unchoked %q, want acd", got)
+	}
+
+	if down, _ := c.Rates(peers[0]); down != 50e3 {
+		t.Fatalf("download rate = %v, want 50000", down)
+	}
+
+	// Filled, there is no slot left.
+	e := &fakePeer{name: "e", interested: true}
+	c.Add(e)
+	c.Fill()
+
+	if u, _ := c.Unchoked(e); u {
+		t.Fatal("peer unchoked with no slot free")
+	}
+}
+
+func TestChokerConcurrent(t *testing.T) {
+	c := &Choker{Slots: 2, Clock: clock.NewFake(time.Unix(1_000_000, 0))}
+
+	peers := newPeers("abcdefgh")
+	for _, p := range peers {
+		c.Add(p)
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+
+	// Rechokes and fills are applied in turn, so the
+	// peers end up as the choker has them.
+	for i := 0; i < 1000; i++ {
+		for _, p := range peers {
+			p.mu.Lock()
+			p.interested = r.IntN(2) == 0
+			p.mu.Unlock()
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			c.Rechoke()
+		}()
+
+		go func() {
+			defer wg.Done()
+			c.Fill()
+		}()
+
+		wg.Wait()
+
+		if got := unchokedPeers(peers); len(got) > 3 {
+			t.Fatalf("unchoked %q, more than 3", got)
+		}
+
+		for _, p := range peers {
+			u, _ := c.Unchoked(p)
+
+			p.mu.Lock()
+			unchoked := p.unchoked
+			p.mu.Unlock()
+
+			if u != unchoked {
+				t.Fatalf("round %d: %s unchoked = %v, choker has %v", i, p.name, unchoked, u)
+			}
+		}
+	}
+}
+
+func TestChokerRun(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_000_000, 0))
+	c := &Choker{Clock: clk}
+
+	p := &fakePeer{interested: true}
+	c.Add(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	clk.BlockUntil(1)
+
+	if u, _ := c.Unchoked(p); !u {
+		t.Fatal("not unchoked when run")
+	}
+
+	p.mu.Lock()
+	p.interested = false
+	p.mu.Unlock()
+
+	clk.Advance(10 * time.Second)
+	clk.BlockUntil(1)
+
+	if u, _ := c.Unchoked(p); u {
+		t.Fatal("not choked after the interval")
+	}
+
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Run = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,575 @@
+// Package client runs many torrents at once, sharing a listening
+// port, a peer ID, and limits on connections and on upload and
+// download rates, with a queue of those waiting their turn to
+// download or seed.
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/bittorrent"
+	"github.com/joelancaster/bytepour/pkg/clock"
+	"github.com/joelancaster/bytepour/pkg/limit"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+	"github.com/joelancaster/bytepour/pkg/session"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+const (
+	defaultListenAddr   = ":6881"
+	defaultMaxConns     = 200
+	defaultMaxDownloads = 3
+	defaultMaxSeeds     = 5
+
+	// How long a peer has to send its handshake.
+	handshakeTimeout = 10 * time.Second
+)
+
+var (
+	// ErrDuplicate is returned when adding a
+	// torrent that has already been added.
+	ErrDuplicate = errors.New("client: torrent already added")
+	// ErrUnknownTorrent is returned for an
+	// info hash of no torrent added.
+	ErrUnknownTorrent = errors.New("client: unknown torrent")
+	// ErrClosed is returned when running a
+	// client that has already been run.
+	ErrClosed = errors.New("client: closed")
+)
+
+// Config configures a client. The zero value is the defaults.
+type Config struct {
+	// The address peers connect to, ":6881" if empty.
+	ListenAddr string
+	// Our peer ID, a new one if zero.
+	PeerId [20]byte
+	// The most peers connected to across every
+	// torrent, 200 if zero, unlimited if negative.
+	MaxConns int
+	// The most peers connected to for each
+	// torrent, that of session.Options if zero.
+	MaxPeers int
+	// Bytes a second of block data sent, and
+	// received, across every torrent, unlimited if zero.
+	UploadRate, DownloadRate int
+	// The most torrents downloading at once, 3 if zero,
+	// and seeding at once, 5 if zero. Unlimited if negative.
+	MaxDownloads, MaxSeeds int
+	// If set, each torrent's resume data is kept in a file
+	// in it, named by info hash, taken up when the torrent
+	// is added and saved whenever its session stops.
+	ResumeDir string
+	// Dials peers. If nil, a net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Announces to trackers. If nil,
+	// a tracker.SchemeAnnouncer.
+	Announcer tracker.Announcer
+	// If nil, the system clock.
+	Clock clock.Clock
+}
+
+// Status is how a torrent of a client is getting on.
+type Status struct {
+	InfoHash [20]byte
+	Name     string
+	// Whether the torrent is paused, by Pause
+	// or by its session failing.
+	Paused bool
+	// Whether the torrent waits its turn to run.
+	Queued   bool
+	Progress session.Progress
+}
+
+// Client runs torrents, in the order added, as many
+// downloading and seeding at once as its Config allows.
+// Its methods are safe for concurrent use.
+type Client struct {
+	cfg    Config
+	l      net.Listener
+	peerId [20]byte
+
+	conns            *limit.Slots
+	upload, download *limit.Rate
+
+	// Wakes the scheduler.
+	wakeUp chan struct{}
+	// The runs of sessions, and
+	// connections being handed over.
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	ran      bool
+	torrents map[[20]byte]*torrent
+	// The torrents, in the order added.
+	order []*torrent
+}
+
+// torrent is a session of a client.
+type torrent struct {
+	sess   *session.Session
+	paused bool
+	// Whether the session's Run is yet to return.
+	running bool
+	// Stops the run, nil once asked to stop.
+	cancel context.CancelFunc
+	// Closed when the run returns.
+	done chan struct{}
+}
+
+// New makes a client listening on cfg.ListenAddr.
+func New(cfg Config) (*Client, error) {
+	addr := cfg.ListenAddr
+	if addr == "" {
+		addr = defaultListenAddr
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		cfg:      cfg,
+		l:        l,
+		peerId:   cfg.PeerId,
+		upload:   limit.NewRate(cfg.UploadRate, cfg.Clock),
+		download: limit.NewRate(cfg.DownloadRate, cfg.Clock),
+		wakeUp:   make(chan struct{}, 1),
+		torrents: make(map[[20]byte]*torrent),
+	}
+
+	if c.peerId == [20]byte{} {
+		c.peerId = bittorrent.NewPeerId(bittorrent.DefaultVersion)
+	}
+
+	switch {
+	case cfg.MaxConns == 0:
+		c.conns = limit.NewSlots(defaultMaxConns)
+	case cfg.MaxConns > 0:
+		c.conns = limit.NewSlots(cfg.MaxConns)
+	}
+
+	return c, nil
+}
+
+// Port is the TCP port peers connect to.
+func (c *Client) Port() uint16 {
+	return c.l.Addr().(*net.TCPAddr).AddrPort().Port()
+}
+
+// SetRates changes the bytes a second of block data sent,
+// and received, across every torrent, unlimited if zero.
+func (c *Client) SetRates(upload, download int) {
+	c.upload.SetLimit(upload)
+	c.download.SetLimit(download)
+}
+
+// options are those of every session.
+func (c *Client) options() session.Options {
+	return session.Options{
+		PeerId:    c.peerId,
+		Port:      c.Port(),
+		MaxPeers:  c.cfg.MaxPeers,
+		Conns:     c.conns,
+		Upload:    c.upload,
+		Download:  c.download,
+		Dial:      c.cfg.Dial,
+		Announcer: c.cfg.Announcer,
+		Clock:     c.cfg.Clock,
+	}
+}
+
+// AddTorrent adds the torrent mi, to be stored under
+// dir, queued to run.
+func (c *Client) AddTorrent(mi *metainfo.MetaInfoPreCompute, dir string) (*session.Session, error) {
+	s, err := session.Open(mi, dir, c.options())
+	if err != nil {
+		return nil, err
+	}
+
+	return s, c.add(s)
+}
+
+// AddMagnet adds the torrent of the magnet link uri,
+// to be stored under dir, queued to run.
+func (c *Client) AddMagnet(uri, dir string) (*session.Session, error) {
+	s, err := session.OpenMagnet(uri, dir, c.options())
+	if err != nil {
+		return nil, err
+	}
+
+	return s, c.add(s)
+}
+
+func (c *Client) add(s *session.Session) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.torrents[s.InfoHash()]; ok {
+		return ErrDuplicate
+	}
+
+	if path := c.resumePath(s); path != "" {
+		// Without it, what's stored is checked.
+		s.LoadResume(path)
+	}
+
+	t := &torrent{sess: s}
+	c.torrents[s.InfoHash()] = t
+	c.order = append(c.order, t)
+
+	c.wake()
+
+	return nil
+}
+
+// Remove stops the torrent of infoHash and forgets it,
+// returning once its session has stopped. What it stored
+// is left in place, but not its resume data.
+func (c *Client) Remove(infoHash [20]byte) error {
+	c.mu.Lock()
+
+	t, ok := c.torrents[infoHash]
+	if !ok {
+		c.mu.Unlock()
+		return ErrUnknownTorrent
+	}
+
+	delete(c.torrents, infoHash)
+
+	for i, o := range c.order {
+		if o == t {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+
+	t.stop()
+
+	done := t.done
+	running := t.running
+
+	c.wake()
+	c.mu.Unlock()
+
+	if running {
+		<-done
+	}
+
+	if path := c.resumePath(t.sess); path != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pause stops the torrent of infoHash, and
+// keeps it stopped until resumed.
+func (c *Client) Pause(infoHash [20]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.torrents[infoHash]
+	if !ok {
+		return ErrUnknownTorrent
+	}
+
+	t.paused = true
+	t.stop()
+
+	c.wake()
+
+	return nil
+}
+
+// Resume queues the torrent of infoHash to run again.
+func (c *Client) Resume(infoHash [20]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.torrents[infoHash]
+	if !ok {
+		return ErrUnknownTorrent
+	}
+
+	t.paused = false
+
+	c.wake()
+
+	return nil
+}
+
+// Session is the session of the torrent of
+// infoHash, nil if there is none.
+func (c *Client) Session(infoHash [20]byte) *session.Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.torrents[infoHash]; ok {
+		return t.sess
+	}
+
+	return nil
+}
+
+// Torrents is the status of each torrent, in the order added.
+func (c *Client) Torrents() []Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	all := make([]Status, 0, len(c.order))
+
+	for _, t := range c.order {
+		all = append(all, Status{
+			InfoHash: t.sess.InfoHash(),
+			Name:     t.sess.Name(),
+			Paused:   t.paused,
+			Queued:   !t.paused && t.cancel == nil,
+			Progress: t.sess.Progress(),
+		})
+	}
+
+	return all
+}
+
+// Run accepts peers' connections for the torrents, and runs
+// the torrents in turn, until ctx is done. It returns once
+// every session has stopped, closing the listener. A client
+// is run once.
+func (c *Client) Run(ctx context.Context) error {
+	c.mu.Lock()
+
+	if c.ran {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+
+	c.ran = true
+	c.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		c.l.Close()
+	})
+
+	defer stop()
+
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+		c.accept()
+	}()
+
+	for {
+		c.schedule(ctx)
+
+		select {
+		case <-ctx.Done():
+			c.l.Close()
+			c.wg.Wait()
+
+			return ctx.Err()
+		case <-c.wakeUp:
+		}
+	}
+}
+
+// accept hands peers' connections to the
+// sessions of their torrents.
+func (c *Client) accept() {
+	for {
+		conn, err := c.l.Accept()
+		if err != nil {
+			return
+		}
+
+		c.wg.Add(1)
+
+		go func() {
+			defer c.wg.Done()
+			c.handOver(conn)
+		}()
+	}
+}
+
+// handOver reads the handshake from conn, and
+// gives it to the session of its torrent.
+func (c *Client) handOver(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	var theirs peer.Handshake
+	if err := peer.ReadHandshake(conn, &theirs); err != nil {
+		conn.Close()
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	s := c.Session(theirs.InfoHash)
+	if s == nil {
+		conn.Close()
+		return
+	}
+
+	// Closed if not taken, as when queued.
+	s.Accept(conn, &theirs)
+}
+
+// resumePath is the path of the resume data
+// of s, empty if it isn't kept.
+func (c *Client) resumePath(s *session.Session) string {
+	if c.cfg.ResumeDir == "" {
+		return ""
+	}
+
+	ih := s.InfoHash()
+
+	return filepath.Join(c.cfg.ResumeDir, hex.EncodeToString(ih[:])+".resume")
+}
+
+func (c *Client) wake() {
+	select {
+	case c.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// schedule stops the torrents paused, or beyond the limits,
+// and starts those queued, in the order added, as far as the
+// limits allow. Those running keep their place ahead of those
+// queued, so long as they keep within the limits.
+func (c *Client) schedule(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	var downloads, seeds int
+	var queued []*torrent
+
+	for _, t := range c.order {
+		switch {
+		case t.paused:
+			t.stop()
+		case t.cancel != nil:
+			if !c.admit(t, &downloads, &seeds) {
+				t.stop()
+			}
+		case !t.running:
+			queued = append(queued, t)
+		}
+	}
+
+	for _, t := range queued {
+		if c.admit(t, &downloads, &seeds) {
+			c.start(ctx, t)
+		}
+	}
+}
+
+// admit counts t as downloading or seeding, if the
+// limit of those doing so is not reached.
+func (c *Client) admit(t *torrent, downloads, seeds *int) bool {
+	n, most := downloads, orDefault(c.cfg.MaxDownloads, defaultMaxDownloads)
+
+	if p := t.sess.Progress(); p.Pieces > 0 && p.Left == 0 {
+		n, most = seeds, orDefault(c.cfg.MaxSeeds, defaultMaxSeeds)
+	}
+
+	if most >= 0 && *n >= most {
+		return false
+	}
+
+	*n++
+
+	return true
+}
+
+func orDefault(n, def int) int {
+	if n == 0 {
+		return def
+	}
+
+	return n
+}
+
+// start runs the session of t, until stopped or it fails,
+// waking the scheduler whenever its state changes and when
+// it returns. c.mu must be held.
+func (c *Client) start(ctx context.Context, t *torrent) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	updates, unsubscribe := t.sess.Subscribe()
+
+	t.running = true
+	t.cancel = cancel
+	t.done = done
+
+	c.wg.Add(2)
+
+	go func() {
+		defer c.wg.Done()
+
+		state := session.StateStopped
+
+		for {
+			select {
+			case p := <-updates:
+				if p.State != state {
+					state = p.State
+					c.wake()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer c.wg.Done()
+
+		t.sess.Run(ctx)
+		unsubscribe()
+
+		if path := c.resumePath(t.sess); path != "" {
+			// At worst, what's stored is checked again.
+			t.sess.SaveResume(path)
+		}
+
+		c.mu.Lock()
+
+		// Stopped on its own, it failed.
+		if ctx.Err() == nil {
+			t.paused = true
+		}
+
+		cancel()
+		t.running = false
+		t.cancel = nil
+		close(done)
+
+		c.wake()
+		c.mu.Unlock()
+	}()
+}
+
+// stop stops the run of t, if any. The
+// client's mu must be held.
+func (t *torrent) stop() {
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/internal/testtorrent"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+	"github.com/joelancaster/bytepour/pkg/session"
+)
+
+// newTorrent makes a torrent of one file, name, and writes
+// the file under dir, if not empty.
+func newTorrent(t *testing.T, dir, name string) *metainfo.MetaInfoPreCompute {
+	t.Helper()
+
+	tt := testtorrent.Torrent{
+		Name:        name,
+		PieceLength: 2 * peer.BlockLength,
+		Files:       []testtorrent.File{{Data: testtorrent.Pattern(5*peer.BlockLength+1, name[0])}},
+		Announce:    "http://tracker/announce",
+	}
+
+	if dir != "" {
+		tt.Write(t, dir)
+	}
+
+	return tt.MetaInfo(t)
+}
+
+func newClient(t *testing.T, cfg Config) *Client {
+	t.Helper()
+
+	cfg.ListenAddr = "127.0.0.1:0"
+
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Run = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	return c
+}
+
+// waitUntil waits for cond to hold.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	timeout := time.Now().Add(20 * time.Second)
+
+	for !cond() {
+		if time.Now().After(timeout) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	a := &testtorrent.Announcer{}
+
+	seeder := newClient(t, Config{Announcer: a})
+	leecher := newClient(t, Config{Announcer: a, UploadRate: 1 << 20, DownloadRate: 1 << 20})
+
+	var seeding []*session.Session
+
+	// Two torrents, through the one port.
+	for _, name := range []string{"a", "b"} {
+		mi := newTorrent(t, "", name)
+
+		dir := t.TempDir()
+		newTorrent(t, dir, name)
+
+		s, err := seeder.AddTorrent(mi, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seeding = append(seeding, s)
+
+		if _, err := leecher.AddTorrent(mi, t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitUntil(t, "downloaded", func() bool {
+		for _, st := range leecher.Torrents() {
+			if st.Progress.State != session.StateSeeding {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	for i, st := range leecher.Torrents() {
+		if st.InfoHash != seeding[i].InfoHash() || st.Paused || st.Queued || st.Progress.Left != 0 {
+			t.Fatalf("torrent %d: %+v", i, st)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	c := newClient(t, Config{MaxDownloads: 1})
+
+	var ihs [3][20]byte
+
+	for i, name := range []string{"a", "b", "c"} {
+		s, err := c.AddTorrent(newTorrent(t, "", name), t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ihs[i] = s.InfoHash()
+	}
+
+	// running waits until only the torrent run is downloading.
+	running := func(run int) {
+		t.Helper()
+
+		waitUntil(t, "torrent "+string(rune('a'+run))+" runs", func() bool {
+			for i, st := range c.Torrents() {
+				downloading := st.Progress.State == session.StateDownloading
+				if downloading != (i == run) || st.Queued && i == run {
+					return false
+				}
+			}
+
+			return true
+		})
+	}
+
+	running(0)
+
+	if err := c.Pause(ihs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	running(1)
+
+	if st := c.Torrents(); !st[0].Paused || st[0].Queued || !st[2].Queued {
+		t.Fatalf("after pause: %+v", st)
+	}
+
+	// Back behind the one that took its place.
+	if err := c.Resume(ihs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	running(1)
+
+	if err := c.Remove(ihs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Session(ihs[1]) != nil || len(c.Torrents()) != 2 {
+		t.Fatal("removed torrent still there")
+	}
+
+	running(0)
+}
+
+func TestResumeDir(t *testing.T) {
+	resumeDir := t.TempDir()
+	c := newClient(t, Config{ResumeDir: resumeDir})
+
+	mi := newTorrent(t, "", "a")
+	dir := t.TempDir()
+	newTorrent(t, dir, "a")
+
+	s, err := c.AddTorrent(mi, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitUntil(t, "seeding", func() bool {
+		return s.Progress().State == session.StateSeeding
+	})
+
+	// Saved when stopped.
+	if err := c.Pause(s.InfoHash()); err != nil {
+		t.Fatal(err)
+	}
+
+	ih := s.InfoHash()
+	path := filepath.Join(resumeDir, hex.EncodeToString(ih[:])+".resume")
+
+	waitUntil(t, "saved", func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+
+	// Taken up by another client.
+	other, err := New(Config{ListenAddr: "127.0.0.1:0", ResumeDir: resumeDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer other.l.Close()
+
+	s, err = other.AddTorrent(mi, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have := s.Resume().Have; have.Count() != mi.Info.NumPieces() {
+		t.Fatalf("resumed with %d pieces, want %d", have.Count(), mi.Info.NumPieces())
+	}
+
+	if err := other.Remove(ih); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("resume data after Remove: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newClient(t, Config{})
+
+	mi := newTorrent(t, "", "a")
+
+	if _, err := c.AddTorrent(mi, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.AddTorrent(mi, t.TempDir()); err != ErrDuplicate {
+		t.Fatalf("AddTorrent again = %v, want %v", err, ErrDuplicate)
+	}
+
+	var unknown [20]byte
+
+	for name, f := range map[string]func([20]byte) error{"Remove": c.Remove, "Pause": c.Pause, "Resume": c.Resume} {
+		if err := f(unknown); err != ErrUnknownTorrent {
+			t.Fatalf("%s of unknown torrent = %v, want %v", name, err, ErrUnknownTorrent)
+		}
+	}
+
+	waitUntil(t, "running", func() bool {
+		return !c.Torrents()[0].Queued
+	})
+
+	if err := c.Run(context.Background()); err != ErrClosed {
+		t.Fatalf("Run again = %v, want %v", err, ErrClosed)
+	}
+
+	if name := c.Torrents()[0].Name; name != "a" {
+		t.Fatalf("Name = %q, want a", name)
+	}
+}
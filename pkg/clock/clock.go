@@ -0,0 +1,165 @@
+// Package clock abstracts the passage of time so that
+// timer driven components can be tested deterministically.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a time.Timer obtained from a Clock.
+type Timer interface {
+	// C delivers the time when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, reporting
+	// whether it stopped it.
+	Stop() bool
+}
+
+// System is the Clock of the real world.
+func System() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// Fake is a Clock whose time only moves when
+// told to, for use in tests.
+type Fake struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	now  time.Time
+	// Timers yet to fire.
+	timers []*fakeTimer
+}
+
+// NewFake constructs a Fake clock starting at now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond.L = &f.mu
+
+	return f
+}
+
+// Now implements Clock for Fake.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// NewTimer implements Clock for Fake.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{
+		f:    f,
+		c:    make(chan time.Time, 1),
+		when: f.now.Add(d),
+	}
+
+	if d <= 0 {
+		t.c <- f.now
+		return t
+	}
+
+	f.timers = append(f.timers, t)
+	f.cond.Broadcast()
+
+	return t
+}
+
+// Advance moves the time forward by d,
+// firing timers that are due in order.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].when.Before(f.timers[j].when)
+	})
+
+	var i int
+	for ; i < len(f.timers) && !f.timers[i].when.After(f.now); i++ {
+		f.timers[i].c <- f.timers[i].when
+	}
+
+	f.timers = f.timers[i:]
+	f.cond.Broadcast()
+}
+
+// Pending is the number of timers yet to fire.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.timers)
+}
+
+// BlockUntil waits until there are
+// n timers yet to fire.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.timers) != n {
+		f.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	f    *Fake
+	c    chan time.Time
+	when time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+
+	for i := 0; i < len(t.f.timers); i++ {
+		if t.f.timers[i] == t {
+			t.f.timers = append(t.f.timers[:i], t.f.timers[i+1:]...)
+			t.f.cond.Broadcast()
+
+			return true
+		}
+	}
+
+	return false
+}
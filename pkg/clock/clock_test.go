@@ -0,0 +1,67 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+
+	a := f.NewTimer(2 * time.Second)
+	b := f.NewTimer(time.Second)
+	c := f.NewTimer(3 * time.Second)
+
+	if f.Pending() != 3 {
+		t.Fatalf("pending: got: %d, want: 3", f.Pending())
+	}
+
+	if !c.Stop() || c.Stop() {
+		t.Fatalf("Stop should succeed once")
+	}
+
+	f.Advance(1500 * time.Millisecond)
+
+	select {
+	case got := <-b.C():
+		if !got.Equal(start.Add(time.Second)) {
+			t.Fatalf("b fired at: %s", got)
+		}
+	default:
+		t.Fatalf("b did not fire")
+	}
+
+	select {
+	case <-a.C():
+		t.Fatalf("a fired early")
+	default:
+	}
+
+	done := make(chan struct{})
+	go func() {
+		f.BlockUntil(0)
+		close(done)
+	}()
+
+	f.Advance(time.Second)
+	<-done
+
+	if got := <-a.C(); !got.Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("a fired at: %s", got)
+	}
+
+	if !f.Now().Equal(start.Add(2500 * time.Millisecond)) {
+		t.Fatalf("now: %s", f.Now())
+	}
+
+	if a.Stop() {
+		t.Fatalf("Stop after firing should report false")
+	}
+
+	select {
+	case <-f.NewTimer(0).C():
+	default:
+		t.Fatalf("zero timer did not fire")
+	}
+}
@@ -0,0 +1,174 @@
+// Package limit shares limits between torrents: a rate
+// that bytes may pass at, and a number of slots, such
+// as for connections.
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+// Rate paces bytes to a limit a second, across everyone
+// that waits on it, allowing bursts of a second's worth.
+// A nil Rate, or a limit of zero, is unlimited. It is
+// safe for concurrent use.
+type Rate struct {
+	clk clock.Clock
+
+	mu sync.Mutex
+	// Bytes a second.
+	limit float64
+	// Bytes that may pass now without waiting,
+	// negative when owed by those waiting.
+	tokens float64
+	last   time.Time
+}
+
+// NewRate makes a Rate of limit bytes a second. If clk
+// is nil, the system clock.
+func NewRate(limit int, clk clock.Clock) *Rate {
+	if clk == nil {
+		clk = clock.System()
+	}
+
+	r := &Rate{clk: clk, last: clk.Now()}
+	r.SetLimit(limit)
+
+	return r
+}
+
+// Limit is the bytes a second allowed, zero if unlimited.
+func (r *Rate) Limit() int {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return int(r.limit)
+}
+
+// SetLimit changes the limit to limit bytes a
+// second. Those waiting already wait on.
+func (r *Rate) SetLimit(limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refill(r.clk.Now())
+	r.limit = float64(max(limit, 0))
+	r.tokens = min(r.tokens, r.limit)
+}
+
+// refill adds the tokens earned up to now. r.mu must be held.
+func (r *Rate) refill(now time.Time) {
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.tokens = min(r.limit, r.tokens+elapsed.Seconds()*r.limit)
+	}
+
+	r.last = now
+}
+
+// Wait waits until n bytes may pass, or ctx is done. More
+// than a second's worth may be asked for at once, which
+// those that wait after make up for.
+func (r *Rate) Wait(ctx context.Context, n int) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+
+	if r.limit == 0 {
+		r.mu.Unlock()
+		return nil
+	}
+
+	r.refill(r.clk.Now())
+	r.tokens -= float64(n)
+
+	var wait time.Duration
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / r.limit * float64(time.Second))
+	}
+
+	r.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	t := r.clk.NewTimer(wait)
+
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		t.Stop()
+
+		// Give back what won't pass.
+		r.mu.Lock()
+		r.tokens = min(r.limit, r.tokens+float64(n))
+		r.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// Slots counts what is held at once, up to a maximum.
+// A nil Slots, or a maximum of zero, is unlimited.
+// It is safe for concurrent use.
+type Slots struct {
+	mu        sync.Mutex
+	max, used int
+}
+
+// NewSlots makes Slots of which max may be held at once.
+func NewSlots(max int) *Slots {
+	return &Slots{max: max}
+}
+
+// TryAcquire takes a slot, reporting whether one was free.
+func (s *Slots) TryAcquire() bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.max != 0 && s.used >= s.max {
+		return false
+	}
+
+	s.used++
+
+	return true
+}
+
+// Release gives back a slot taken by TryAcquire.
+func (s *Slots) Release() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.used--
+}
+
+// Used is the number of slots held.
+func (s *Slots) Used() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.used
+}
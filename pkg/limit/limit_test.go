@@ -0,0 +1,111 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+func TestRate(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	r := NewRate(1000, clk)
+	ctx := context.Background()
+
+	// Nothing banked at the start.
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Wait(ctx, 500)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(499 * time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("Wait returned early")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A second's worth is banked, no more.
+	clk.Advance(5 * time.Second)
+
+	if err := r.Wait(ctx, 1000); err != nil || clk.Pending() != 0 {
+		t.Fatalf("Wait of a burst = %v, %d timers", err, clk.Pending())
+	}
+
+	// Asking for more than a second's worth
+	// makes those after wait longer.
+	go func() {
+		done <- r.Wait(ctx, 2000)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Second)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		done <- r.Wait(cctx, 100)
+	}()
+
+	clk.BlockUntil(1)
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Wait = %v, want %v", err, context.Canceled)
+	}
+
+	// Unlimited.
+	r.SetLimit(0)
+	if err := r.Wait(ctx, 1<<30); err != nil || r.Limit() != 0 {
+		t.Fatalf("Wait unlimited = %v", err)
+	}
+
+	var none *Rate
+	if err := none.Wait(ctx, 1<<30); err != nil || none.Limit() != 0 {
+		t.Fatalf("Wait on nil = %v", err)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	s := NewSlots(2)
+
+	if !s.TryAcquire() || !s.TryAcquire() || s.TryAcquire() {
+		t.Fatal("acquired other than 2 of 2 slots")
+	}
+
+	s.Release()
+
+	if s.Used() != 1 || !s.TryAcquire() {
+		t.Fatalf("after Release, used %d", s.Used())
+	}
+
+	var none *Slots
+	if !none.TryAcquire() {
+		t.Fatal("nil Slots are limited")
+	}
+
+	none.Release()
+}
+
+func BenchmarkRate(b *testing.B) {
+	r := NewRate(1<<40, nil)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		r.Wait(ctx, 16<<10)
+	}
+}
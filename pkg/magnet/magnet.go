@@ -0,0 +1,116 @@
+// Package magnet parses magnet links, which name a
+// torrent by its info hash alone (BEP 9).
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrNotMagnet is returned for URIs
+	// that aren't magnet links.
+	ErrNotMagnet = errors.New("magnet: not a magnet link")
+	// ErrNoInfoHash is returned for magnet links
+	// without a BitTorrent info hash.
+	ErrNoInfoHash = errors.New("magnet: no btih info hash")
+)
+
+const btihPrefix = "urn:btih:"
+
+// Magnet is what a magnet link says of a torrent.
+type Magnet struct {
+	InfoHash [20]byte
+	// The display name, if any.
+	Name string
+	// Tracker URLs, in the order given.
+	Trackers []string
+	// Peers to fetch the metadata from, those
+	// given as addresses rather than host names.
+	Peers []netip.AddrPort
+}
+
+// Parse parses the magnet link uri. Its info hash may be
+// hex or base32 encoded. Parameters other than xt, dn, tr
+// and x.pe are ignored, as are unusable peers.
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "magnet" {
+		return nil, ErrNotMagnet
+	}
+
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Magnet{Name: q.Get("dn"), Trackers: q["tr"]}
+
+	found := false
+	for _, xt := range q["xt"] {
+		if len(xt) < len(btihPrefix) || !strings.EqualFold(xt[:len(btihPrefix)], btihPrefix) {
+			continue
+		}
+
+		if m.InfoHash, found = parseInfoHash(xt[len(btihPrefix):]); found {
+			break
+		}
+	}
+
+	if !found {
+		return nil, ErrNoInfoHash
+	}
+
+	for _, pe := range q["x.pe"] {
+		if addr, err := netip.ParseAddrPort(pe); err == nil {
+			m.Peers = append(m.Peers, addr)
+		}
+	}
+
+	return m, nil
+}
+
+func parseInfoHash(s string) ([20]byte, bool) {
+	var ih [20]byte
+
+	switch len(s) {
+	case 40:
+		n, err := hex.Decode(ih[:], []byte(s))
+		return ih, err == nil && n == 20
+	case 32:
+		n, err := base32.StdEncoding.Decode(ih[:], []byte(strings.ToUpper(s)))
+		return ih, err == nil && n == 20
+	}
+
+	return ih, false
+}
+
+// String is the magnet link for m,
+// with the info hash in hex.
+func (m *Magnet) String() string {
+	var b strings.Builder
+
+	b.WriteString("magnet:?xt=" + btihPrefix + hex.EncodeToString(m.InfoHash[:]))
+
+	if m.Name != "" {
+		b.WriteString("&dn=" + url.QueryEscape(m.Name))
+	}
+
+	for _, tr := range m.Trackers {
+		b.WriteString("&tr=" + url.QueryEscape(tr))
+	}
+
+	for _, pe := range m.Peers {
+		b.WriteString("&x.pe=" + url.QueryEscape(pe.String()))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package magnet
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const ihHex = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+
+	var ih [20]byte
+	hex.Decode(ih[:], []byte(ihHex))
+
+	tests := []struct {
+		uri  string
+		want Magnet
+	}{
+		{
+			"magnet:?xt=urn:btih:" + ihHex,
+			Magnet{InfoHash: ih},
+		},
+		{
+			"magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK&dn=a+b",
+			Magnet{InfoHash: ih, Name: "a b"},
+		},
+		{
+			// Lower case base32, other hashes and
+			// parameters, and a peer by host name.
+			"magnet:?xt=urn:sha1:XYZ&xt=URN:BTIH:yex6dqdlxisuvhoj6um3gnnkpqjwpkek&xl=10" +
+				"&tr=http%3A%2F%2Fa%2Fannounce&tr=udp%3A%2F%2Fb%3A80&x.pe=1.2.3.4:5&x.pe=host:6&x.pe=[::1]:7",
+			Magnet{
+				InfoHash: ih,
+				Trackers: []string{"http://a/announce", "udp://b:80"},
+				Peers:    []netip.AddrPort{netip.MustParseAddrPort("1.2.3.4:5"), netip.MustParseAddrPort("[::1]:7")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		m, err := Parse(tt.uri)
+		if err != nil || !reflect.DeepEqual(*m, tt.want) {
+			t.Fatalf("Parse(%q) = %+v, %v, want %+v", tt.uri, m, err, tt.want)
+		}
+
+		again, err := Parse(m.String())
+		if err != nil || !reflect.DeepEqual(again, m) {
+			t.Fatalf("Parse(%q) = %+v, %v, want %+v", m.String(), again, err, m)
+		}
+	}
+
+	bad := []struct {
+		uri  string
+		want error
+	}{
+		{"http://example.com/?xt=urn:btih:" + ihHex, ErrNotMagnet},
+		{"magnet:?dn=x", ErrNoInfoHash},
+		{"magnet:?xt=urn:btih:" + ihHex[:38], ErrNoInfoHash},
+		{"magnet:?xt=urn:btih:" + ihHex[:39] + "z", ErrNoInfoHash},
+	}
+
+	for _, tt := range bad {
+		if _, err := Parse(tt.uri); err != tt.want {
+			t.Fatalf("Parse(%q) = %v, want %v", tt.uri, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package metainfo
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+var (
+	// ErrEditInfo is returned when an edit
+	// would change the info dictionary.
+	ErrEditInfo = errors.New("metainfo: the info dictionary cannot be edited")
+	// ErrBadValue is returned when a raw value
+	// is not exactly one bencoded term.
+	ErrBadValue = errors.New("metainfo: value is not a single bencoded term")
+	// ErrNoInfo is returned for a metainfo
+	// file without an info dictionary.
+	ErrNoInfo = errors.New("metainfo: no info dictionary")
+)
+
+// Editor rewrites the top-level dictionary of a metainfo
+// file. The info dictionary is copied verbatim from the
+// input, so the info hash of the result is unchanged.
+type Editor struct {
+	// Raw bencoded values, by key.
+	values map[string][]byte
+}
+
+// NewEditor constructs an Editor for the metainfo file p,
+// failing with a parse.Error if p is malformed, or ErrNoInfo.
+// p must not be modified while the Editor is in use.
+func NewEditor(p []byte) (*Editor, error) {
+	e := Editor{values: make(map[string][]byte)}
+
+	it := parse.NewDictIter(p)
+	for it.Next() {
+		e.values[string(it.Key())] = it.Value()
+	}
+
+	if err := it.Err(); err.IsError() {
+		return nil, err
+	}
+
+	if _, ok := e.values["info"]; !ok {
+		return nil, ErrNoInfo
+	}
+
+	return &e, nil
+}
+
+// Get yields the raw bencoded value of key,
+// or nil if it is not set.
+func (e *Editor) Get(key string) []byte {
+	return e.values[key]
+}
+
+// SetRaw sets key to value, which must
+// already be bencoded.
+func (e *Editor) SetRaw(key string, value []byte) error {
+	if key == "info" {
+		return ErrEditInfo
+	}
+
+	if len(value) == 0 || parse.Skip(value) != len(value) {
+		return ErrBadValue
+	}
+
+	e.values[key] = value
+
+	return nil
+}
+
+// SetString sets key to the string value.
+func (e *Editor) SetString(key string, value []byte) error {
+	return e.SetRaw(key, encode.String(nil, value))
+}
+
+// SetInt sets key to the integer value.
+func (e *Editor) SetInt(key string, value int64) error {
+	return e.SetRaw(key, encode.Int(nil, value))
+}
+
+// Delete removes key, if it is set.
+func (e *Editor) Delete(key string) error {
+	if key == "info" {
+		return ErrEditInfo
+	}
+
+	delete(e.values, key)
+
+	return nil
+}
+
+// SetAnnounce replaces the tracker URL.
+func (e *Editor) SetAnnounce(u []byte) error {
+	return e.SetString("announce", u)
+}
+
+// SetComment replaces the comment.
+func (e *Editor) SetComment(c []byte) error {
+	return e.SetString("comment", c)
+}
+
+// SetAnnounceList replaces the tiers of trackers
+// (BEP 12). An empty list of tiers removes it.
+func (e *Editor) SetAnnounceList(tiers [][][]byte) error {
+	if len(tiers) == 0 {
+		return e.Delete("announce-list")
+	}
+
+	v := []byte{parse.OpenList}
+	for i := 0; i < len(tiers); i++ {
+		v = append(v, parse.OpenList)
+		for j := 0; j < len(tiers[i]); j++ {
+			v = encode.String(v, tiers[i][j])
+		}
+		v = append(v, parse.EndTerm)
+	}
+	v = append(v, parse.EndTerm)
+
+	return e.SetRaw("announce-list", v)
+}
+
+// WebSeeds yields the URLs of the web seeds (BEP 19),
+// which may be a single string or a list.
+func (e *Editor) WebSeeds() [][]byte {
+	raw := e.values["url-list"]
+
+	if s, ok := parse.Bytes(raw); ok {
+		if len(s) == 0 {
+			return nil
+		}
+
+		return [][]byte{s}
+	}
+
+	var urls [][]byte
+
+	it := parse.NewListIter(raw)
+	for it.Next() {
+		if s, ok := parse.Bytes(it.Value()); ok {
+			urls = append(urls, s)
+		}
+	}
+
+	return urls
+}
+
+// AddWebSeed adds u to the web seeds (BEP 19),
+// if it is not there already.
+func (e *Editor) AddWebSeed(u []byte) error {
+	urls := e.WebSeeds()
+
+	for i := 0; i < len(urls); i++ {
+		if string(urls[i]) == string(u) {
+			return nil
+		}
+	}
+
+	v := []byte{parse.OpenList}
+	for i := 0; i < len(urls); i++ {
+		v = encode.String(v, urls[i])
+	}
+	v = encode.String(v, u)
+	v = append(v, parse.EndTerm)
+
+	return e.SetRaw("url-list", v)
+}
+
+// AppendTo appends the edited metainfo file to dst.
+// Keys are written in sorted order.
+func (e *Editor) AppendTo(dst []byte) []byte {
+	keys := make([]string, 0, len(e.values))
+	for k := range e.values {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	dst = append(dst, parse.OpenDict)
+	for i := 0; i < len(keys); i++ {
+		dst = encode.String(dst, keys[i])
+		dst = append(dst, e.values[keys[i]]...)
+	}
+
+	return append(dst, parse.EndTerm)
+}
+
+// Bytes yields the edited metainfo file.
+func (e *Editor) Bytes() []byte {
+	return e.AppendTo(nil)
+}
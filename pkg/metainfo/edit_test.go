@@ -0,0 +1,77 @@
+package metainfo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEditor(t *testing.T) {
+	const (
+		info    = "d6:lengthi1e4:name1:a12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+		torrent = "d8:announce20:http://a.example/ann7:comment2:hi4:info" + info + "8:url-list15:http://a.w/seede"
+	)
+
+	e, err := NewEditor([]byte(torrent))
+	if err != nil {
+		t.Fatalf("NewEditor: %s", err)
+	}
+
+	if err := e.SetAnnounce([]byte("udp://b.example:80")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Delete("comment"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.AddWebSeed([]byte("http://b.w/seed")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.AddWebSeed([]byte("http://a.w/seed")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.SetAnnounceList([][][]byte{{[]byte("udp://b.example:80")}, {[]byte("http://c")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.SetInt("creation date", 1707570148); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.SetRaw("info", []byte("de")); err != ErrEditInfo {
+		t.Fatalf("SetRaw info: got: %v, want: %v", err, ErrEditInfo)
+	}
+
+	if err := e.Delete("info"); err != ErrEditInfo {
+		t.Fatalf("Delete info: got: %v, want: %v", err, ErrEditInfo)
+	}
+
+	if err := e.SetRaw("x", []byte("i1ei2e")); err != ErrBadValue {
+		t.Fatalf("SetRaw two terms: got: %v, want: %v", err, ErrBadValue)
+	}
+
+	const want = "d8:announce18:udp://b.example:80" +
+		"13:announce-listll18:udp://b.example:80el8:http://cee" +
+		"13:creation datei1707570148e" +
+		"4:info" + info +
+		"8:url-listl15:http://a.w/seed15:http://b.w/seedee"
+
+	got := e.Bytes()
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if !bytes.Contains(got, []byte(info)) {
+		t.Fatalf("info dict not copied verbatim")
+	}
+
+	if _, err := NewEditor([]byte("d8:announce3:urle")); err != ErrNoInfo {
+		t.Fatalf("NewEditor without info = %v, want %v", err, ErrNoInfo)
+	}
+
+	if _, err := NewEditor([]byte("d8:announce3:url")); err == nil || err == ErrNoInfo {
+		t.Fatalf("NewEditor of a truncated file = %v, want a parse error", err)
+	}
+}
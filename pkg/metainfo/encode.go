@@ -0,0 +1,193 @@
+package metainfo
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+// AppendBencode appends the bencoding of m to dst,
+// including any keys that were not decoded.
+//
+// The info dictionary is copied from InfoDict, so the
+// info hash is kept, or encoded from Info if m was built
+// rather than decoded and InfoDict is empty.
+func (m *MetaInfoPreCompute) AppendBencode(dst []byte) []byte {
+	var d dictEncoder
+
+	if len(m.Announce) != 0 {
+		d.string("announce", m.Announce)
+	}
+
+	if len(m.Comment) != 0 {
+		d.string("comment", m.Comment)
+	}
+
+	if len(m.CommentUTF8) != 0 {
+		d.string("comment.utf-8", m.CommentUTF8)
+	}
+
+	if len(m.CreatedBy) != 0 {
+		d.string("created by", m.CreatedBy)
+	}
+
+	if !m.CreationDate.IsZero() {
+		d.key("creation date")
+		d.buf = encode.Int(d.buf, m.CreationDate.Unix())
+	}
+
+	if len(m.Encoding) != 0 {
+		d.string("encoding", m.Encoding)
+	}
+
+	d.key("info")
+	if len(m.InfoDict) != 0 {
+		d.buf = append(d.buf, m.InfoDict...)
+	} else {
+		d.buf = m.Info.AppendBencode(d.buf)
+	}
+
+	if len(m.Nodes) != 0 {
+		d.key("nodes")
+		d.buf = append(d.buf, parse.OpenList)
+		for i := 0; i < len(m.Nodes); i++ {
+			d.buf = append(d.buf, parse.OpenList)
+			d.buf = encode.String(d.buf, m.Nodes[i].Host)
+			d.buf = encode.Uint(d.buf, uint64(m.Nodes[i].Port))
+			d.buf = append(d.buf, parse.EndTerm)
+		}
+		d.buf = append(d.buf, parse.EndTerm)
+	}
+
+	d.extra(m.Extra)
+
+	return d.appendTo(dst)
+}
+
+// AppendBencode appends the bencoding of the
+// info dictionary to dst, including any keys
+// that were not decoded.
+func (a *Info) AppendBencode(dst []byte) []byte {
+	var d dictEncoder
+
+	if len(a.Files) == 0 {
+		d.key("length")
+		d.buf = encode.Uint(d.buf, a.Length)
+	} else {
+		d.key("files")
+		d.buf = append(d.buf, parse.OpenList)
+		for i := 0; i < len(a.Files); i++ {
+			d.buf = a.Files[i].AppendBencode(d.buf)
+		}
+		d.buf = append(d.buf, parse.EndTerm)
+	}
+
+	d.string("name", a.Name)
+
+	if len(a.NameUTF8) != 0 {
+		d.string("name.utf-8", a.NameUTF8)
+	}
+
+	d.key("piece length")
+	d.buf = encode.Uint(d.buf, a.PieceLength)
+
+	d.string("pieces", a.Pieces)
+
+	d.extra(a.Extra)
+
+	return d.appendTo(dst)
+}
+
+// AppendBencode appends the bencoding of an entry in
+// the files list to dst, including any keys that were
+// not decoded.
+func (a *File) AppendBencode(dst []byte) []byte {
+	var d dictEncoder
+
+	d.key("length")
+	d.buf = encode.Uint(d.buf, a.Length)
+
+	d.key("path")
+	d.buf = append(d.buf, parse.OpenList)
+	for i := 0; i < len(a.Path); i++ {
+		d.buf = encode.String(d.buf, a.Path[i])
+	}
+	d.buf = append(d.buf, parse.EndTerm)
+
+	d.extra(a.Extra)
+
+	return d.appendTo(dst)
+}
+
+// dictEncoder collects the entries of a dictionary
+// so they can be written in sorted order.
+type dictEncoder struct {
+	// Keys and values, as they were added.
+	buf []byte
+	// Offsets into buf of each entry.
+	entries []dictEntry
+}
+
+type dictEntry struct {
+	key, value, end int
+}
+
+// key begins a new entry, whose value
+// the caller appends to buf.
+func (d *dictEncoder) key(k string) {
+	d.keyBytes([]byte(k))
+}
+
+func (d *dictEncoder) keyBytes(k []byte) {
+	var e dictEntry
+
+	e.key = len(d.buf)
+	d.buf = append(d.buf, k...)
+	e.value = len(d.buf)
+
+	d.entries = append(d.entries, e)
+}
+
+// string adds an entry with a string value.
+func (d *dictEncoder) string(k string, v []byte) {
+	d.key(k)
+	d.buf = encode.String(d.buf, v)
+}
+
+// extra adds entries that were not decoded.
+func (d *dictEncoder) extra(fs []RawField) {
+	for i := 0; i < len(fs); i++ {
+		d.keyBytes(fs[i].Key)
+		d.buf = append(d.buf, fs[i].Value...)
+	}
+}
+
+// appendTo appends the dictionary to dst,
+// sorted by key.
+func (d *dictEncoder) appendTo(dst []byte) []byte {
+	for i := 0; i < len(d.entries); i++ {
+		if i+1 < len(d.entries) {
+			d.entries[i].end = d.entries[i+1].key
+		} else {
+			d.entries[i].end = len(d.buf)
+		}
+	}
+
+	sort.SliceStable(d.entries, func(i, j int) bool {
+		ei, ej := d.entries[i], d.entries[j]
+
+		return bytes.Compare(d.buf[ei.key:ei.value], d.buf[ej.key:ej.value]) < 0
+	})
+
+	dst = append(dst, parse.OpenDict)
+	for i := 0; i < len(d.entries); i++ {
+		e := d.entries[i]
+
+		dst = encode.String(dst, d.buf[e.key:e.value])
+		dst = append(dst, d.buf[e.value:e.end]...)
+	}
+
+	return append(dst, parse.EndTerm)
+}
@@ -0,0 +1,33 @@
+package metainfo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppendBencode(t *testing.T) {
+	mi := MetaInfoPreCompute{
+		Announce:     []byte("http://t"),
+		CreationDate: time.Unix(10, 0),
+		Nodes:        []Node{{Host: []byte("h"), Port: 1}},
+		Extra: []RawField{
+			{Key: []byte("zz"), Value: []byte("i2e")},
+			{Key: []byte("aa"), Value: []byte("le")},
+		},
+		Info: Info{
+			Length:      3,
+			Name:        []byte("n"),
+			PieceLength: 16384,
+			Pieces:      []byte("p"),
+			Extra:       []RawField{{Key: []byte("private"), Value: []byte("i1e")}},
+		},
+	}
+
+	const want = "d2:aale8:announce8:http://t13:creation datei10e" +
+		"4:infod6:lengthi3e4:name1:n12:piece lengthi16384e6:pieces1:p7:privatei1ee" +
+		"5:nodesll1:hi1eee2:zzi2ee"
+
+	if got := mi.AppendBencode(nil); string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
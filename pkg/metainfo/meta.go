@@ -3,6 +3,7 @@ package metainfo
 import (
 	"bytes"
 	"encoding/json"
+	"time"
 )
 
 // MetaInfoPreCompute is the top-level
@@ -12,6 +13,22 @@ type MetaInfoPreCompute struct {
 	Announce []byte `bencode:"announce"`
 	// Optional free-form comment field.
 	Comment []byte `bencode:"comment"`
+	// Optional comment, known to be UTF-8.
+	CommentUTF8 []byte `bencode:"comment.utf-8"`
+	// Optional time the torrent was created.
+	// The zero time if not present.
+	CreationDate time.Time `bencode:"creation date"`
+	// Optional name and version of the program
+	// that created the torrent.
+	CreatedBy []byte `bencode:"created by"`
+	// Optional character set of the
+	// strings in the info dictionary.
+	Encoding []byte `bencode:"encoding"`
+	// Optional DHT nodes to bootstrap from (BEP 5).
+	Nodes []Node `bencode:"nodes"`
+	// Keys that were not decoded, in the
+	// order they were found.
+	Extra []RawField `bencode:"-"`
 	// Substring of the input that is the info dict.
 	InfoDict []byte `bencode:"-" json:"-"`
 	// The info dictionary, containing file info.
@@ -21,14 +38,95 @@ type MetaInfoPreCompute struct {
 // Info is the info dictionary in a metainfo file.
 type Info struct {
 	// Length of the file, in bytes.
+	// Zero for multi-file torrents.
 	Length uint64 `bencode:"length"`
-	// The name of the file.
+	// The name of the file, or of the directory
+	// for multi-file torrents.
 	Name []byte `bencode:"name"`
+	// Optional name, known to be UTF-8.
+	NameUTF8 []byte `bencode:"name.utf-8"`
 	// The pieces of a file, kept as a single
 	// string.
 	Pieces []byte `bencode:"pieces" json:"-"`
 	// The length of each piece.
 	PieceLength uint64 `bencode:"piece length"`
+	// The files of a multi-file torrent.
+	Files []File `bencode:"files"`
+	// Keys that were not decoded, in the
+	// order they were found.
+	Extra []RawField `bencode:"-"`
+}
+
+// File is an entry in the files list
+// of a multi-file info dictionary.
+type File struct {
+	// Length of the file, in bytes.
+	Length uint64 `bencode:"length"`
+	// Path components, the last of which
+	// is the file name.
+	Path [][]byte `bencode:"path"`
+	// Keys that were not decoded, in the
+	// order they were found.
+	Extra []RawField `bencode:"-"`
+}
+
+// RawField is a dictionary entry that was not
+// decoded, kept so it can be encoded again.
+type RawField struct {
+	Key []byte
+	// The bencoding of the value.
+	Value []byte
+}
+
+// Lookup yields the raw value of key in fs,
+// or nil if it is not there.
+func Lookup(fs []RawField, key string) []byte {
+	for i := 0; i < len(fs); i++ {
+		if string(fs[i].Key) == key {
+			return fs[i].Value
+		}
+	}
+
+	return nil
+}
+
+// Node is a DHT node, as found in the
+// nodes list of a metainfo file.
+type Node struct {
+	// A hostname or IP address.
+	Host []byte
+	Port uint16
+}
+
+// TotalLength is the length of all
+// the files in the torrent.
+func (a *Info) TotalLength() uint64 {
+	if len(a.Files) == 0 {
+		return a.Length
+	}
+
+	var n uint64
+	for i := 0; i < len(a.Files); i++ {
+		n += a.Files[i].Length
+	}
+
+	return n
+}
+
+// NumPieces is the number of pieces the torrent
+// is split into, according to its length.
+func (a *Info) NumPieces() int {
+	if a.PieceLength == 0 {
+		return 0
+	}
+
+	return int((a.TotalLength() + a.PieceLength - 1) / a.PieceLength)
+}
+
+// PieceSize is the length of piece i, less than NumPieces,
+// which is short of PieceLength if it is the last.
+func (a *Info) PieceSize(i int) uint64 {
+	return min(a.PieceLength, a.TotalLength()-uint64(i)*a.PieceLength)
 }
 
 // Eq compares a MetaInfoPreCompute for equality.
@@ -37,10 +135,26 @@ func (a *MetaInfoPreCompute) Eq(b *MetaInfoPreCompute) bool {
 		return true
 	}
 
+	if len(a.Nodes) != len(b.Nodes) {
+		return false
+	}
+
+	for i := 0; i < len(a.Nodes); i++ {
+		if a.Nodes[i].Port != b.Nodes[i].Port ||
+			!bytes.Equal(a.Nodes[i].Host, b.Nodes[i].Host) {
+			return false
+		}
+	}
+
 	return a.Info.Eq(&b.Info) &&
+		extraEq(a.Extra, b.Extra) &&
 		bytes.Equal(a.InfoDict, b.InfoDict) &&
 		bytes.Equal(a.Announce, b.Announce) &&
-		bytes.Equal(a.Comment, b.Comment)
+		bytes.Equal(a.Comment, b.Comment) &&
+		bytes.Equal(a.CommentUTF8, b.CommentUTF8) &&
+		a.CreationDate.Equal(b.CreationDate) &&
+		bytes.Equal(a.CreatedBy, b.CreatedBy) &&
+		bytes.Equal(a.Encoding, b.Encoding)
 
 }
 
@@ -50,12 +164,57 @@ func (a *Info) Eq(b *Info) bool {
 		return true
 	}
 
+	if len(a.Files) != len(b.Files) {
+		return false
+	}
+
+	for i := 0; i < len(a.Files); i++ {
+		if !a.Files[i].Eq(&b.Files[i]) {
+			return false
+		}
+	}
+
 	return a.Length == b.Length &&
 		a.PieceLength == b.PieceLength &&
+		extraEq(a.Extra, b.Extra) &&
 		bytes.Equal(a.Name, b.Name) &&
+		bytes.Equal(a.NameUTF8, b.NameUTF8) &&
 		bytes.Equal(a.Pieces, b.Pieces)
 }
 
+// Eq compares a File for equality.
+func (a *File) Eq(b *File) bool {
+	if a.Length != b.Length || len(a.Path) != len(b.Path) ||
+		!extraEq(a.Extra, b.Extra) {
+		return false
+	}
+
+	for i := 0; i < len(a.Path); i++ {
+		if !bytes.Equal(a.Path[i], b.Path[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// extraEq compares undecoded keys for equality,
+// including their order.
+func extraEq(a, b []RawField) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := 0; i < len(a); i++ {
+		if !bytes.Equal(a[i].Key, b[i].Key) ||
+			!bytes.Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // String implements the stringer interface for
 // MetaInfoPreCompute. Debug use only.
 func (m *MetaInfoPreCompute) String() string {
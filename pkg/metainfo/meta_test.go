@@ -0,0 +1,29 @@
+package metainfo
+
+import "testing"
+
+func TestPieceSize(t *testing.T) {
+	tests := []struct {
+		info Info
+		want []uint64
+	}{
+		{Info{Length: 10, PieceLength: 4}, []uint64{4, 4, 2}},
+		{Info{Length: 8, PieceLength: 4}, []uint64{4, 4}},
+		{Info{Length: 3, PieceLength: 4}, []uint64{3}},
+		// Files are laid end to end.
+		{Info{PieceLength: 4, Files: []File{{Length: 3}, {Length: 0}, {Length: 6}}}, []uint64{4, 4, 1}},
+		{Info{PieceLength: 4}, nil},
+	}
+
+	for _, test := range tests {
+		if n := test.info.NumPieces(); n != len(test.want) {
+			t.Fatalf("NumPieces(%+v) = %d, want %d", test.info, n, len(test.want))
+		}
+
+		for i, want := range test.want {
+			if got := test.info.PieceSize(i); got != want {
+				t.Fatalf("PieceSize(%+v, %d) = %d, want %d", test.info, i, got, want)
+			}
+		}
+	}
+}
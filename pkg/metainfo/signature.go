@@ -0,0 +1,208 @@
+package metainfo
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"errors"
+	"sort"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+var (
+	// ErrBadSignature is returned when a signature
+	// does not match the info dictionary.
+	ErrBadSignature = errors.New("metainfo: signature does not verify")
+	// ErrUnsupportedKey is returned for keys other
+	// than ed25519 and RSA.
+	ErrUnsupportedKey = errors.New("metainfo: unsupported signing key type")
+	// ErrNoCertificate is returned when verifying a chain
+	// for a signature without a certificate.
+	ErrNoCertificate = errors.New("metainfo: signature has no certificate")
+)
+
+// Signature is an entry in the signatures
+// dictionary of a signed torrent (BEP 35).
+type Signature struct {
+	// Who made the signature, the
+	// key in the signatures dictionary.
+	Identity []byte
+	// Optional X.509 certificate of the signer, DER encoded.
+	Certificate []byte
+	// Optional bencoded dictionary that is
+	// signed along with the info dictionary.
+	Info []byte
+	// The signature itself.
+	Signature []byte
+}
+
+// Signatures yields the signatures of m,
+// which has none if it is not signed.
+func (m *MetaInfoPreCompute) Signatures() ([]Signature, parse.Error) {
+	raw := Lookup(m.Extra, "signatures")
+	if raw == nil {
+		return nil, parse.ErrOk
+	}
+
+	return ParseSignatures(raw)
+}
+
+// ParseSignatures parses the bencoded value of
+// the signatures key of a metainfo file.
+func ParseSignatures(raw []byte) ([]Signature, parse.Error) {
+	var sigs []Signature
+
+	it := parse.NewDictIter(raw)
+	for it.Next() {
+		sig := Signature{Identity: it.Key()}
+
+		entry := parse.NewDictIter(it.Value())
+		for entry.Next() {
+			switch string(entry.Key()) {
+			case "certificate":
+				sig.Certificate, _ = parse.Bytes(entry.Value())
+			case "info":
+				sig.Info = entry.Value()
+			case "signature":
+				sig.Signature, _ = parse.Bytes(entry.Value())
+			}
+		}
+
+		if err := entry.Err(); err.IsError() {
+			return nil, err
+		}
+
+		sigs = append(sigs, sig)
+	}
+
+	if err := it.Err(); err.IsError() {
+		return nil, err
+	}
+
+	return sigs, parse.ErrOk
+}
+
+// signedMessage is what a signature is computed over,
+// the info dictionary followed by the signature's own
+// info dictionary, if it has one.
+func signedMessage(infoDict, sigInfo []byte) []byte {
+	msg := make([]byte, 0, len(infoDict)+len(sigInfo))
+	msg = append(msg, infoDict...)
+
+	return append(msg, sigInfo...)
+}
+
+// Verify checks s is a signature of infoDict,
+// the original bytes of the info dictionary,
+// made by the private half of pub.
+func (s *Signature) Verify(infoDict []byte, pub crypto.PublicKey) error {
+	msg := signedMessage(infoDict, s.Info)
+
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, msg, s.Signature) {
+			return ErrBadSignature
+		}
+	case *rsa.PublicKey:
+		// As specified by BEP 35.
+		digest := sha1.Sum(msg)
+
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA1, digest[:], s.Signature) != nil {
+			return ErrBadSignature
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+
+	return nil
+}
+
+// VerifyChain checks the certificate of s is valid according
+// to opts, and that s is a signature of infoDict made with
+// the certificate's key. The verified certificate is returned.
+func (s *Signature) VerifyChain(infoDict []byte, opts x509.VerifyOptions) (*x509.Certificate, error) {
+	if len(s.Certificate) == 0 {
+		return nil, ErrNoCertificate
+	}
+
+	cert, err := x509.ParseCertificate(s.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, err
+	}
+
+	if err := s.Verify(infoDict, cert.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// Sign adds a signature of the torrent being edited
+// by e under identity, made with key. cert is the DER
+// encoded X.509 certificate of key, and may be nil.
+// Any existing signature under identity is replaced.
+func Sign(e *Editor, identity string, key crypto.Signer, cert []byte) error {
+	msg := signedMessage(e.Get("info"), nil)
+
+	var sig []byte
+	var err error
+
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = key.Sign(rand.Reader, msg, crypto.Hash(0))
+	case *rsa.PublicKey:
+		digest := sha1.Sum(msg)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA1)
+	default:
+		return ErrUnsupportedKey
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Keep the other signatures, keyed by identity so
+	// they can be written back in sorted order.
+	entries := make(map[string][]byte)
+
+	it := parse.NewDictIter(e.Get("signatures"))
+	for it.Next() {
+		entries[string(it.Key())] = it.Value()
+	}
+
+	var v []byte
+	v = append(v, parse.OpenDict)
+	if len(cert) != 0 {
+		v = encode.KeyString(v, "certificate", cert)
+	}
+	v = encode.KeyString(v, "signature", sig)
+	v = append(v, parse.EndTerm)
+
+	entries[identity] = v
+
+	ids := make([]string, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	var sigs []byte
+	sigs = append(sigs, parse.OpenDict)
+	for i := 0; i < len(ids); i++ {
+		sigs = encode.String(sigs, ids[i])
+		sigs = append(sigs, entries[ids[i]]...)
+	}
+	sigs = append(sigs, parse.EndTerm)
+
+	return e.SetRaw("signatures", sigs)
+}
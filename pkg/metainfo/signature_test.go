@@ -0,0 +1,151 @@
+package metainfo
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const signTestTorrent = "d8:announce8:http://t4:info" +
+	"d6:lengthi1e4:name1:a12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae" +
+	"e"
+
+// resign re-opens a signed torrent, as a downloader would.
+func resign(t *testing.T, e *Editor) (*Editor, []Signature) {
+	t.Helper()
+
+	e, err := NewEditor(e.Bytes())
+	if err != nil {
+		t.Fatalf("NewEditor: %s", err)
+	}
+
+	sigs, perr := ParseSignatures(e.Get("signatures"))
+	if perr.IsError() {
+		t.Fatalf("ParseSignatures: %s", perr)
+	}
+
+	return e, sigs
+}
+
+func TestSignEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEditor([]byte(signTestTorrent))
+	if err != nil {
+		t.Fatalf("NewEditor: %s", err)
+	}
+
+	if err := Sign(e, "builds.example", priv, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	e, sigs := resign(t, e)
+
+	if len(sigs) != 1 || string(sigs[0].Identity) != "builds.example" {
+		t.Fatalf("got signatures: %+v", sigs)
+	}
+
+	if err := sigs[0].Verify(e.Get("info"), pub); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if err := sigs[0].Verify(e.Get("info"), otherPub); err != ErrBadSignature {
+		t.Fatalf("Verify with other key: got: %v, want: %v", err, ErrBadSignature)
+	}
+
+	tampered := []byte(string(e.Get("info")))
+	tampered[len(tampered)-2] = 'b'
+
+	if err := sigs[0].Verify(tampered, pub); err != ErrBadSignature {
+		t.Fatalf("Verify tampered: got: %v, want: %v", err, ErrBadSignature)
+	}
+
+	if _, err := sigs[0].VerifyChain(e.Get("info"), x509.VerifyOptions{}); err != ErrNoCertificate {
+		t.Fatalf("VerifyChain: got: %v, want: %v", err, ErrNoCertificate)
+	}
+}
+
+func TestSignCertificates(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "builds.example"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEditor([]byte(signTestTorrent))
+	if err != nil {
+		t.Fatalf("NewEditor: %s", err)
+	}
+
+	if err := Sign(e, "z.rsa", rsaKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Sign(e, "a.ed25519", priv, der); err != nil {
+		t.Fatal(err)
+	}
+
+	e, sigs := resign(t, e)
+
+	if len(sigs) != 2 || string(sigs[0].Identity) != "a.ed25519" || string(sigs[1].Identity) != "z.rsa" {
+		t.Fatalf("got signatures: %+v", sigs)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	got, err := sigs[0].VerifyChain(e.Get("info"), x509.VerifyOptions{Roots: roots})
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+
+	if got.Subject.CommonName != "builds.example" {
+		t.Fatalf("got certificate for: %s", got.Subject.CommonName)
+	}
+
+	if _, err := sigs[0].VerifyChain(e.Get("info"), x509.VerifyOptions{Roots: x509.NewCertPool()}); err == nil {
+		t.Fatalf("VerifyChain with no roots: want error")
+	}
+
+	if err := sigs[1].Verify(e.Get("info"), &rsaKey.PublicKey); err != nil {
+		t.Fatalf("Verify RSA: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package metainfo
+
+import "github.com/joelancaster/bytepour/pkg/bencode/parse"
+
+// Trackers yields the tiers of tracker URLs to announce to.
+// When there is an announce-list (BEP 12) it is used and the
+// announce key ignored, otherwise the announce key, if any,
+// is the only tier. Empty tiers and URLs are left out.
+func (m *MetaInfoPreCompute) Trackers() [][][]byte {
+	var tiers [][][]byte
+
+	it := parse.NewListIter(Lookup(m.Extra, "announce-list"))
+	for it.Next() {
+		var tier [][]byte
+
+		urls := parse.NewListIter(it.Value())
+		for urls.Next() {
+			if u, ok := parse.Bytes(urls.Value()); ok && len(u) != 0 {
+				tier = append(tier, u)
+			}
+		}
+
+		if len(tier) != 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+
+	if len(tiers) == 0 && len(m.Announce) != 0 {
+		tiers = [][][]byte{{m.Announce}}
+	}
+
+	return tiers
+}
@@ -0,0 +1,34 @@
+package metainfo
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTrackers(t *testing.T) {
+	tests := []struct {
+		announce     string
+		announceList string
+		want         string
+	}{
+		{"", "", "[]"},
+		{"http://a", "", "[[http://a]]"},
+		{"http://a", "ll8:http://b8:http://cel8:http://dee", "[[http://b http://c] [http://d]]"},
+		// Empty tiers and URLs are left out.
+		{"http://a", "lle" + "l0:8:http://bee", "[[http://b]]"},
+		{"http://a", "le", "[[http://a]]"},
+		// Not a list of lists.
+		{"http://a", "8:http://b", "[[http://a]]"},
+	}
+
+	for _, test := range tests {
+		mi := MetaInfoPreCompute{Announce: []byte(test.announce)}
+		if test.announceList != "" {
+			mi.Extra = []RawField{{Key: []byte("announce-list"), Value: []byte(test.announceList)}}
+		}
+
+		if got := fmt.Sprintf("%s", mi.Trackers()); got != test.want {
+			t.Fatalf("Trackers(%q, %q) = %s, want %s", test.announce, test.announceList, got, test.want)
+		}
+	}
+}
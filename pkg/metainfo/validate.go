@@ -0,0 +1,263 @@
+package metainfo
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+// Severity is how serious a Finding is.
+type Severity byte
+
+const (
+	// The metainfo is usable, but unusual.
+	SeverityInfo = Severity(0)
+	// The metainfo is usable, but other
+	// clients may reject or mishandle it.
+	SeverityWarning = Severity(1)
+	// The metainfo is not usable.
+	SeverityError = Severity(2)
+)
+
+// String implements the Stringer interface for Severity.
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Finding is a problem found in a metainfo file by Validate.
+type Finding struct {
+	Severity Severity
+	// The key the finding is about, e.g. "info.piece length"
+	// or "info.files[3].path".
+	Field string
+	// Human readable explanation.
+	Reason string
+}
+
+// String implements the Stringer interface for Finding.
+func (f Finding) String() string {
+	return f.Severity.String() + ": " + f.Field + ": " + f.Reason
+}
+
+const (
+	// Smallest piece length in common use,
+	// one block.
+	minPieceLength = 16 << 10
+	// Largest piece length most clients accept.
+	maxPieceLength = 64 << 20
+)
+
+// Validate checks mi for missing required fields and
+// semantic problems, returning what it found.
+// An empty result means mi is fine.
+func Validate(mi *MetaInfoPreCompute) []Finding {
+	var fs []Finding
+
+	add := func(sev Severity, field, reason string) {
+		fs = append(fs, Finding{Severity: sev, Field: field, Reason: reason})
+	}
+
+	if len(mi.InfoDict) == 0 {
+		add(SeverityError, "info", "missing info dictionary")
+	}
+
+	if len(mi.Announce) != 0 {
+		if reason := checkTrackerURL(mi.Announce); reason != "" {
+			add(SeverityError, "announce", reason)
+		}
+	} else if len(mi.Trackers()) == 0 {
+		// Trackerless torrents are fine, if they can be found by DHT.
+		add(SeverityWarning, "announce", "no announce URL")
+	}
+
+	if list := Lookup(mi.Extra, "announce-list"); list != nil {
+		tiers := parse.NewListIter(list)
+		for i := 0; tiers.Next(); i++ {
+			urls := parse.NewListIter(tiers.Value())
+			for j := 0; urls.Next(); j++ {
+				field := "announce-list[" + strconv.Itoa(i) + "][" + strconv.Itoa(j) + "]"
+
+				if u, ok := parse.Bytes(urls.Value()); !ok {
+					add(SeverityError, field, "not a string")
+				} else if reason := checkTrackerURL(u); reason != "" {
+					add(SeverityError, field, reason)
+				}
+			}
+
+			if urls.Err().IsError() {
+				add(SeverityError, "announce-list["+strconv.Itoa(i)+"]", "not a list")
+			}
+		}
+
+		if tiers.Err().IsError() {
+			add(SeverityError, "announce-list", "not a list")
+		}
+	}
+
+	if seeds := Lookup(mi.Extra, "url-list"); seeds != nil {
+		// A single URL, or a list of them.
+		if u, ok := parse.Bytes(seeds); ok {
+			if len(u) != 0 {
+				if reason := checkWebSeedURL(u); reason != "" {
+					add(SeverityError, "url-list", reason)
+				}
+			}
+		} else {
+			it := parse.NewListIter(seeds)
+			for i := 0; it.Next(); i++ {
+				field := "url-list[" + strconv.Itoa(i) + "]"
+
+				if u, ok := parse.Bytes(it.Value()); !ok {
+					add(SeverityError, field, "not a string")
+				} else if reason := checkWebSeedURL(u); reason != "" {
+					add(SeverityError, field, reason)
+				}
+			}
+
+			if it.Err().IsError() {
+				add(SeverityError, "url-list", "not a string or list")
+			}
+		}
+	}
+
+	info := &mi.Info
+
+	if len(info.Name) == 0 {
+		add(SeverityError, "info.name", "missing name")
+	} else if reason := CheckPathComponent(info.Name); reason != "" {
+		add(SeverityError, "info.name", reason)
+	}
+
+	switch pl := info.PieceLength; {
+	case pl == 0:
+		add(SeverityError, "info.piece length", "missing piece length")
+	case pl&(pl-1) != 0:
+		add(SeverityWarning, "info.piece length", "not a power of two")
+	case pl < minPieceLength:
+		add(SeverityWarning, "info.piece length", "smaller than 16 KiB")
+	case pl > maxPieceLength:
+		add(SeverityWarning, "info.piece length", "larger than 64 MiB")
+	}
+
+	if info.Length != 0 && len(info.Files) != 0 {
+		add(SeverityError, "info", "has both length and files")
+	}
+
+	for i := 0; i < len(info.Files); i++ {
+		field := "info.files[" + strconv.Itoa(i) + "].path"
+		path := info.Files[i].Path
+
+		if len(path) == 0 {
+			add(SeverityError, field, "empty path")
+			continue
+		}
+
+		for j := 0; j < len(path); j++ {
+			if reason := CheckPathComponent(path[j]); reason != "" {
+				add(SeverityError, field, reason)
+				break
+			}
+		}
+	}
+
+	if info.Length == 0 && len(info.Files) == 0 {
+		add(SeverityError, "info.length", "missing length or files")
+	}
+
+	switch {
+	case len(info.Pieces) == 0:
+		add(SeverityError, "info.pieces", "missing pieces")
+	case len(info.Pieces)%20 != 0:
+		add(SeverityError, "info.pieces", "length is not a multiple of 20")
+	case info.PieceLength != 0 && len(info.Pieces)/20 != info.NumPieces():
+		add(SeverityError, "info.pieces",
+			"has "+strconv.Itoa(len(info.Pieces)/20)+" hashes, want "+
+				strconv.Itoa(info.NumPieces())+" for total length")
+	}
+
+	return fs
+}
+
+// HasErrors reports whether any of fs is
+// of SeverityError.
+func HasErrors(fs []Finding) bool {
+	for i := 0; i < len(fs); i++ {
+		if fs[i].Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkTrackerURL gives the reason u is not
+// a usable tracker URL, or the empty string.
+func checkTrackerURL(u []byte) string {
+	parsed, err := url.Parse(string(u))
+	if err != nil {
+		return "does not parse as a URL"
+	}
+
+	switch parsed.Scheme {
+	case "http", "https", "udp":
+	default:
+		return "unsupported scheme " + strconv.Quote(parsed.Scheme)
+	}
+
+	if parsed.Host == "" {
+		return "no host"
+	}
+
+	return ""
+}
+
+// checkWebSeedURL gives the reason u is not
+// a usable web seed URL, or the empty string.
+func checkWebSeedURL(u []byte) string {
+	parsed, err := url.Parse(string(u))
+	if err != nil {
+		return "does not parse as a URL"
+	}
+
+	switch parsed.Scheme {
+	case "http", "https":
+	default:
+		return "unsupported scheme " + strconv.Quote(parsed.Scheme)
+	}
+
+	if parsed.Host == "" {
+		return "no host"
+	}
+
+	return ""
+}
+
+// CheckPathComponent gives the reason c is not
+// safe to use as a single element of a file path,
+// or the empty string.
+func CheckPathComponent(c []byte) string {
+	switch {
+	case len(c) == 0:
+		return "empty path component"
+	case string(c) == "." || string(c) == "..":
+		return "relative path component " + strconv.Quote(string(c))
+	case bytes.IndexByte(c, '/') >= 0 || bytes.IndexByte(c, '\\') >= 0:
+		// Also catches absolute paths.
+		return "path separator in component " + strconv.Quote(string(c))
+	case bytes.IndexByte(c, 0) >= 0:
+		return "NUL in path component"
+	}
+
+	return ""
+}
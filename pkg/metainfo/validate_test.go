@@ -0,0 +1,241 @@
+package metainfo
+
+import (
+	"strings"
+	"testing"
+)
+
+// validMetaInfo is a small, well-formed
+// single file torrent of 3 pieces.
+func validMetaInfo() MetaInfoPreCompute {
+	return MetaInfoPreCompute{
+		Announce: []byte("http://tracker.example:6969/announce"),
+		InfoDict: []byte("d...e"),
+		Info: Info{
+			Length:      2*16384 + 100,
+			Name:        []byte("file.iso"),
+			PieceLength: 16384,
+			Pieces:      make([]byte, 3*20),
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(mi *MetaInfoPreCompute)
+		wantField string
+		wantSev   Severity
+	}{
+		{
+			name: "Valid",
+			edit: func(mi *MetaInfoPreCompute) {},
+		},
+		{
+			name: "MultiFile",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Info.Length = 0
+				mi.Info.Files = []File{
+					{Length: 16384, Path: [][]byte{[]byte("a"), []byte("b.txt")}},
+					{Length: 16484, Path: [][]byte{[]byte("c.txt")}},
+				}
+			},
+		},
+		{
+			name:      "NoName",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Info.Name = nil },
+			wantField: "info.name",
+			wantSev:   SeverityError,
+		},
+		{
+			name:      "NoPieces",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Info.Pieces = nil },
+			wantField: "info.pieces",
+			wantSev:   SeverityError,
+		},
+		{
+			name:      "NoPieceLength",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Info.PieceLength = 0 },
+			wantField: "info.piece length",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "PieceLengthNotPowerOfTwo",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Info.PieceLength = 20000
+				mi.Info.Length = 3 * 20000
+			},
+			wantField: "info.piece length",
+			wantSev:   SeverityWarning,
+		},
+		{
+			name:      "PieceLengthTiny",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Info.PieceLength = 1024; mi.Info.Length = 3000 },
+			wantField: "info.piece length",
+			wantSev:   SeverityWarning,
+		},
+		{
+			name:      "PiecesTooFew",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Info.Length *= 2 },
+			wantField: "info.pieces",
+			wantSev:   SeverityError,
+		},
+		{
+			name:      "PiecesRagged",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Info.Pieces = mi.Info.Pieces[:59] },
+			wantField: "info.pieces",
+			wantSev:   SeverityError,
+		},
+		{
+			name:      "NoAnnounce",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Announce = nil },
+			wantField: "announce",
+			wantSev:   SeverityWarning,
+		},
+		{
+			name:      "BadAnnounce",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Announce = []byte("ftp://tracker.example/") },
+			wantField: "announce",
+			wantSev:   SeverityError,
+		},
+		{
+			name:      "UnparseableAnnounce",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Announce = []byte("http://[::1") },
+			wantField: "announce",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "AnnounceListOnly",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Announce = nil
+				mi.Extra = []RawField{{Key: []byte("announce-list"), Value: []byte("ll18:udp://tracker.a:80ee")}}
+			},
+		},
+		{
+			name: "BadAnnounceList",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Extra = []RawField{{Key: []byte("announce-list"), Value: []byte("ll18:udp://tracker.a:80el3:a:bee")}}
+			},
+			wantField: "announce-list[1][0]",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "MalformedAnnounceList",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Extra = []RawField{{Key: []byte("announce-list"), Value: []byte("li1ee")}}
+			},
+			wantField: "announce-list[0]",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "WebSeeds",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Extra = []RawField{{Key: []byte("url-list"), Value: []byte("l15:https://seed.a/14:http://seed.b/e")}}
+			},
+		},
+		{
+			name: "BadWebSeed",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Extra = []RawField{{Key: []byte("url-list"), Value: []byte("l15:https://seed.a/13:udp://seed.b/e")}}
+			},
+			wantField: "url-list[1]",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "BadSingleWebSeed",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Extra = []RawField{{Key: []byte("url-list"), Value: []byte("6:seed.a")}}
+			},
+			wantField: "url-list",
+			wantSev:   SeverityError,
+		},
+		{
+			name:      "NoInfoDict",
+			edit:      func(mi *MetaInfoPreCompute) { mi.InfoDict = nil },
+			wantField: "info",
+			wantSev:   SeverityError,
+		},
+		{
+			name:      "DotDotName",
+			edit:      func(mi *MetaInfoPreCompute) { mi.Info.Name = []byte("..") },
+			wantField: "info.name",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "DotDotPath",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Info.Length = 0
+				mi.Info.Files = []File{
+					{Length: 32868, Path: [][]byte{[]byte(".."), []byte("etc"), []byte("passwd")}},
+				}
+			},
+			wantField: "info.files[0].path",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "AbsolutePath",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Info.Length = 0
+				mi.Info.Files = []File{
+					{Length: 32868, Path: [][]byte{[]byte("/etc/passwd")}},
+				}
+			},
+			wantField: "info.files[0].path",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "EmptyComponent",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Info.Length = 0
+				mi.Info.Files = []File{
+					{Length: 1, Path: [][]byte{[]byte("a")}},
+					{Length: 32867, Path: [][]byte{[]byte("a"), []byte("")}},
+				}
+			},
+			wantField: "info.files[1].path",
+			wantSev:   SeverityError,
+		},
+		{
+			name: "EmptyPath",
+			edit: func(mi *MetaInfoPreCompute) {
+				mi.Info.Length = 0
+				mi.Info.Files = []File{{Length: 32868}}
+			},
+			wantField: "info.files[0].path",
+			wantSev:   SeverityError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mi := validMetaInfo()
+			tc.edit(&mi)
+
+			fs := Validate(&mi)
+
+			if tc.wantField == "" {
+				if len(fs) != 0 {
+					t.Fatalf("%s: want no findings, got: %v", tc.name, fs)
+				}
+
+				return
+			}
+
+			if len(fs) != 1 {
+				t.Fatalf("%s: want one finding, got: %v", tc.name, fs)
+			}
+
+			if fs[0].Field != tc.wantField || fs[0].Severity != tc.wantSev {
+				t.Fatalf("%s: got: %s, want: %s: %s", tc.name, fs[0], tc.wantSev, tc.wantField)
+			}
+
+			if HasErrors(fs) != (tc.wantSev == SeverityError) {
+				t.Fatalf("%s: HasErrors disagrees with %s", tc.name, fs[0])
+			}
+
+			if !strings.HasPrefix(fs[0].String(), tc.wantSev.String()) {
+				t.Fatalf("%s: bad string: %s", tc.name, fs[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package peer
+
+import "math/bits"
+
+// Bits is the payload of a bitfield message, a bit for
+// each piece, set if the peer has it. The high bit of
+// the first byte is the first piece.
+type Bits []byte
+
+// NewBits makes Bits for n pieces, none of them set.
+func NewBits(n int) Bits {
+	return make(Bits, (n+7)/8)
+}
+
+// ValidBits reports whether p is a valid bitfield for
+// n pieces: long enough for them and no longer, with
+// the spare bits at the end clear.
+func ValidBits(p []byte, n int) bool {
+	if len(p) != (n+7)/8 {
+		return false
+	}
+
+	if spare := n % 8; spare != 0 {
+		return p[len(p)-1]&(0xFF>>spare) == 0
+	}
+
+	return true
+}
+
+// Has reports whether piece i is set.
+func (b Bits) Has(i int) bool {
+	return b[i/8]&(0x80>>(i%8)) != 0
+}
+
+// Set sets piece i.
+func (b Bits) Set(i int) {
+	b[i/8] |= 0x80 >> (i % 8)
+}
+
+// Clear clears piece i.
+func (b Bits) Clear(i int) {
+	b[i/8] &^= 0x80 >> (i % 8)
+}
+
+// Count is the number of pieces set.
+func (b Bits) Count() int {
+	var n int
+	for _, c := range b {
+		n += bits.OnesCount8(c)
+	}
+
+	return n
+}
@@ -0,0 +1,42 @@
+package peer
+
+import "testing"
+
+func TestBits(t *testing.T) {
+	b := NewBits(10)
+	if len(b) != 2 {
+		t.Fatalf("len = %d, want 2", len(b))
+	}
+
+	b.Set(0)
+	b.Set(9)
+	b.Set(5)
+	b.Clear(5)
+
+	if !b.Has(0) || !b.Has(9) || b.Has(5) || b.Has(1) || b.Count() != 2 {
+		t.Fatalf("bits = %08b", b)
+	}
+
+	if b[0] != 0x80 || b[1] != 0x40 {
+		t.Fatalf("bits = %08b, want high bit first", b)
+	}
+
+	tests := []struct {
+		p    []byte
+		n    int
+		want bool
+	}{
+		{[]byte{0xFF, 0xC0}, 10, true},
+		{[]byte{0xFF, 0xE0}, 10, false},
+		{[]byte{0xFF}, 10, false},
+		{[]byte{0xFF, 0xC0, 0x00}, 10, false},
+		{[]byte{0xFF}, 8, true},
+		{[]byte{}, 0, true},
+	}
+
+	for _, test := range tests {
+		if got := ValidBits(test.p, test.n); got != test.want {
+			t.Fatalf("ValidBits(%08b, %d) = %v, want %v", test.p, test.n, got, test.want)
+		}
+	}
+}
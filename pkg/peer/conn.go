@@ -0,0 +1,735 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+const (
+	// BlockLength is the length of the blocks
+	// pieces are requested in, but for the last
+	// block of the last piece.
+	BlockLength = 16 * 1024
+	// MaxBlockLength is the longest block a peer
+	// may request of us.
+	MaxBlockLength = 128 * 1024
+
+	defaultMaxRequests    = 16
+	defaultRequestTimeout = time.Minute
+	defaultSnubTimeout    = time.Minute
+	defaultKeepAlive      = 2 * time.Minute
+	defaultIdleTimeout    = 3 * time.Minute
+
+	// How often timeouts are checked for.
+	tick = time.Second
+	// Events buffered before reading
+	// from the peer waits for them.
+	eventBuffer = 64
+)
+
+var (
+	// ErrChoked is returned when requesting
+	// of a peer that is choking us.
+	ErrChoked = errors.New("peer: choked")
+	// ErrQueueFull is returned when requesting of a peer
+	// that already has as many requests as we allow.
+	ErrQueueFull = errors.New("peer: request queue full")
+	// ErrNoPiece is returned when requesting
+	// a piece the peer doesn't have.
+	ErrNoPiece = errors.New("peer: peer does not have piece")
+	// ErrIdle is returned when the peer
+	// has sent nothing for too long.
+	ErrIdle = errors.New("peer: connection idle")
+	// ErrClosed is returned when using
+	// a connection that was closed.
+	ErrClosed = errors.New("peer: connection closed")
+)
+
+// ProtocolError is returned when the peer breaks
+// the rules of the protocol, which ends the connection.
+type ProtocolError struct {
+	Reason string
+}
+
+// Error implements the error interface for ProtocolError.
+func (e *ProtocolError) Error() string {
+	return "peer: protocol violation: " + e.Reason
+}
+
+// Block is a part of a piece, the unit
+// that pieces are requested in.
+type Block struct {
+	Index, Begin, Length uint32
+}
+
+// EventKind is the type of an Event.
+type EventKind byte
+
+const (
+	// The peer choked us. Requests it
+	// dropped are in Blocks.
+	EventChoked = EventKind(iota + 1)
+	// The peer unchoked us.
+	EventUnchoked
+	// The peer is interested in us.
+	EventInterested
+	// The peer is no longer interested in us.
+	EventNotInterested
+	// The peer has a new piece, Index.
+	EventHave
+	// The peer sent its bitfield.
+	EventBitfield
+	// The peer requests Block of us.
+	EventRequest
+	// The peer cancelled its request for Block.
+	EventCancel
+	// The peer sent Block, whose data is Data.
+	EventBlock
+	// The peer's DHT port is Port.
+	EventPort
+	// The peer sent an extension message, Data.
+	EventExtended
+	// Our requests in Blocks went unanswered
+	// for too long, and were dropped.
+	EventTimeout
+	// The peer hasn't sent us a block
+	// we requested for too long.
+	EventSnubbed
+)
+
+// Event is something a peer did, for the
+// scheduler of the torrent to act on.
+type Event struct {
+	Kind   EventKind
+	Index  uint32
+	Block  Block
+	Data   []byte
+	Blocks []Block
+	Port   uint16
+}
+
+// Config configures a Conn. The zero value
+// is the defaults, for no pieces.
+type Config struct {
+	// The number of pieces in the torrent.
+	NumPieces int
+	// The most requests to have outstanding
+	// with the peer, 16 if zero.
+	MaxRequests int
+	// How long to wait for a requested block
+	// before dropping the request, a minute if zero.
+	RequestTimeout time.Duration
+	// How long without a requested block before the
+	// peer is snubbing us, a minute if zero.
+	SnubTimeout time.Duration
+	// How long to go without sending before sending
+	// a keep-alive, two minutes if zero.
+	KeepAlive time.Duration
+	// How long the peer may send nothing before the
+	// connection is closed, three minutes if zero.
+	IdleTimeout time.Duration
+	// The longest message to accept, after its
+	// length prefix. DefaultMaxLength if zero.
+	MaxLength uint32
+	// If nil, the system clock.
+	Clock clock.Clock
+}
+
+// Stats are the totals of block data
+// sent and received on a connection.
+type Stats struct {
+	Downloaded, Uploaded uint64
+}
+
+// Conn is a connection to a peer, after the handshake.
+// It tracks the state of both sides, sends messages on
+// behalf of the torrent's scheduler and turns messages
+// from the peer into Events.
+type Conn struct {
+	conn   net.Conn
+	cfg    Config
+	clk    clock.Clock
+	events chan Event
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	writeMu sync.Mutex
+	buf     MessageBuffer
+
+	mu             sync.Mutex
+	err            error
+	amChoking      bool
+	amInterested   bool
+	peerChoking    bool
+	peerInterested bool
+	// The pieces the peer has.
+	bits Bits
+	// Whether any message but the
+	// bitfield has been received.
+	started bool
+	// Our outstanding requests, oldest first.
+	pending []pendingRequest
+	snubbed bool
+	// When a block was last received, or
+	// we first requested one since.
+	lastBlock    time.Time
+	lastReceived time.Time
+	lastSent     time.Time
+	stats        Stats
+}
+
+type pendingRequest struct {
+	Block
+	at time.Time
+}
+
+// NewConn makes a Conn for conn, whose handshake is done.
+// Both sides start choking and not interested. Run must
+// be called to read from the peer.
+func NewConn(conn net.Conn, cfg Config) *Conn {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.System()
+	}
+
+	now := clk.Now()
+
+	return &Conn{
+		conn:         conn,
+		cfg:          cfg,
+		clk:          clk,
+		events:       make(chan Event, eventBuffer),
+		done:         make(chan struct{}),
+		amChoking:    true,
+		peerChoking:  true,
+		bits:         NewBits(cfg.NumPieces),
+		lastReceived: now,
+		lastSent:     now,
+	}
+}
+
+// Events delivers what the peer does. It is
+// closed once Run returns.
+func (c *Conn) Events() <-chan Event {
+	return c.events
+}
+
+// Run reads from the peer until the connection fails or is
+// closed, sending what it does to Events. It returns why
+// the connection ended, nil if Close was called.
+func (c *Conn) Run() error {
+	maintained := make(chan struct{})
+
+	go func() {
+		defer close(maintained)
+		c.maintain()
+	}()
+
+	// Nothing is emitted once events is closed.
+	defer func() {
+		<-maintained
+		close(c.events)
+	}()
+
+	d := NewDecoder(c.conn, c.cfg.MaxLength)
+
+	var m Message
+
+	for {
+		err := d.Decode(&m)
+		if err == nil {
+			err = c.handle(&m)
+		}
+
+		if err != nil {
+			c.fail(err)
+
+			return c.Err()
+		}
+	}
+}
+
+// handle updates our state for m and
+// emits the event for it, if any.
+func (c *Conn) handle(m *Message) error {
+	c.mu.Lock()
+
+	c.lastReceived = c.clk.Now()
+
+	if m.KeepAlive {
+		c.mu.Unlock()
+		return nil
+	}
+
+	// A bitfield may only be the first message.
+	first := !c.started
+	c.started = true
+
+	var e Event
+
+	switch m.Id {
+	case Choke:
+		c.peerChoking = true
+		e = Event{Kind: EventChoked, Blocks: c.dropPending()}
+	case Unchoke:
+		c.peerChoking = false
+		e = Event{Kind: EventUnchoked}
+	case Interested:
+		c.peerInterested = true
+		e = Event{Kind: EventInterested}
+	case NotInterested:
+		c.peerInterested = false
+		e = Event{Kind: EventNotInterested}
+	case Have:
+		if int(m.Index) >= c.cfg.NumPieces {
+			c.mu.Unlock()
+			return &ProtocolError{Reason: "have for piece out of range"}
+		}
+
+		c.bits.Set(int(m.Index))
+		e = Event{Kind: EventHave, Index: m.Index}
+	case Bitfield:
+		if !first {
+			c.mu.Unlock()
+			return &ProtocolError{Reason: "bitfield after other messages"}
+		}
+
+		if !ValidBits(m.Payload, c.cfg.NumPieces) {
+			c.mu.Unlock()
+			return &ProtocolError{Reason: "malformed bitfield"}
+		}
+
+		copy(c.bits, m.Payload)
+		e = Event{Kind: EventBitfield}
+	case Request:
+		b := Block{Index: m.Index, Begin: m.Begin, Length: m.Length}
+		if int(b.Index) >= c.cfg.NumPieces || b.Length == 0 || b.Length > MaxBlockLength {
+			c.mu.Unlock()
+			return &ProtocolError{Reason: "bad request"}
+		}
+
+		// Requests while choked are
+		// dropped, as the peer knows.
+		if c.amChoking {
+			c.mu.Unlock()
+			return nil
+		}
+
+		e = Event{Kind: EventRequest, Block: b}
+	case Cancel:
+		e = Event{Kind: EventCancel, Block: Block{Index: m.Index, Begin: m.Begin, Length: m.Length}}
+	case Piece:
+		b := Block{Index: m.Index, Begin: m.Begin, Length: uint32(len(m.Payload))}
+
+		// Blocks we didn't ask for, or
+		// stopped waiting for, are ignored.
+		if !c.removePending(b) {
+			c.mu.Unlock()
+			return nil
+		}
+
+		c.stats.Downloaded += uint64(b.Length)
+		c.lastBlock = c.lastReceived
+		c.snubbed = false
+
+		e = Event{Kind: EventBlock, Block: b, Data: append([]byte(nil), m.Payload...)}
+	case Port:
+		e = Event{Kind: EventPort, Port: m.Port}
+	case Extended:
+		e = Event{Kind: EventExtended, Data: append([]byte(nil), m.Payload...)}
+	}
+
+	c.mu.Unlock()
+
+	return c.emit(e)
+}
+
+// emit sends e to Events, unless the connection closes first.
+func (c *Conn) emit(e Event) error {
+	select {
+	case c.events <- e:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// maintain checks for timeouts and keeps
+// the connection alive until it's closed.
+func (c *Conn) maintain() {
+	for {
+		timer := c.clk.NewTimer(tick)
+
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		now := c.clk.Now()
+
+		c.mu.Lock()
+
+		idle := now.Sub(c.lastReceived) >= orDefault(c.cfg.IdleTimeout, defaultIdleTimeout)
+		keepAlive := now.Sub(c.lastSent) >= orDefault(c.cfg.KeepAlive, defaultKeepAlive)
+
+		var timedOut []Block
+
+		timeout := orDefault(c.cfg.RequestTimeout, defaultRequestTimeout)
+		for len(c.pending) != 0 && now.Sub(c.pending[0].at) >= timeout {
+			timedOut = append(timedOut, c.pending[0].Block)
+			c.pending = c.pending[1:]
+		}
+
+		// Snubbed once there's been no block for a while,
+		// for as long as we're still waiting on one.
+		snubbed := !c.snubbed && len(c.pending) != 0 &&
+			now.Sub(c.lastBlock) >= orDefault(c.cfg.SnubTimeout, defaultSnubTimeout)
+		if snubbed {
+			c.snubbed = true
+		}
+
+		c.mu.Unlock()
+
+		if idle {
+			c.fail(ErrIdle)
+			return
+		}
+
+		if keepAlive {
+			c.send(&Message{KeepAlive: true})
+		}
+
+		if len(timedOut) != 0 && c.emit(Event{Kind: EventTimeout, Blocks: timedOut}) != nil {
+			return
+		}
+
+		if snubbed && c.emit(Event{Kind: EventSnubbed}) != nil {
+			return
+		}
+	}
+}
+
+// Choke chokes the peer, which drops its requests.
+func (c *Conn) Choke() error {
+	return c.setChoking(true)
+}
+
+// Unchoke unchokes the peer, so it may request of us.
+func (c *Conn) Unchoke() error {
+	return c.setChoking(false)
+}
+
+func (c *Conn) setChoking(choking bool) error {
+	c.mu.Lock()
+	changed := c.amChoking != choking
+	c.amChoking = choking
+	c.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	if choking {
+		return c.send(&Message{Id: Choke})
+	}
+
+	return c.send(&Message{Id: Unchoke})
+}
+
+// Interested tells the peer we want pieces it has.
+func (c *Conn) Interested() error {
+	return c.setInterested(true)
+}
+
+// NotInterested tells the peer we no longer
+// want pieces it has.
+func (c *Conn) NotInterested() error {
+	return c.setInterested(false)
+}
+
+func (c *Conn) setInterested(interested bool) error {
+	c.mu.Lock()
+	changed := c.amInterested != interested
+	c.amInterested = interested
+	c.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	if interested {
+		return c.send(&Message{Id: Interested})
+	}
+
+	return c.send(&Message{Id: NotInterested})
+}
+
+// Have tells the peer we have piece index.
+func (c *Conn) Have(index uint32) error {
+	return c.send(&Message{Id: Have, Index: index})
+}
+
+// Bitfield tells the peer which pieces we have. It must be
+// sent first, if at all, and may be left out if we have none.
+func (c *Conn) Bitfield(bits Bits) error {
+	return c.send(&Message{Id: Bitfield, Payload: bits})
+}
+
+// Request asks the peer for b, which must be of a piece it
+// has. The peer must not be choking us, and the number of
+// outstanding requests must be below the configured limit.
+func (c *Conn) Request(b Block) error {
+	c.mu.Lock()
+
+	var err error
+
+	switch {
+	case c.peerChoking:
+		err = ErrChoked
+	case len(c.pending) >= orDefault(c.cfg.MaxRequests, defaultMaxRequests):
+		err = ErrQueueFull
+	case int(b.Index) >= c.cfg.NumPieces || !c.bits.Has(int(b.Index)):
+		err = ErrNoPiece
+	}
+
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+
+	now := c.clk.Now()
+
+	// The wait for a block starts with the first request.
+	if len(c.pending) == 0 {
+		c.lastBlock = now
+	}
+
+	c.pending = append(c.pending, pendingRequest{Block: b, at: now})
+	c.mu.Unlock()
+
+	return c.send(&Message{Id: Request, Index: b.Index, Begin: b.Begin, Length: b.Length})
+}
+
+// Cancel withdraws our request for b.
+func (c *Conn) Cancel(b Block) error {
+	c.mu.Lock()
+	found := c.removePending(b)
+	c.mu.Unlock()
+
+	if !found {
+		return nil
+	}
+
+	return c.send(&Message{Id: Cancel, Index: b.Index, Begin: b.Begin, Length: b.Length})
+}
+
+// SendBlock sends the peer data, the block b it requested.
+func (c *Conn) SendBlock(b Block, data []byte) error {
+	err := c.send(&Message{Id: Piece, Index: b.Index, Begin: b.Begin, Payload: data})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.stats.Uploaded += uint64(len(data))
+	c.mu.Unlock()
+
+	return nil
+}
+
+// SendExtended sends an extension message (BEP 10),
+// payload being its id then bencoded dictionary.
+func (c *Conn) SendExtended(payload []byte) error {
+	return c.send(&Message{Id: Extended, Payload: payload})
+}
+
+// send writes m, then its payload.
+func (c *Conn) send(m *Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+
+	_, err := c.conn.Write(PutMessage(&c.buf, m))
+
+	switch m.Id {
+	case Bitfield, Piece, Extended:
+		if err == nil && !m.KeepAlive {
+			_, err = c.conn.Write(m.Payload)
+		}
+	}
+
+	if err != nil {
+		c.fail(err)
+		return err
+	}
+
+	c.mu.Lock()
+	c.lastSent = c.clk.Now()
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.fail(nil)
+
+	return nil
+}
+
+// fail closes the connection because of err,
+// which is nil when closing on purpose.
+func (c *Conn) fail(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// Err is why the connection ended, nil if it
+// hasn't or was closed on purpose.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Done is closed when the connection ends.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// PeerChoking reports whether the peer is choking us.
+func (c *Conn) PeerChoking() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.peerChoking
+}
+
+// PeerInterested reports whether the
+// peer is interested in us.
+func (c *Conn) PeerInterested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.peerInterested
+}
+
+// AmChoking reports whether we are choking the peer.
+func (c *Conn) AmChoking() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.amChoking
+}
+
+// AmInterested reports whether we are
+// interested in the peer.
+func (c *Conn) AmInterested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.amInterested
+}
+
+// PeerHas reports whether the peer has piece index.
+func (c *Conn) PeerHas(index int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return index < c.cfg.NumPieces && c.bits.Has(index)
+}
+
+// PeerBits appends the pieces the peer has to dst.
+func (c *Conn) PeerBits(dst Bits) Bits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append(dst, c.bits...)
+}
+
+// Pending is the number of our requests
+// outstanding with the peer.
+func (c *Conn) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.pending)
+}
+
+// Snubbed reports whether the peer has gone
+// too long without sending a requested block.
+func (c *Conn) Snubbed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.snubbed
+}
+
+// Stats is how much block data has been exchanged.
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// RemoteAddr is the peer's address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// dropPending forgets every outstanding request.
+func (c *Conn) dropPending() []Block {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	blocks := make([]Block, len(c.pending))
+	for i, p := range c.pending {
+		blocks[i] = p.Block
+	}
+
+	c.pending = c.pending[:0]
+
+	return blocks
+}
+
+// removePending forgets the request for b,
+// reporting whether there was one.
+func (c *Conn) removePending(b Block) bool {
+	for i, p := range c.pending {
+		if p.Block == b {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func orDefault[T time.Duration | int](v, def T) T {
+	if v == 0 {
+		return def
+	}
+
+	return v
+}
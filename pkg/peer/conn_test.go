@@ -0,0 +1,355 @@
+package peer
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+// remote is the far side of a Conn, speaking raw messages.
+type remote struct {
+	conn     net.Conn
+	messages chan Message
+}
+
+func newTestConn(t *testing.T, cfg Config) (*Conn, *remote, chan error) {
+	a, b := net.Pipe()
+
+	c := NewConn(a, cfg)
+	r := &remote{conn: b, messages: make(chan Message, 64)}
+
+	go func() {
+		defer close(r.messages)
+
+		d := NewDecoder(b, 0)
+		for {
+			var m Message
+			if err := d.Decode(&m); err != nil {
+				return
+			}
+
+			m.Payload = append([]byte(nil), m.Payload...)
+			r.messages <- m
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run()
+	}()
+
+	t.Cleanup(func() {
+		c.Close()
+		b.Close()
+	})
+
+	return c, r, done
+}
+
+func (r *remote) send(t *testing.T, m Message) {
+	t.Helper()
+
+	if _, err := r.conn.Write(AppendMessage(nil, &m)); err != nil {
+		t.Fatalf("send %v: %v", m.Id, err)
+	}
+}
+
+func (r *remote) expect(t *testing.T, want Message) {
+	t.Helper()
+
+	m, ok := <-r.messages
+	if !ok || !reflect.DeepEqual(m, want) {
+		t.Fatalf("remote got %+v, want %+v", m, want)
+	}
+}
+
+func expectEvent(t *testing.T, c *Conn, want Event) {
+	t.Helper()
+
+	e, ok := <-c.Events()
+	if !ok || !reflect.DeepEqual(e, want) {
+		t.Fatalf("event = %+v, want %+v", e, want)
+	}
+}
+
+func TestConn(t *testing.T) {
+	c, r, _ := newTestConn(t, Config{NumPieces: 10, MaxRequests: 2})
+
+	if !c.AmChoking() || c.AmInterested() || !c.PeerChoking() || c.PeerInterested() {
+		t.Fatal("connection didn't start choked and not interested")
+	}
+
+	r.send(t, Message{Id: Bitfield, Payload: []byte{0x80, 0x40}})
+	expectEvent(t, c, Event{Kind: EventBitfield})
+
+	r.send(t, Message{Id: Have, Index: 1})
+	expectEvent(t, c, Event{Kind: EventHave, Index: 1})
+
+	if !c.PeerHas(0) || !c.PeerHas(1) || c.PeerHas(2) || !c.PeerHas(9) || c.PeerHas(10) {
+		t.Fatalf("peer bits = %08b", c.PeerBits(nil))
+	}
+
+	b0 := Block{Index: 0, Begin: 0, Length: BlockLength}
+	if err := c.Request(b0); err != ErrChoked {
+		t.Fatalf("Request while choked = %v, want %v", err, ErrChoked)
+	}
+
+	if err := c.Interested(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expect(t, Message{Id: Interested})
+
+	r.send(t, Message{Id: Unchoke})
+	expectEvent(t, c, Event{Kind: EventUnchoked})
+
+	if err := c.Request(Block{Index: 2, Length: BlockLength}); err != ErrNoPiece {
+		t.Fatalf("Request for missing piece = %v, want %v", err, ErrNoPiece)
+	}
+
+	b1 := Block{Index: 0, Begin: BlockLength, Length: BlockLength}
+	b2 := Block{Index: 1, Begin: 0, Length: BlockLength}
+
+	for _, b := range []Block{b0, b1} {
+		if err := c.Request(b); err != nil {
+			t.Fatal(err)
+		}
+
+		r.expect(t, Message{Id: Request, Index: b.Index, Begin: b.Begin, Length: b.Length})
+	}
+
+	if err := c.Request(b2); err != ErrQueueFull {
+		t.Fatalf("Request past the limit = %v, want %v", err, ErrQueueFull)
+	}
+
+	data := bytes.Repeat([]byte{7}, BlockLength)
+
+	// Blocks we didn't ask for are ignored.
+	r.send(t, Message{Id: Piece, Index: 5, Payload: data})
+	r.send(t, Message{Id: Piece, Index: b1.Index, Begin: b1.Begin, Payload: data})
+	expectEvent(t, c, Event{Kind: EventBlock, Block: b1, Data: data})
+
+	if c.Pending() != 1 || c.Stats().Downloaded != BlockLength {
+		t.Fatalf("pending = %d, stats = %+v", c.Pending(), c.Stats())
+	}
+
+	if err := c.Request(b2); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expect(t, Message{Id: Request, Index: b2.Index, Begin: b2.Begin, Length: b2.Length})
+
+	if err := c.Cancel(b2); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expect(t, Message{Id: Cancel, Index: b2.Index, Begin: b2.Begin, Length: b2.Length})
+
+	// Choking drops what's left.
+	r.send(t, Message{Id: Choke})
+	expectEvent(t, c, Event{Kind: EventChoked, Blocks: []Block{b0}})
+
+	if c.Pending() != 0 {
+		t.Fatalf("pending = %d after choke", c.Pending())
+	}
+}
+
+func TestConnUpload(t *testing.T) {
+	c, r, _ := newTestConn(t, Config{NumPieces: 4})
+
+	b := Block{Index: 3, Begin: 0, Length: 4}
+
+	// Requests while choked are dropped.
+	r.send(t, Message{Id: Request, Index: b.Index, Begin: b.Begin, Length: b.Length})
+	r.send(t, Message{Id: Interested})
+	expectEvent(t, c, Event{Kind: EventInterested})
+
+	if err := c.Unchoke(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expect(t, Message{Id: Unchoke})
+
+	r.send(t, Message{Id: Request, Index: b.Index, Begin: b.Begin, Length: b.Length})
+	expectEvent(t, c, Event{Kind: EventRequest, Block: b})
+
+	r.send(t, Message{Id: Cancel, Index: b.Index, Begin: b.Begin, Length: b.Length})
+	expectEvent(t, c, Event{Kind: EventCancel, Block: b})
+
+	if err := c.SendBlock(b, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expect(t, Message{Id: Piece, Index: b.Index, Begin: b.Begin, Payload: []byte("data")})
+
+	if err := c.Have(2); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expect(t, Message{Id: Have, Index: 2})
+
+	if c.Stats().Uploaded != 4 {
+		t.Fatalf("stats = %+v", c.Stats())
+	}
+}
+
+func TestConnTimeouts(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_000_000, 0))
+
+	c, r, done := newTestConn(t, Config{
+		NumPieces:      1,
+		RequestTimeout: time.Minute,
+		SnubTimeout:    30 * time.Second,
+		Clock:          clk,
+	})
+
+	r.send(t, Message{Id: Have, Index: 0})
+	expectEvent(t, c, Event{Kind: EventHave})
+	r.send(t, Message{Id: Unchoke})
+	expectEvent(t, c, Event{Kind: EventUnchoked})
+
+	b := Block{Length: BlockLength}
+	if err := c.Request(b); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expect(t, Message{Id: Request, Length: BlockLength})
+
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Second)
+	expectEvent(t, c, Event{Kind: EventSnubbed})
+
+	if !c.Snubbed() {
+		t.Fatal("not snubbed")
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Second)
+	expectEvent(t, c, Event{Kind: EventTimeout, Blocks: []Block{b}})
+
+	if c.Pending() != 0 {
+		t.Fatalf("pending = %d after timeout", c.Pending())
+	}
+
+	// Quiet connections are kept alive,
+	// then closed once the peer is too.
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	r.expect(t, Message{KeepAlive: true})
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+
+	if err := <-done; err != ErrIdle {
+		t.Fatalf("Run = %v, want %v", err, ErrIdle)
+	}
+
+	if _, ok := <-c.Events(); ok {
+		t.Fatal("events not closed")
+	}
+
+	if err := c.Have(0); err != ErrClosed {
+		t.Fatalf("Have after close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestConnTimeoutAfterClose(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		clk := clock.NewFake(time.Unix(1_000_000, 0))
+
+		c, r, done := newTestConn(t, Config{NumPieces: 1, RequestTimeout: time.Second, Clock: clk})
+
+		r.send(t, Message{Id: Have, Index: 0})
+		expectEvent(t, c, Event{Kind: EventHave})
+		r.send(t, Message{Id: Unchoke})
+		expectEvent(t, c, Event{Kind: EventUnchoked})
+
+		if err := c.Request(Block{Length: BlockLength}); err != nil {
+			t.Fatal(err)
+		}
+
+		r.expect(t, Message{Id: Request, Length: BlockLength})
+		clk.BlockUntil(1)
+
+		// The timeout races the connection ending.
+		go clk.Advance(time.Minute)
+		r.conn.Close()
+
+		<-done
+
+		for range c.Events() {
+		}
+	}
+}
+
+func TestConnProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []Message
+	}{
+		{"have out of range", []Message{{Id: Have, Index: 7}}},
+		{"late bitfield", []Message{{Id: Unchoke}, {Id: Bitfield, Payload: []byte{0}}}},
+		{"spare bits", []Message{{Id: Bitfield, Payload: []byte{0x01}}}},
+		{"long bitfield", []Message{{Id: Bitfield, Payload: []byte{0, 0}}}},
+		{"long request", []Message{{Id: Request, Length: MaxBlockLength + 1}}},
+	}
+
+	for _, tt := range tests {
+		c, r, done := newTestConn(t, Config{NumPieces: 7})
+
+		// The connection may close before all are written.
+		go func() {
+			for _, m := range tt.messages {
+				r.conn.Write(AppendMessage(nil, &m))
+			}
+		}()
+
+		for range c.Events() {
+		}
+
+		var perr *ProtocolError
+		if err := <-done; !errors.As(err, &perr) {
+			t.Fatalf("%s: Run = %v, want a protocol error", tt.name, err)
+		}
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	c, _, done := newTestConn(t, Config{})
+
+	c.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v after Close", err)
+	}
+}
+
+func TestExchangeHandshakes(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	ha := Handshake{InfoHash: [20]byte{1}, PeerId: [20]byte{'a'}}
+	hb := Handshake{InfoHash: [20]byte{1}, PeerId: [20]byte{'b'}}
+
+	errc := make(chan error, 1)
+
+	var gotA Handshake
+	go func() {
+		errc <- ExchangeHandshakes(b, &hb, &gotA)
+	}()
+
+	var gotB Handshake
+	if err := ExchangeHandshakes(a, &ha, &gotB); err != nil || gotB != hb {
+		t.Fatalf("ExchangeHandshakes = %+v, %v", gotB, err)
+	}
+
+	if err := <-errc; err != nil || gotA != ha {
+		t.Fatalf("ExchangeHandshakes = %+v, %v", gotA, err)
+	}
+}
@@ -0,0 +1,192 @@
+package peer
+
+import (
+	"errors"
+	"math"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+// ExtendedHandshakeId is the extension message id of the
+// extension protocol's handshake (BEP 10), which says what
+// ids the sender gave the extensions it supports.
+const ExtendedHandshakeId = 0
+
+// ExtensionMetadata is the name of the metadata
+// exchange extension (BEP 9).
+const ExtensionMetadata = "ut_metadata"
+
+// ExtendedHandshake is the payload of the
+// extension protocol's handshake (BEP 10).
+type ExtendedHandshake struct {
+	// The id the sender gave each extension it supports.
+	// Zero means the extension is not supported.
+	M map[string]byte
+	// The length of the info dictionary, if known (BEP 9).
+	MetadataSize int
+	// The sender's client name and version.
+	V []byte
+}
+
+// AppendExtendedHandshake appends the payload of an
+// extended message holding h to dst: its id, then h.
+func AppendExtendedHandshake(dst []byte, h *ExtendedHandshake) []byte {
+	dst = append(dst, ExtendedHandshakeId, parse.OpenDict)
+
+	dst = encode.String(dst, "m")
+	dst = append(dst, parse.OpenDict)
+
+	for _, name := range sortedKeys(h.M) {
+		dst = encode.KeyUint(dst, name, uint64(h.M[name]))
+	}
+
+	dst = append(dst, parse.EndTerm)
+
+	if h.MetadataSize != 0 {
+		dst = encode.KeyInt(dst, "metadata_size", int64(h.MetadataSize))
+	}
+
+	if len(h.V) != 0 {
+		dst = encode.KeyString(dst, "v", h.V)
+	}
+
+	return append(dst, parse.EndTerm)
+}
+
+// ParseExtendedHandshake parses the dictionary of an
+// extension protocol handshake, after its id, into h.
+// Unknown keys, and extensions with bad ids, are skipped.
+func ParseExtendedHandshake(h *ExtendedHandshake, p []byte) parse.Error {
+	*h = ExtendedHandshake{M: make(map[string]byte)}
+
+	it := parse.NewDictIter(p)
+	for it.Next() {
+		v := it.Value()
+
+		switch string(it.Key()) {
+		case "m":
+			m := parse.NewDictIter(v)
+			for m.Next() {
+				if id, ok := parse.Integer(m.Value()); ok && 0 < id && id < 256 {
+					h.M[string(m.Key())] = byte(id)
+				}
+			}
+		case "metadata_size":
+			if n, ok := parse.Integer(v); ok && n > 0 {
+				h.MetadataSize = int(n)
+			}
+		case "v":
+			h.V, _ = parse.ParseString(v)
+		}
+	}
+
+	return it.Err()
+}
+
+// MetadataPieceLength is the length of each piece of the
+// info dictionary, the last shorter, in metadata exchange.
+const MetadataPieceLength = 16 * 1024
+
+// maxMetadataPiece is the last piece of the longest info
+// dictionary we take, that its offset may not overflow.
+const maxMetadataPiece = math.MaxInt32 / MetadataPieceLength
+
+// Types of metadata exchange message.
+const (
+	MetadataRequest = 0
+	MetadataData    = 1
+	MetadataReject  = 2
+)
+
+// ErrBadMetadataMessage is returned for metadata
+// exchange messages that don't parse.
+var ErrBadMetadataMessage = errors.New("peer: malformed metadata message")
+
+// MetadataMessage is a message of the
+// metadata exchange extension (BEP 9).
+type MetadataMessage struct {
+	Type  int
+	Piece int
+	// Set for data, the length of the info dictionary.
+	TotalSize int
+	// Set for data, the piece itself.
+	Data []byte
+}
+
+// AppendMetadataMessage appends the payload of an
+// extended message holding m to dst: id, which the
+// receiver gave the extension, then m.
+func AppendMetadataMessage(dst []byte, id byte, m *MetadataMessage) []byte {
+	dst = append(dst, id, parse.OpenDict)
+	dst = encode.KeyInt(dst, "msg_type", int64(m.Type))
+	dst = encode.KeyInt(dst, "piece", int64(m.Piece))
+
+	if m.Type == MetadataData {
+		dst = encode.KeyInt(dst, "total_size", int64(m.TotalSize))
+	}
+
+	dst = append(dst, parse.EndTerm)
+
+	return append(dst, m.Data...)
+}
+
+// ParseMetadataMessage parses a metadata exchange message,
+// after its id, into m. The data of m aliases p.
+func ParseMetadataMessage(m *MetadataMessage, p []byte) error {
+	*m = MetadataMessage{Type: -1, Piece: -1}
+
+	n := parse.Skip(p)
+	if n < 0 {
+		return ErrBadMetadataMessage
+	}
+
+	it := parse.NewDictIter(p[:n])
+	for it.Next() {
+		v, ok := parse.Integer(it.Value())
+		if !ok {
+			continue
+		}
+
+		// Out of range, so as not to be truncated.
+		if v > math.MaxInt32 {
+			v = -1
+		}
+
+		switch string(it.Key()) {
+		case "msg_type":
+			m.Type = int(v)
+		case "piece":
+			m.Piece = int(v)
+		case "total_size":
+			m.TotalSize = int(v)
+		}
+	}
+
+	if it.Err().IsError() || m.Type < 0 || m.Piece < 0 || m.Piece > maxMetadataPiece ||
+		m.TotalSize < 0 || m.TotalSize > math.MaxInt32 {
+		return ErrBadMetadataMessage
+	}
+
+	if m.Type == MetadataData {
+		m.Data = p[n:]
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	// Few keys, so insertion sort.
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+
+	return keys
+}
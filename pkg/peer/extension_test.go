@@ -0,0 +1,70 @@
+package peer
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestExtendedHandshake(t *testing.T) {
+	h := ExtendedHandshake{
+		M:            map[string]byte{"ut_pex": 2, ExtensionMetadata: 1},
+		MetadataSize: 31235,
+		V:            []byte("bytepour"),
+	}
+
+	p := AppendExtendedHandshake(nil, &h)
+	want := "\x00d1:md11:ut_metadatai1e6:ut_pexi2ee13:metadata_sizei31235e1:v8:bytepoure"
+	if string(p) != want {
+		t.Fatalf("AppendExtendedHandshake = %q, want %q", p, want)
+	}
+
+	var got ExtendedHandshake
+	if err := ParseExtendedHandshake(&got, p[1:]); err.IsError() || !reflect.DeepEqual(got, h) {
+		t.Fatalf("ParseExtendedHandshake = %+v, %v, want %+v", got, err, h)
+	}
+
+	// Disabled extensions and bad ids are skipped.
+	p = []byte("d1:md1:ai0e1:bi256e1:ci-1e1:di3ee1:xi1ee")
+	if err := ParseExtendedHandshake(&got, p); err.IsError() || !reflect.DeepEqual(got.M, map[string]byte{"d": 3}) {
+		t.Fatalf("ParseExtendedHandshake = %+v, %v", got, err)
+	}
+
+	if err := ParseExtendedHandshake(&got, []byte("d1:m")); !err.IsError() {
+		t.Fatal("ParseExtendedHandshake of a truncated dictionary succeeded")
+	}
+}
+
+func TestMetadataMessage(t *testing.T) {
+	tests := []struct {
+		m    MetadataMessage
+		want string
+	}{
+		{MetadataMessage{Type: MetadataRequest}, "\x03d8:msg_typei0e5:piecei0ee"},
+		{MetadataMessage{Type: MetadataData, Piece: 1, TotalSize: 16390, Data: []byte("abcdef")}, "\x03d8:msg_typei1e5:piecei1e10:total_sizei16390eeabcdef"},
+		{MetadataMessage{Type: MetadataReject, Piece: 2}, "\x03d8:msg_typei2e5:piecei2ee"},
+	}
+
+	for _, tt := range tests {
+		p := AppendMetadataMessage(nil, 3, &tt.m)
+		if string(p) != tt.want {
+			t.Fatalf("AppendMetadataMessage(%+v) = %q, want %q", tt.m, p, tt.want)
+		}
+
+		var got MetadataMessage
+		if err := ParseMetadataMessage(&got, p[1:]); err != nil || got.Type != tt.m.Type || got.Piece != tt.m.Piece ||
+			got.TotalSize != tt.m.TotalSize || !bytes.Equal(got.Data, tt.m.Data) {
+			t.Fatalf("ParseMetadataMessage(%q) = %+v, %v", p[1:], got, err)
+		}
+	}
+
+	bad := []string{"", "d8:msg_typei0ee", "d5:piecei0ee", "d8:msg_typei0e5:piecei0e",
+		"d8:msg_typei0e5:piecei562949953421312ee", "d8:msg_typei0e5:piecei131072ee",
+		"d8:msg_typei1e5:piecei0e10:total_sizei-1ee"}
+	for _, p := range bad {
+		var m MetadataMessage
+		if err := ParseMetadataMessage(&m, []byte(p)); err != ErrBadMetadataMessage {
+			t.Fatalf("ParseMetadataMessage(%q) = %v, want %v", p, err, ErrBadMetadataMessage)
+		}
+	}
+}
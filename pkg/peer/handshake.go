@@ -0,0 +1,137 @@
+// Package peer speaks the peer wire protocol (BEP 3):
+// the handshake, then length-prefixed messages.
+package peer
+
+import (
+	"errors"
+	"io"
+)
+
+// Protocol is the protocol string
+// that starts every handshake.
+const Protocol = "BitTorrent protocol"
+
+// HandshakeLength is the length of a handshake: the protocol
+// string's length and the string itself, the reserved bytes,
+// the info hash and the peer ID.
+const HandshakeLength = 1 + len(Protocol) + 8 + 20 + 20
+
+// ErrBadProtocol is returned when a handshake
+// isn't for the BitTorrent protocol.
+var ErrBadProtocol = errors.New("peer: handshake is not for the BitTorrent protocol")
+
+// HandshakeBuffer is a working space
+// for encoding a handshake.
+type HandshakeBuffer [HandshakeLength]byte
+
+// Reserved is the reserved bytes of a handshake,
+// whose bits say which extensions a peer supports.
+type Reserved [8]byte
+
+// Bits of the reserved bytes, by byte then mask.
+const (
+	// The extension protocol (BEP 10).
+	reservedExtended, maskExtended = 5, 0x10
+	// The fast extension (BEP 6).
+	reservedFast, maskFast = 7, 0x04
+	// The DHT, and so the port message (BEP 5).
+	reservedDHT, maskDHT = 7, 0x01
+)
+
+// Extended reports whether the extension
+// protocol (BEP 10) is supported.
+func (r *Reserved) Extended() bool {
+	return r[reservedExtended]&maskExtended != 0
+}
+
+// SetExtended says the extension
+// protocol (BEP 10) is supported.
+func (r *Reserved) SetExtended() {
+	r[reservedExtended] |= maskExtended
+}
+
+// Fast reports whether the fast
+// extension (BEP 6) is supported.
+func (r *Reserved) Fast() bool {
+	return r[reservedFast]&maskFast != 0
+}
+
+// SetFast says the fast extension
+// (BEP 6) is supported.
+func (r *Reserved) SetFast() {
+	r[reservedFast] |= maskFast
+}
+
+// DHT reports whether the DHT (BEP 5) is supported.
+func (r *Reserved) DHT() bool {
+	return r[reservedDHT]&maskDHT != 0
+}
+
+// SetDHT says the DHT (BEP 5) is supported.
+func (r *Reserved) SetDHT() {
+	r[reservedDHT] |= maskDHT
+}
+
+// Handshake is the first thing each
+// side of a connection sends.
+type Handshake struct {
+	Reserved Reserved
+	InfoHash [20]byte
+	PeerId   [20]byte
+}
+
+// PutHandshake writes the encoding of h into buf.
+func PutHandshake(buf *HandshakeBuffer, h *Handshake) []byte {
+	buf[0] = byte(len(Protocol))
+	n := 1 + copy(buf[1:], Protocol)
+	n += copy(buf[n:], h.Reserved[:])
+	n += copy(buf[n:], h.InfoHash[:])
+	n += copy(buf[n:], h.PeerId[:])
+
+	return buf[:n]
+}
+
+// ParseHandshake parses the handshake p into h.
+func ParseHandshake(h *Handshake, p *HandshakeBuffer) error {
+	if p[0] != byte(len(Protocol)) || string(p[1:1+len(Protocol)]) != Protocol {
+		return ErrBadProtocol
+	}
+
+	n := 1 + len(Protocol)
+	n += copy(h.Reserved[:], p[n:])
+	n += copy(h.InfoHash[:], p[n:])
+	copy(h.PeerId[:], p[n:])
+
+	return nil
+}
+
+// ReadHandshake reads a handshake from r into h.
+func ReadHandshake(r io.Reader, h *Handshake) error {
+	var buf HandshakeBuffer
+
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+
+	return ParseHandshake(h, &buf)
+}
+
+// ExchangeHandshakes sends ours on rw while reading the peer's
+// handshake into theirs, so that neither side need go first.
+// If it fails, rw should be closed to end the write.
+func ExchangeHandshakes(rw io.ReadWriter, ours, theirs *Handshake) error {
+	var buf HandshakeBuffer
+
+	written := make(chan error, 1)
+
+	go func() {
+		_, err := rw.Write(PutHandshake(&buf, ours))
+		written <- err
+	}()
+
+	if err := ReadHandshake(rw, theirs); err != nil {
+		return err
+	}
+
+	return <-written
+}
@@ -0,0 +1,40 @@
+package peer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	want := Handshake{
+		InfoHash: [20]byte{1, 2, 3},
+		PeerId:   [20]byte([]byte("-BP0100-abcdefghijkl")),
+	}
+	want.Reserved.SetExtended()
+	want.Reserved.SetFast()
+
+	if !want.Reserved.Extended() || !want.Reserved.Fast() || want.Reserved.DHT() {
+		t.Fatalf("reserved = %x", want.Reserved)
+	}
+
+	var buf HandshakeBuffer
+
+	p := PutHandshake(&buf, &want)
+	if len(p) != HandshakeLength || !bytes.HasPrefix(p, []byte("\x13BitTorrent protocol\x00\x00\x00\x00\x00\x10\x00\x04\x01\x02\x03")) {
+		t.Fatalf("PutHandshake = %q", p)
+	}
+
+	var got Handshake
+	if err := ReadHandshake(bytes.NewReader(p), &got); err != nil || got != want {
+		t.Fatalf("ReadHandshake = %+v, %v, want %+v", got, err, want)
+	}
+
+	buf[1] = 'b'
+	if err := ParseHandshake(&got, &buf); err != ErrBadProtocol {
+		t.Fatalf("ParseHandshake = %v, want %v", err, ErrBadProtocol)
+	}
+
+	if err := ReadHandshake(bytes.NewReader(p[:40]), &got); err == nil {
+		t.Fatal("read a short handshake")
+	}
+}
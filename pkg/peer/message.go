@@ -0,0 +1,291 @@
+package peer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// MessageId is the type of a message,
+// the byte after its length prefix.
+type MessageId byte
+
+const (
+	Choke         = MessageId(0)
+	Unchoke       = MessageId(1)
+	Interested    = MessageId(2)
+	NotInterested = MessageId(3)
+	// Payload is a piece index.
+	Have = MessageId(4)
+	// Payload is a bit for each piece, high bit first.
+	Bitfield = MessageId(5)
+	// Payload is a piece index, an offset
+	// into it, and a length.
+	Request = MessageId(6)
+	// Payload is a piece index, an offset
+	// into it, then the block itself.
+	Piece = MessageId(7)
+	// Payload is as for Request.
+	Cancel = MessageId(8)
+	// Payload is the peer's DHT port (BEP 5).
+	Port = MessageId(9)
+	// Payload is an extension message id then
+	// its bencoded dictionary (BEP 10).
+	Extended = MessageId(20)
+)
+
+// String implements the Stringer interface for MessageId.
+func (id MessageId) String() string {
+	switch id {
+	case Choke:
+		return "choke"
+	case Unchoke:
+		return "unchoke"
+	case Interested:
+		return "interested"
+	case NotInterested:
+		return "not interested"
+	case Have:
+		return "have"
+	case Bitfield:
+		return "bitfield"
+	case Request:
+		return "request"
+	case Piece:
+		return "piece"
+	case Cancel:
+		return "cancel"
+	case Port:
+		return "port"
+	case Extended:
+		return "extended"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// The length prefix of a message.
+	lengthPrefix = 4
+	// The longest message without a variable payload,
+	// a request or cancel.
+	maxFixedLength = 1 + 4 + 4 + 4
+
+	// MessageBufferLength is the length of a MessageBuffer.
+	MessageBufferLength = lengthPrefix + maxFixedLength
+
+	// DefaultMaxLength is the longest message, after its length
+	// prefix, that a Decoder accepts by default. It allows for
+	// blocks up to 128 KiB and bitfields up to a million pieces.
+	DefaultMaxLength = 1 + 4 + 4 + 1<<17
+)
+
+var (
+	// ErrMessageTooLong is returned when a
+	// message is longer than we allow.
+	ErrMessageTooLong = errors.New("peer: message too long")
+	// ErrBadLength is returned when a message's
+	// length is wrong for its type.
+	ErrBadLength = errors.New("peer: wrong message length")
+	// ErrUnknownMessage is returned for
+	// messages of an unknown type.
+	ErrUnknownMessage = errors.New("peer: unknown message type")
+)
+
+// MessageBuffer is a working space for encoding a message.
+// It holds any message but for the payload of a bitfield,
+// piece or extended message.
+type MessageBuffer [MessageBufferLength]byte
+
+// Message is a message of the peer wire protocol.
+type Message struct {
+	// Nothing else is set for
+	// a keep-alive message.
+	KeepAlive bool
+	Id        MessageId
+	// Set for have, request, piece and cancel.
+	Index uint32
+	// Set for request, piece and cancel.
+	Begin uint32
+	// Set for request and cancel.
+	Length uint32
+	// Set for port.
+	Port uint16
+	// The bitfield, the block of a piece, or the extension
+	// message id and dictionary of an extended message.
+	Payload []byte
+}
+
+// PutMessage writes the encoding of m into buf, all but the
+// payload of a bitfield, piece or extended message, which
+// should be sent straight after.
+func PutMessage(buf *MessageBuffer, m *Message) []byte {
+	if m.KeepAlive {
+		binary.BigEndian.PutUint32(buf[:], 0)
+
+		return buf[:lengthPrefix]
+	}
+
+	buf[lengthPrefix] = byte(m.Id)
+	n := lengthPrefix + 1
+
+	var payload int
+
+	switch m.Id {
+	case Have:
+		binary.BigEndian.PutUint32(buf[n:], m.Index)
+		n += 4
+	case Request, Cancel:
+		binary.BigEndian.PutUint32(buf[n:], m.Index)
+		binary.BigEndian.PutUint32(buf[n+4:], m.Begin)
+		binary.BigEndian.PutUint32(buf[n+8:], m.Length)
+		n += 12
+	case Piece:
+		binary.BigEndian.PutUint32(buf[n:], m.Index)
+		binary.BigEndian.PutUint32(buf[n+4:], m.Begin)
+		n += 8
+		payload = len(m.Payload)
+	case Port:
+		binary.BigEndian.PutUint16(buf[n:], m.Port)
+		n += 2
+	case Bitfield, Extended:
+		payload = len(m.Payload)
+	}
+
+	binary.BigEndian.PutUint32(buf[:], uint32(n-lengthPrefix+payload))
+
+	return buf[:n]
+}
+
+// AppendMessage appends the whole encoding of m to dst.
+func AppendMessage(dst []byte, m *Message) []byte {
+	var buf MessageBuffer
+
+	dst = append(dst, PutMessage(&buf, m)...)
+
+	switch m.Id {
+	case Bitfield, Piece, Extended:
+		if !m.KeepAlive {
+			dst = append(dst, m.Payload...)
+		}
+	}
+
+	return dst
+}
+
+// ParseMessage parses the message p, without its length
+// prefix, into m. The payload of m aliases p. Messages of a
+// fixed length must be exactly that long.
+func ParseMessage(m *Message, p []byte) error {
+	*m = Message{}
+
+	if len(p) == 0 {
+		m.KeepAlive = true
+		return nil
+	}
+
+	m.Id = MessageId(p[0])
+	body := p[1:]
+
+	var want int
+
+	switch m.Id {
+	case Choke, Unchoke, Interested, NotInterested:
+		want = 0
+	case Have:
+		want = 4
+	case Request, Cancel:
+		want = 12
+	case Port:
+		want = 2
+	case Piece:
+		if len(body) < 8 {
+			return ErrBadLength
+		}
+
+		want = len(body)
+	case Bitfield, Extended:
+		if len(body) == 0 {
+			return ErrBadLength
+		}
+
+		want = len(body)
+	default:
+		return ErrUnknownMessage
+	}
+
+	if len(body) != want {
+		return ErrBadLength
+	}
+
+	switch m.Id {
+	case Have:
+		m.Index = binary.BigEndian.Uint32(body)
+	case Request, Cancel:
+		m.Index = binary.BigEndian.Uint32(body)
+		m.Begin = binary.BigEndian.Uint32(body[4:])
+		m.Length = binary.BigEndian.Uint32(body[8:])
+	case Piece:
+		m.Index = binary.BigEndian.Uint32(body)
+		m.Begin = binary.BigEndian.Uint32(body[4:])
+		m.Payload = body[8:]
+	case Port:
+		m.Port = binary.BigEndian.Uint16(body)
+	case Bitfield, Extended:
+		m.Payload = body
+	}
+
+	return nil
+}
+
+// Decoder reads messages from a stream,
+// reusing one buffer for all of them.
+type Decoder struct {
+	r io.Reader
+	// The longest message, after its length
+	// prefix, to accept.
+	maxLength uint32
+	prefix    [lengthPrefix]byte
+	buf       []byte
+}
+
+// NewDecoder makes a Decoder reading from r that accepts
+// messages up to maxLength long, after their length prefix.
+// Zero is DefaultMaxLength.
+func NewDecoder(r io.Reader, maxLength uint32) *Decoder {
+	if maxLength == 0 {
+		maxLength = DefaultMaxLength
+	}
+
+	return &Decoder{r: r, maxLength: maxLength}
+}
+
+// Decode reads the next message into m. The payload of m is
+// only valid until the next call to Decode. Messages longer
+// than allowed are not read, and ErrMessageTooLong returned.
+func (d *Decoder) Decode(m *Message) error {
+	if _, err := io.ReadFull(d.r, d.prefix[:]); err != nil {
+		return err
+	}
+
+	n := binary.BigEndian.Uint32(d.prefix[:])
+	if n > d.maxLength {
+		return ErrMessageTooLong
+	}
+
+	if uint32(cap(d.buf)) < n {
+		d.buf = make([]byte, n)
+	}
+
+	p := d.buf[:n]
+
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	return ParseMessage(m, p)
+}
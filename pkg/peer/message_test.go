@@ -0,0 +1,166 @@
+package peer
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		m    Message
+		wire string
+	}{
+		{Message{KeepAlive: true}, "\x00\x00\x00\x00"},
+		{Message{Id: Choke}, "\x00\x00\x00\x01\x00"},
+		{Message{Id: Unchoke}, "\x00\x00\x00\x01\x01"},
+		{Message{Id: Interested}, "\x00\x00\x00\x01\x02"},
+		{Message{Id: NotInterested}, "\x00\x00\x00\x01\x03"},
+		{Message{Id: Have, Index: 0x01020304}, "\x00\x00\x00\x05\x04\x01\x02\x03\x04"},
+		{Message{Id: Bitfield, Payload: []byte{0xF0}}, "\x00\x00\x00\x02\x05\xF0"},
+		{Message{Id: Request, Index: 1, Begin: 0x4000, Length: 0x4000}, "\x00\x00\x00\x0D\x06\x00\x00\x00\x01\x00\x00\x40\x00\x00\x00\x40\x00"},
+		{Message{Id: Piece, Index: 1, Begin: 2, Payload: []byte("abc")}, "\x00\x00\x00\x0C\x07\x00\x00\x00\x01\x00\x00\x00\x02abc"},
+		{Message{Id: Cancel, Index: 1, Begin: 2, Length: 3}, "\x00\x00\x00\x0D\x08\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00\x03"},
+		{Message{Id: Port, Port: 6881}, "\x00\x00\x00\x03\x09\x1A\xE1"},
+		{Message{Id: Extended, Payload: []byte("\x00de")}, "\x00\x00\x00\x04\x14\x00de"},
+	}
+
+	var stream []byte
+
+	for _, test := range tests {
+		got := AppendMessage(nil, &test.m)
+		if string(got) != test.wire {
+			t.Fatalf("%v: AppendMessage = %q, want %q", test.m.Id, got, test.wire)
+		}
+
+		var m Message
+		if err := ParseMessage(&m, got[lengthPrefix:]); err != nil || !reflect.DeepEqual(m, test.m) {
+			t.Fatalf("%v: ParseMessage = %+v, %v, want %+v", test.m.Id, m, err, test.m)
+		}
+
+		stream = append(stream, got...)
+	}
+
+	d := NewDecoder(bytes.NewReader(stream), 0)
+
+	for _, test := range tests {
+		var m Message
+		if err := d.Decode(&m); err != nil || !reflect.DeepEqual(m, test.m) {
+			t.Fatalf("%v: Decode = %+v, %v, want %+v", test.m.Id, m, err, test.m)
+		}
+	}
+
+	var m Message
+	if err := d.Decode(&m); err != io.EOF {
+		t.Fatalf("Decode at end = %v, want EOF", err)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	tests := []struct {
+		p    string
+		want error
+	}{
+		{"\x00\x00", ErrBadLength},
+		{"\x04\x00\x00\x00", ErrBadLength},
+		{"\x04\x00\x00\x00\x00\x00", ErrBadLength},
+		{"\x05", ErrBadLength},
+		{"\x06\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x40", ErrBadLength},
+		{"\x07\x00\x00\x00\x01\x00\x00\x00", ErrBadLength},
+		{"\x09\x1A", ErrBadLength},
+		{"\x14", ErrBadLength},
+		{"\x0A", ErrUnknownMessage},
+		{"\xFF\x00", ErrUnknownMessage},
+	}
+
+	for _, test := range tests {
+		var m Message
+		if err := ParseMessage(&m, []byte(test.p)); err != test.want {
+			t.Fatalf("ParseMessage(%q) = %v, want %v", test.p, err, test.want)
+		}
+	}
+
+	// An empty block is allowed.
+	var m Message
+	if err := ParseMessage(&m, []byte("\x07\x00\x00\x00\x01\x00\x00\x00\x00")); err != nil || len(m.Payload) != 0 {
+		t.Fatalf("ParseMessage of empty piece = %+v, %v", m, err)
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	var m Message
+
+	d := NewDecoder(bytes.NewReader([]byte("\x00\x00\x00\x06\x05abcde")), 5)
+	if err := d.Decode(&m); err != ErrMessageTooLong {
+		t.Fatalf("Decode = %v, want %v", err, ErrMessageTooLong)
+	}
+
+	d = NewDecoder(bytes.NewReader([]byte("\x00\x00\x00\x06\x05ab")), 0)
+	if err := d.Decode(&m); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Decode = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	d = NewDecoder(bytes.NewReader([]byte("\x00\x00")), 0)
+	if err := d.Decode(&m); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Decode = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestMessageAllocs(t *testing.T) {
+	block := make([]byte, 16384)
+	piece := AppendMessage(nil, &Message{Id: Piece, Index: 1, Payload: block})
+
+	var buf MessageBuffer
+	var m Message
+
+	r := bytes.NewReader(piece)
+	d := NewDecoder(r, 0)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		PutMessage(&buf, &Message{Id: Request, Index: 1, Begin: 2, Length: 16384})
+
+		r.Reset(piece)
+		if err := d.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if allocs != 0 {
+		t.Fatalf("allocs = %v, want 0", allocs)
+	}
+}
+
+func BenchmarkPutMessage(b *testing.B) {
+	var buf MessageBuffer
+
+	m := Message{Id: Request, Index: 1, Begin: 0x4000, Length: 0x4000}
+
+	for i := 0; i < b.N; i++ {
+		PutMessage(&buf, &m)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var stream []byte
+	for i := 0; i < 64; i++ {
+		stream = AppendMessage(stream, &Message{Id: Piece, Index: uint32(i), Payload: make([]byte, 16384)})
+	}
+
+	r := bytes.NewReader(stream)
+	d := NewDecoder(r, 0)
+
+	var m Message
+
+	b.SetBytes(int64(len(stream) / 64))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := d.Decode(&m); err == io.EOF {
+			r.Reset(stream)
+			i--
+		} else if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
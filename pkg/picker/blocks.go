@@ -0,0 +1,311 @@
+package picker
+
+import "github.com/joelancaster/bytepour/pkg/peer"
+
+// download is the blocks of a piece
+// being downloaded, and who has them.
+type download struct {
+	index  int
+	blocks []block
+	// Blocks not yet received and
+	// not requested of anyone.
+	unrequested int
+	// Blocks not yet received.
+	remaining int
+}
+
+type block struct {
+	// The peers the block is requested of, by the
+	// key given to Pick. More than one in end-game.
+	peers    []int
+	received bool
+}
+
+// EndGame reports whether the picker is in end-game mode: every
+// block left is requested, so they are requested again of other
+// peers, to not wait on the slowest.
+func (p *Picker) EndGame() bool {
+	return p.endGame
+}
+
+// Pick appends to dst up to n blocks to request of a peer that
+// has bits, which the picker knows the peer by key. Blocks of
+// pieces already started come first, so whole pieces are had
+// sooner, then new pieces, rarest first or in order if
+// sequential. In end-game, blocks requested of other
+// peers are picked again.
+func (p *Picker) Pick(dst []peer.Block, key int, bits peer.Bits, n int) []peer.Block {
+	for _, d := range p.downloads {
+		if n == 0 {
+			return dst
+		}
+
+		if d.unrequested != 0 && p.prio[d.index] != None && bits.Has(d.index) {
+			dst = p.pickBlocks(dst, d, key, &n, false)
+		}
+	}
+
+	for n != 0 {
+		i := p.next(bits)
+		if i < 0 {
+			break
+		}
+
+		dst = p.pickBlocks(dst, p.start(i), key, &n, false)
+	}
+
+	if !p.checkEndGame() || n == 0 {
+		return dst
+	}
+
+	for _, d := range p.downloads {
+		if n == 0 {
+			break
+		}
+
+		if p.prio[d.index] != None && bits.Has(d.index) {
+			dst = p.pickBlocks(dst, d, key, &n, true)
+		}
+	}
+
+	return dst
+}
+
+// next is the next piece to start that
+// a peer with bits has, or -1 if none.
+func (p *Picker) next(bits peer.Bits) int {
+	if p.sequential {
+		for i := p.cursor; i < p.numPieces; i++ {
+			if p.prio[i] != None && !p.have.Has(i) && !p.downloading.Has(i) && bits.Has(i) {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	// Pieces of priority None come after all others.
+	end := p.groupStart(None)
+
+	for k := 0; k < end; k++ {
+		i := p.order[k]
+		if !p.downloading.Has(int(i)) && bits.Has(int(i)) {
+			return int(i)
+		}
+	}
+
+	return -1
+}
+
+// start starts downloading piece i.
+func (p *Picker) start(i int) *download {
+	n := (int(p.PieceLength(i)) + peer.BlockLength - 1) / peer.BlockLength
+
+	d := &download{
+		index:       i,
+		blocks:      make([]block, n),
+		unrequested: n,
+		remaining:   n,
+	}
+
+	p.downloads = append(p.downloads, d)
+	p.started[int32(i)] = d
+	p.downloading.Set(i)
+
+	return d
+}
+
+// checkEndGame reports whether every wanted
+// piece we don't have is fully requested.
+func (p *Picker) checkEndGame() bool {
+	var wanted int
+
+	for _, d := range p.downloads {
+		if p.prio[d.index] == None {
+			continue
+		}
+
+		if d.unrequested != 0 {
+			return false
+		}
+
+		wanted++
+	}
+
+	p.endGame = wanted != 0 && wanted == p.wanted
+
+	return p.endGame
+}
+
+// pickBlocks appends blocks of d to dst, up to *n of them:
+// those requested of no one, or in end-game those requested
+// of others but not the peer key.
+func (p *Picker) pickBlocks(dst []peer.Block, d *download, key int, n *int, endGame bool) []peer.Block {
+	for j := range d.blocks {
+		if *n == 0 {
+			break
+		}
+
+		b := &d.blocks[j]
+		if b.received {
+			continue
+		}
+
+		if endGame {
+			if len(b.peers) == 0 || contains(b.peers, key) {
+				continue
+			}
+		} else if len(b.peers) != 0 {
+			continue
+		} else {
+			d.unrequested--
+		}
+
+		b.peers = append(b.peers, key)
+		dst = append(dst, p.block(d.index, j))
+		*n--
+	}
+
+	return dst
+}
+
+// block is the jth block of piece i.
+func (p *Picker) block(i, j int) peer.Block {
+	begin := uint32(j * peer.BlockLength)
+
+	return peer.Block{
+		Index:  uint32(i),
+		Begin:  begin,
+		Length: min(peer.BlockLength, p.PieceLength(i)-begin),
+	}
+}
+
+// find is the download and block index of b,
+// or nil if it isn't a block being downloaded.
+func (p *Picker) find(b peer.Block) (*download, int) {
+	d := p.started[int32(b.Index)]
+	if d == nil || b.Begin%peer.BlockLength != 0 {
+		return nil, 0
+	}
+
+	j := int(b.Begin / peer.BlockLength)
+	if j >= len(d.blocks) || p.block(d.index, j) != b {
+		return nil, 0
+	}
+
+	return d, j
+}
+
+// Wanted reports whether b is a block of a piece being
+// downloaded that is yet to be received, so should be
+// stored when it comes.
+func (p *Picker) Wanted(b peer.Block) bool {
+	d, j := p.find(b)
+
+	return d != nil && !d.blocks[j].received
+}
+
+// Received records that block b came from the peer key. It
+// appends to dst the other peers b was requested of, whose
+// requests should be cancelled, and reports whether every
+// block of the piece is now had, ready to be verified. Blocks
+// not requested, or already received, report false.
+func (p *Picker) Received(dst []int, key int, b peer.Block) ([]int, bool) {
+	d, j := p.find(b)
+	if d == nil || d.blocks[j].received {
+		return dst, false
+	}
+
+	blk := &d.blocks[j]
+	if len(blk.peers) == 0 {
+		d.unrequested--
+	}
+
+	for _, k := range blk.peers {
+		if k != key {
+			dst = append(dst, k)
+		}
+	}
+
+	blk.peers = nil
+	blk.received = true
+	d.remaining--
+
+	return dst, d.remaining == 0
+}
+
+// Dropped records that the request for b of the peer key
+// won't be answered, as it was cancelled, timed out or the
+// peer choked us, so the block may be picked again.
+func (p *Picker) Dropped(key int, b peer.Block) {
+	if d, j := p.find(b); d != nil {
+		p.drop(d, j, key)
+	}
+}
+
+// PeerGone drops every request of the peer key.
+func (p *Picker) PeerGone(key int) {
+	for _, d := range p.downloads {
+		for j := range d.blocks {
+			p.drop(d, j, key)
+		}
+	}
+}
+
+func (p *Picker) drop(d *download, j, key int) {
+	b := &d.blocks[j]
+
+	for k, pk := range b.peers {
+		if pk == key {
+			b.peers = append(b.peers[:k], b.peers[k+1:]...)
+
+			if len(b.peers) == 0 && !b.received {
+				d.unrequested++
+				p.endGame = false
+			}
+
+			return
+		}
+	}
+}
+
+// Verified records whether piece i, all of whose blocks were
+// received, matched its hash. If so we have it, and if not it
+// is downloaded again from scratch.
+func (p *Picker) Verified(i int, ok bool) {
+	if ok {
+		p.SetHave(i)
+		return
+	}
+
+	p.forget(i)
+	p.endGame = false
+}
+
+// forget stops the download of piece i, if any.
+func (p *Picker) forget(i int) {
+	d := p.started[int32(i)]
+	if d == nil {
+		return
+	}
+
+	delete(p.started, int32(i))
+	p.downloading.Clear(i)
+
+	for k, dk := range p.downloads {
+		if dk == d {
+			p.downloads = append(p.downloads[:k], p.downloads[k+1:]...)
+			break
+		}
+	}
+}
+
+func contains(keys []int, key int) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,347 @@
+// Package picker chooses which pieces, and which blocks
+// of them, to request of peers: rarest first, or in order
+// for streaming, by priority, ending in end-game mode.
+package picker
+
+import (
+	"math/rand/v2"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+)
+
+// Priority is how much a piece, or file, is wanted.
+type Priority uint8
+
+const (
+	// Pieces of priority None are not downloaded.
+	None   = Priority(0)
+	Low    = Priority(1)
+	Normal = Priority(4)
+	High   = Priority(7)
+	// MaxPriority is the highest priority.
+	MaxPriority = High
+)
+
+const (
+	// Pieces are grouped by priority, highest first,
+	// then pieces we have last.
+	numGroups = int(MaxPriority) + 2
+	haveGroup = numGroups - 1
+	// The number of peers that may have a piece before the
+	// order is rebuilt to allow for more. It doubles each time.
+	initialWidth = 64
+)
+
+// Picker chooses what to request. It keeps every piece in
+// one array, sorted by priority then availability, so that
+// a peer or have message moves each piece it counts just
+// one place, across the edge of its bucket. It is not safe
+// for concurrent use.
+type Picker struct {
+	numPieces   int
+	pieceLength uint64
+	// The length of the last piece.
+	lastLength uint64
+
+	// Where each file starts, then where the last ends.
+	fileOffsets []uint64
+	filePrio    []Priority
+
+	prio  []Priority
+	have  peer.Bits
+	avail []uint32
+	// The peers whose pieces are counted in avail.
+	counted map[int]bool
+	// The number of pieces of priority above None
+	// that we don't have.
+	wanted int
+
+	// The pieces, sorted by bucket: priority
+	// then availability, rarest first.
+	order []int32
+	// Where each piece is in order.
+	pos []int32
+	// One past the last place in order of each bucket.
+	ends []int32
+	// The number of buckets in each group,
+	// more than any piece's availability.
+	width int
+
+	// Pieces being downloaded, oldest first.
+	downloads   []*download
+	started     map[int32]*download
+	downloading peer.Bits
+
+	sequential bool
+	// No piece before it is missing.
+	cursor  int
+	endGame bool
+}
+
+// New makes a Picker for the pieces of info, all wanted at
+// Normal priority and none of them had. Ties of availability
+// are broken by a shuffle with r, or the global source if nil.
+func New(info *metainfo.Info, r *rand.Rand) *Picker {
+	n := info.NumPieces()
+
+	p := &Picker{
+		numPieces:   n,
+		pieceLength: info.PieceLength,
+		prio:        make([]Priority, n),
+		have:        peer.NewBits(n),
+		avail:       make([]uint32, n),
+		counted:     make(map[int]bool),
+		wanted:      n,
+		order:       make([]int32, n),
+		pos:         make([]int32, n),
+		width:       initialWidth,
+		started:     make(map[int32]*download),
+		downloading: peer.NewBits(n),
+	}
+
+	if n != 0 {
+		p.lastLength = info.PieceSize(n - 1)
+	}
+
+	if len(info.Files) == 0 {
+		p.fileOffsets = []uint64{0, info.Length}
+	} else {
+		p.fileOffsets = make([]uint64, len(info.Files)+1)
+		for i := range info.Files {
+			p.fileOffsets[i+1] = p.fileOffsets[i] + info.Files[i].Length
+		}
+	}
+
+	p.filePrio = make([]Priority, len(p.fileOffsets)-1)
+	for i := range p.filePrio {
+		p.filePrio[i] = Normal
+	}
+
+	for i := range p.prio {
+		p.prio[i] = Normal
+	}
+
+	for i := range p.order {
+		p.order[i] = int32(i)
+	}
+
+	shuffle := rand.Shuffle
+	if r != nil {
+		shuffle = r.Shuffle
+	}
+
+	shuffle(n, func(i, j int) {
+		p.order[i], p.order[j] = p.order[j], p.order[i]
+	})
+
+	p.rebuild()
+
+	return p
+}
+
+// NumPieces is the number of pieces in the torrent.
+func (p *Picker) NumPieces() int {
+	return p.numPieces
+}
+
+// PieceLength is the length of piece i,
+// which is short if it is the last.
+func (p *Picker) PieceLength(i int) uint32 {
+	if i == p.numPieces-1 {
+		return uint32(p.lastLength)
+	}
+
+	return uint32(p.pieceLength)
+}
+
+// Bits is the pieces we have. It must not be modified.
+func (p *Picker) Bits() peer.Bits {
+	return p.have
+}
+
+// Have reports whether we have piece i.
+func (p *Picker) Have(i int) bool {
+	return p.have.Has(i)
+}
+
+// Complete reports whether we have every wanted piece.
+func (p *Picker) Complete() bool {
+	return p.wanted == 0
+}
+
+// Availability is the number of peers that have piece i.
+func (p *Picker) Availability(i int) int {
+	return int(p.avail[i])
+}
+
+// SetSequential sets whether new pieces are
+// picked in order, rather than rarest first.
+func (p *Picker) SetSequential(sequential bool) {
+	p.sequential = sequential
+}
+
+// SetHave records that we have piece i, as
+// when resuming, and forgets any download of it.
+func (p *Picker) SetHave(i int) {
+	if p.have.Has(i) {
+		return
+	}
+
+	p.forget(i)
+
+	from := p.bucket(i)
+	if p.prio[i] != None {
+		p.wanted--
+	}
+
+	p.have.Set(i)
+	p.move(i, from, p.bucket(i))
+
+	for p.cursor < p.numPieces && p.have.Has(p.cursor) {
+		p.cursor++
+	}
+}
+
+// AddPeer counts the pieces of the peer key, which
+// joined or sent its bitfield. A peer already counted
+// is ignored, until removed.
+func (p *Picker) AddPeer(key int, bits peer.Bits) {
+	if p.counted[key] {
+		return
+	}
+
+	p.counted[key] = true
+	p.eachPiece(bits, p.peerHas)
+}
+
+// RemovePeer stops counting the pieces of the peer key,
+// as given to AddPeer and PeerHave, as it left or is to
+// be counted again. A peer not counted is ignored.
+func (p *Picker) RemovePeer(key int, bits peer.Bits) {
+	if !p.counted[key] {
+		return
+	}
+
+	delete(p.counted, key)
+	p.eachPiece(bits, p.peerLost)
+}
+
+// PeerHave counts piece i for the peer key, which now has it.
+func (p *Picker) PeerHave(key, i int) {
+	p.counted[key] = true
+	p.peerHas(i)
+}
+
+func (p *Picker) peerHas(i int) {
+	if int(p.avail[i])+1 >= p.width {
+		p.width *= 2
+		p.rebuild()
+	}
+
+	from := p.bucket(i)
+	p.avail[i]++
+	p.move(i, from, from+1)
+}
+
+func (p *Picker) peerLost(i int) {
+	from := p.bucket(i)
+	p.avail[i]--
+	p.move(i, from, from-1)
+}
+
+// eachPiece calls f for each piece set in bits.
+func (p *Picker) eachPiece(bits peer.Bits, f func(i int)) {
+	for j, c := range bits {
+		if c == 0 {
+			continue
+		}
+
+		for k := 0; k < 8; k++ {
+			if c&(0x80>>k) != 0 && j*8+k < p.numPieces {
+				f(j*8 + k)
+			}
+		}
+	}
+}
+
+// bucket is where piece i belongs in the order.
+func (p *Picker) bucket(i int) int {
+	g := int(MaxPriority - p.prio[i])
+	if p.have.Has(i) {
+		g = haveGroup
+	}
+
+	return g*p.width + int(p.avail[i])
+}
+
+// move moves piece i from bucket from to bucket to. Each
+// step swaps it to the edge of the bucket it's leaving,
+// which then shrinks by one to leave it in the next.
+func (p *Picker) move(i, from, to int) {
+	k := int(p.pos[i])
+
+	for b := from; b < to; b++ {
+		last := int(p.ends[b]) - 1
+		p.swap(k, last)
+		k = last
+		p.ends[b]--
+	}
+
+	for b := from; b > to; b-- {
+		first := int(p.ends[b-1])
+		p.swap(k, first)
+		k = first
+		p.ends[b-1]++
+	}
+}
+
+func (p *Picker) swap(j, k int) {
+	a, b := p.order[j], p.order[k]
+	p.order[j], p.order[k] = b, a
+	p.pos[a], p.pos[b] = int32(k), int32(j)
+}
+
+// rebuild sorts the order by bucket, keeping pieces in
+// the same bucket in the same order, and so shuffled.
+func (p *Picker) rebuild() {
+	p.ends = make([]int32, numGroups*p.width)
+
+	for i := 0; i < p.numPieces; i++ {
+		p.ends[p.bucket(i)]++
+	}
+
+	var sum int32
+	for b, n := range p.ends {
+		sum += n
+		p.ends[b] = sum
+	}
+
+	// Fill each bucket from its end, backwards.
+	order := make([]int32, p.numPieces)
+	for k := p.numPieces - 1; k >= 0; k-- {
+		i := p.order[k]
+		b := p.bucket(int(i))
+		p.ends[b]--
+		order[p.ends[b]] = i
+	}
+
+	// The ends are now the starts; shift them along.
+	copy(p.ends, p.ends[1:])
+	p.ends[len(p.ends)-1] = int32(p.numPieces)
+
+	p.order = order
+	for k, i := range order {
+		p.pos[i] = int32(k)
+	}
+}
+
+// groupStart is where the pieces of priority prio start.
+func (p *Picker) groupStart(prio Priority) int {
+	g := int(MaxPriority - prio)
+	if g == 0 {
+		return 0
+	}
+
+	return int(p.ends[g*p.width-1])
+}
@@ -0,0 +1,391 @@
+package picker
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+)
+
+// check checks the order is sorted into its buckets.
+func check(t *testing.T, p *Picker) {
+	t.Helper()
+
+	start := 0
+	for b, end := range p.ends {
+		for k := start; k < int(end); k++ {
+			i := int(p.order[k])
+			if p.pos[i] != int32(k) || p.bucket(i) != b {
+				t.Fatalf("piece %d at %d, pos %d, in bucket %d, want %d", i, k, p.pos[i], p.bucket(i), b)
+			}
+		}
+
+		start = int(end)
+	}
+
+	if start != p.numPieces {
+		t.Fatalf("buckets end at %d, want %d", start, p.numPieces)
+	}
+}
+
+func bitsOf(n int, pieces ...int) peer.Bits {
+	b := peer.NewBits(n)
+	for _, i := range pieces {
+		b.Set(i)
+	}
+
+	return b
+}
+
+func all(n int) peer.Bits {
+	b := peer.NewBits(n)
+	for i := 0; i < n; i++ {
+		b.Set(i)
+	}
+
+	return b
+}
+
+// pieces is the pieces of blocks, in order, once each.
+func pieces(blocks []peer.Block) []int {
+	var ps []int
+	for _, b := range blocks {
+		if len(ps) == 0 || ps[len(ps)-1] != int(b.Index) {
+			ps = append(ps, int(b.Index))
+		}
+	}
+
+	return ps
+}
+
+func newPicker(t *testing.T, info *metainfo.Info) *Picker {
+	p := New(info, rand.New(rand.NewPCG(1, 2)))
+	check(t, p)
+
+	return p
+}
+
+func TestPieceLength(t *testing.T) {
+	p := newPicker(t, &metainfo.Info{Length: 2*32768 + 100, PieceLength: 32768})
+
+	if p.NumPieces() != 3 || p.PieceLength(0) != 32768 || p.PieceLength(2) != 100 {
+		t.Fatalf("pieces = %d, lengths %d, %d", p.NumPieces(), p.PieceLength(0), p.PieceLength(2))
+	}
+
+	got := p.Pick(nil, 1, bitsOf(3, 2), 10)
+	want := []peer.Block{{Index: 2, Length: 100}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Pick = %v, want %v", got, want)
+	}
+}
+
+func TestRarestFirst(t *testing.T) {
+	const n = 8
+
+	p := newPicker(t, &metainfo.Info{Length: n * peer.BlockLength, PieceLength: peer.BlockLength})
+
+	p.AddPeer(1, all(n))
+	p.AddPeer(2, bitsOf(n, 0, 1, 2, 3, 4, 5))
+	p.AddPeer(3, bitsOf(n, 0, 1, 2, 3))
+	p.AddPeer(4, bitsOf(n, 0, 1))
+	p.PeerHave(5, 0)
+	check(t, p)
+
+	if p.Availability(0) != 5 || p.Availability(7) != 1 {
+		t.Fatalf("availability = %d, %d", p.Availability(0), p.Availability(7))
+	}
+
+	// Ties are broken at random, so compare by availability.
+	got := pieces(p.Pick(nil, 1, all(n), n))
+	for k := 1; k < len(got); k++ {
+		if p.Availability(got[k-1]) > p.Availability(got[k]) {
+			t.Fatalf("Pick = %v, not rarest first", got)
+		}
+	}
+
+	if len(got) != n || got[n-1] != 0 {
+		t.Fatalf("Pick = %v", got)
+	}
+
+	p.RemovePeer(1, all(n))
+	p.RemovePeer(2, bitsOf(n, 0, 1, 2, 3, 4, 5))
+	check(t, p)
+
+	if p.Availability(0) != 3 || p.Availability(7) != 0 {
+		t.Fatalf("availability = %d, %d", p.Availability(0), p.Availability(7))
+	}
+}
+
+func TestRemoveUncounted(t *testing.T) {
+	const n = 8
+
+	p := newPicker(t, &metainfo.Info{Length: n * peer.BlockLength, PieceLength: peer.BlockLength})
+	p.AddPeer(1, bitsOf(n, 0, 1))
+	p.AddPeer(2, bitsOf(n, 1, 2))
+
+	// A peer never counted, one removed twice,
+	// and one counted twice are each uncounted once.
+	p.RemovePeer(3, all(n))
+	p.RemovePeer(1, bitsOf(n, 0, 1))
+	p.RemovePeer(1, bitsOf(n, 0, 1))
+	p.AddPeer(2, bitsOf(n, 1, 2))
+	check(t, p)
+
+	for i, want := range []int{0, 1, 1, 0, 0, 0, 0, 0} {
+		if p.Availability(i) != want {
+			t.Fatalf("availability of %d = %d, want %d", i, p.Availability(i), want)
+		}
+	}
+
+	// Those of a peer counted by its haves alone are uncounted too.
+	p.PeerHave(4, 7)
+	p.RemovePeer(4, bitsOf(n, 7))
+	p.RemovePeer(2, bitsOf(n, 1, 2))
+	check(t, p)
+
+	for i := 0; i < n; i++ {
+		if p.Availability(i) != 0 {
+			t.Fatalf("availability of %d = %d, want 0", i, p.Availability(i))
+		}
+	}
+}
+
+func TestRandomTies(t *testing.T) {
+	info := &metainfo.Info{Length: 64 * peer.BlockLength, PieceLength: peer.BlockLength}
+
+	seen := make(map[uint32]bool)
+	for seed := uint64(0); seed < 8; seed++ {
+		p := New(info, rand.New(rand.NewPCG(seed, 0)))
+		seen[p.Pick(nil, 1, all(64), 1)[0].Index] = true
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("the same of 64 equally rare pieces was picked every time")
+	}
+}
+
+func TestManyPeers(t *testing.T) {
+	const n = 4
+
+	p := newPicker(t, &metainfo.Info{Length: n * peer.BlockLength, PieceLength: peer.BlockLength})
+
+	// More peers than fit, so the order is rebuilt.
+	for k := 0; k < 3*initialWidth; k++ {
+		p.AddPeer(k, bitsOf(n, 0, 1, 2))
+		p.PeerHave(k, k%2)
+	}
+
+	check(t, p)
+
+	if p.Availability(0) != 3*initialWidth+3*initialWidth/2 || p.Availability(3) != 0 {
+		t.Fatalf("availability = %d, %d", p.Availability(0), p.Availability(3))
+	}
+
+	got := pieces(p.Pick(nil, 1, all(n), n))
+	if len(got) != n || got[0] != 3 || got[1] != 2 {
+		t.Fatalf("Pick = %v, want 3 then 2 first", got)
+	}
+}
+
+func TestSequential(t *testing.T) {
+	const n = 6
+
+	p := newPicker(t, &metainfo.Info{Length: n * 2 * peer.BlockLength, PieceLength: 2 * peer.BlockLength})
+	p.SetSequential(true)
+	p.SetHave(0)
+	p.SetHave(2)
+	p.AddPeer(1, bitsOf(n, 5))
+
+	got := p.Pick(nil, 1, all(n), 5)
+	want := []peer.Block{
+		{Index: 1, Begin: 0, Length: peer.BlockLength},
+		{Index: 1, Begin: peer.BlockLength, Length: peer.BlockLength},
+		{Index: 3, Begin: 0, Length: peer.BlockLength},
+		{Index: 3, Begin: peer.BlockLength, Length: peer.BlockLength},
+		{Index: 4, Begin: 0, Length: peer.BlockLength},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Pick = %v, want %v", got, want)
+	}
+
+	// Pieces started come first, before the next.
+	p.Dropped(1, want[2])
+
+	if got := p.Pick(nil, 2, all(n), 2); !reflect.DeepEqual(got, []peer.Block{want[2], {Index: 4, Begin: peer.BlockLength, Length: peer.BlockLength}}) {
+		t.Fatalf("Pick = %v", got)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	// Files of 1.5, 0, 1.5 and 1 pieces.
+	const pl = peer.BlockLength
+
+	p := newPicker(t, &metainfo.Info{
+		PieceLength: pl,
+		Files: []metainfo.File{
+			{Length: pl + pl/2},
+			{Length: 0},
+			{Length: pl + pl/2},
+			{Length: pl},
+		},
+	})
+
+	p.SetFilePriority(2, None)
+	check(t, p)
+
+	// The second piece is shared with the first file.
+	want := []Priority{Normal, Normal, None, Normal}
+	for i, prio := range want {
+		if p.Priority(i) != prio {
+			t.Fatalf("priority of piece %d = %d, want %d", i, p.Priority(i), prio)
+		}
+	}
+
+	p.SetFilePriority(3, High)
+	p.SetFilePriority(0, Low)
+	p.AddPeer(1, bitsOf(4, 0))
+	check(t, p)
+
+	// High first, then the rarer of the Low.
+	got := pieces(p.Pick(nil, 1, all(4), 4))
+	if !reflect.DeepEqual(got, []int{3, 1, 0}) {
+		t.Fatalf("Pick = %v, want [3 1 0]", got)
+	}
+
+	if p.Complete() {
+		t.Fatal("complete with nothing had")
+	}
+
+	for _, i := range got {
+		p.SetHave(i)
+	}
+
+	if !p.Complete() {
+		t.Fatal("not complete without pieces of priority None")
+	}
+
+	check(t, p)
+}
+
+func TestEndGame(t *testing.T) {
+	const n = 2
+
+	p := newPicker(t, &metainfo.Info{Length: n * 2 * peer.BlockLength, PieceLength: 2 * peer.BlockLength})
+
+	got := p.Pick(nil, 1, all(n), 3)
+	if len(got) != 3 || p.EndGame() {
+		t.Fatalf("Pick = %v, end-game %v", got, p.EndGame())
+	}
+
+	last := p.Pick(nil, 2, all(n), 1)
+	if len(last) != 1 || !p.EndGame() {
+		t.Fatalf("Pick = %v, end-game %v", last, p.EndGame())
+	}
+
+	// Peer 2 gets what peer 1 is yet to send, and
+	// peer 1 gets nothing it already asked for.
+	if dup := p.Pick(nil, 2, all(n), 5); !reflect.DeepEqual(dup, got) {
+		t.Fatalf("Pick in end-game = %v, want %v", dup, got)
+	}
+
+	if dup := p.Pick(nil, 1, all(n), 5); !reflect.DeepEqual(dup, last) {
+		t.Fatalf("Pick in end-game = %v, want %v", dup, last)
+	}
+
+	if !p.Wanted(got[0]) {
+		t.Fatalf("Wanted(%v) = false", got[0])
+	}
+
+	cancel, done := p.Received(nil, 2, got[0])
+	if !reflect.DeepEqual(cancel, []int{1}) || done {
+		t.Fatalf("Received = %v, %v", cancel, done)
+	}
+
+	if p.Wanted(got[0]) {
+		t.Fatalf("Wanted(%v) = true once received", got[0])
+	}
+
+	// Received twice.
+	if cancel, done := p.Received(nil, 1, got[0]); cancel != nil || done {
+		t.Fatalf("Received again = %v, %v", cancel, done)
+	}
+
+	if _, done := p.Received(nil, 1, got[1]); !done {
+		t.Fatal("piece not done")
+	}
+
+	p.Verified(int(got[0].Index), false)
+
+	if p.EndGame() {
+		t.Fatal("still in end-game with a piece to download again")
+	}
+
+	again := p.Pick(nil, 3, all(n), 2)
+	if !reflect.DeepEqual(again, got[:2]) {
+		t.Fatalf("Pick after failing = %v, want %v", again, got[:2])
+	}
+}
+
+func TestPeerGone(t *testing.T) {
+	p := newPicker(t, &metainfo.Info{Length: 4 * peer.BlockLength, PieceLength: 4 * peer.BlockLength})
+
+	got := p.Pick(nil, 1, all(1), 2)
+	if rest := p.Pick(nil, 2, all(1), 2); len(rest) != 2 {
+		t.Fatalf("Pick = %v", rest)
+	}
+
+	p.PeerGone(1)
+
+	if again := p.Pick(nil, 3, all(1), 2); !reflect.DeepEqual(again, got) {
+		t.Fatalf("Pick after peer gone = %v, want %v", again, got)
+	}
+}
+
+func BenchmarkPeerHave(b *testing.B) {
+	const n = 200_000
+
+	p := New(&metainfo.Info{Length: n * peer.BlockLength, PieceLength: peer.BlockLength}, nil)
+	bits := all(n)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p.AddPeer(1, bits)
+		p.RemovePeer(1, bits)
+	}
+}
+
+func BenchmarkPick(b *testing.B) {
+	const n = 200_000
+
+	p := New(&metainfo.Info{Length: n * peer.BlockLength, PieceLength: peer.BlockLength}, nil)
+
+	// A peer with one piece in a hundred, and most of ours.
+	sparse := peer.NewBits(n)
+	for i := 0; i < n; i += 100 {
+		sparse.Set(i)
+	}
+
+	p.AddPeer(1, sparse)
+	p.AddPeer(2, all(n))
+
+	for i := 0; i < n*9/10; i++ {
+		p.SetHave(i)
+	}
+
+	dst := make([]peer.Block, 0, 16)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		dst = p.Pick(dst[:0], 1, sparse, 16)
+		for _, blk := range dst {
+			p.Dropped(1, blk)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package picker
+
+import "sort"
+
+// Priority is the priority of piece i.
+func (p *Picker) Priority(i int) Priority {
+	return p.prio[i]
+}
+
+// SetPriority sets the priority of piece i, until the
+// priority of a file it is part of is next set.
+func (p *Picker) SetPriority(i int, prio Priority) {
+	prio = min(prio, MaxPriority)
+	if p.prio[i] == prio {
+		return
+	}
+
+	if !p.have.Has(i) {
+		switch {
+		case p.prio[i] == None:
+			p.wanted++
+		case prio == None:
+			p.wanted--
+		}
+	}
+
+	from := p.bucket(i)
+	p.prio[i] = prio
+	p.move(i, from, p.bucket(i))
+}
+
+// FilePriority is the priority of file f, by its
+// index in the files of the info dictionary.
+func (p *Picker) FilePriority(f int) Priority {
+	return p.filePrio[f]
+}
+
+// SetFilePriority sets the priority of file f, by its
+// index in the files of the info dictionary. Each of its
+// pieces takes the highest priority of the files it is
+// part of.
+func (p *Picker) SetFilePriority(f int, prio Priority) {
+	p.filePrio[f] = min(prio, MaxPriority)
+
+	start, end := p.fileOffsets[f], p.fileOffsets[f+1]
+	if start == end || p.pieceLength == 0 {
+		return
+	}
+
+	for i := int(start / p.pieceLength); i <= int((end-1)/p.pieceLength); i++ {
+		p.SetPriority(i, p.piecePriority(i))
+	}
+}
+
+// piecePriority is the highest priority
+// of the files piece i is part of.
+func (p *Picker) piecePriority(i int) Priority {
+	start := uint64(i) * p.pieceLength
+	end := start + uint64(p.PieceLength(i))
+
+	// The first file ending after the piece starts.
+	f := sort.Search(len(p.filePrio), func(f int) bool {
+		return p.fileOffsets[f+1] > start
+	})
+
+	prio := None
+	for ; f < len(p.filePrio) && p.fileOffsets[f] < end; f++ {
+		if p.fileOffsets[f] != p.fileOffsets[f+1] {
+			prio = max(prio, p.filePrio[f])
+		}
+	}
+
+	return prio
+}
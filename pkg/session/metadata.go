@@ -0,0 +1,213 @@
+package session
+
+import (
+	"context"
+	"crypto/sha1"
+	"net/netip"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/aot"
+	"github.com/joelancaster/bytepour/pkg/bittorrent"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+)
+
+const (
+	// The id we give metadata exchange.
+	metadataId = 1
+	// Peers to fetch the metadata from at once.
+	maxFetchers = 4
+	// How long to spend fetching from one peer.
+	fetchTimeout = time.Minute
+	// The longest info dictionary we fetch.
+	maxMetadataSize = 32 << 20
+	// What's announced as left before the
+	// metadata, and so the length, is had.
+	unknownLeft = 1
+)
+
+// clientVersion is what we tell peers we run.
+var clientVersion = []byte("bytepour " + bittorrent.DefaultVersion.String())
+
+// fetchMetadata fetches the info dictionary from the peer at
+// addr (BEP 9), until it's had from this peer or another,
+// when ctx is done.
+func (s *Session) fetchMetadata(ctx context.Context, addr netip.AddrPort) {
+	conn, theirs, err := s.dialPeer(ctx, addr)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	if !theirs.Reserved.Extended() || theirs.PeerId == s.peerId {
+		return
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(fetchTimeout))
+
+	hs := peer.AppendExtendedHandshake(nil, &peer.ExtendedHandshake{
+		M: map[string]byte{peer.ExtensionMetadata: metadataId},
+		V: clientVersion,
+	})
+
+	if _, err := conn.Write(peer.AppendMessage(nil, &peer.Message{Id: peer.Extended, Payload: hs})); err != nil {
+		return
+	}
+
+	d := peer.NewDecoder(conn, 0)
+
+	var m peer.Message
+	var info []byte
+	var got []bool
+	var left int
+
+	for {
+		if err := d.Decode(&m); err != nil {
+			return
+		}
+
+		if m.KeepAlive || m.Id != peer.Extended || len(m.Payload) == 0 {
+			continue
+		}
+
+		switch m.Payload[0] {
+		case peer.ExtendedHandshakeId:
+			if info != nil {
+				continue
+			}
+
+			var h peer.ExtendedHandshake
+			if err := peer.ParseExtendedHandshake(&h, m.Payload[1:]); err.IsError() {
+				return
+			}
+
+			id := h.M[peer.ExtensionMetadata]
+			if id == 0 || h.MetadataSize <= 0 || h.MetadataSize > maxMetadataSize {
+				return
+			}
+
+			info = make([]byte, h.MetadataSize)
+			left = (len(info) + peer.MetadataPieceLength - 1) / peer.MetadataPieceLength
+			got = make([]bool, left)
+
+			// Every piece at once, there being few.
+			var out []byte
+			for i := range got {
+				out = peer.AppendMessage(out, &peer.Message{
+					Id:      peer.Extended,
+					Payload: peer.AppendMetadataMessage(nil, id, &peer.MetadataMessage{Type: peer.MetadataRequest, Piece: i}),
+				})
+			}
+
+			if _, err := conn.Write(out); err != nil {
+				return
+			}
+		case metadataId:
+			var mm peer.MetadataMessage
+			if info == nil || peer.ParseMetadataMessage(&mm, m.Payload[1:]) != nil {
+				continue
+			}
+
+			if mm.Type != peer.MetadataData || mm.TotalSize != len(info) || mm.Piece >= len(got) {
+				return
+			}
+
+			lo := mm.Piece * peer.MetadataPieceLength
+			if len(mm.Data) != min(peer.MetadataPieceLength, len(info)-lo) {
+				return
+			}
+
+			if !got[mm.Piece] {
+				copy(info[lo:], mm.Data)
+				got[mm.Piece] = true
+				left--
+			}
+
+			if left == 0 {
+				s.setMetadata(info)
+				return
+			}
+		}
+	}
+}
+
+// setMetadata takes info as the torrent's info dictionary,
+// if it matches the info hash and is usable.
+func (s *Session) setMetadata(info []byte) bool {
+	if sha1.Sum(info) != s.infoHash {
+		return false
+	}
+
+	p := make([]byte, 0, len(info)+len("d4:infoe"))
+	p = append(p, "d4:info"...)
+	p = append(p, info...)
+	p = append(p, 'e')
+
+	mi := &metainfo.MetaInfoPreCompute{}
+	if err := aot.DecodeMetaInfoFile(mi, p); err.IsError() || checkInfo(mi) != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mi != nil {
+		return true
+	}
+
+	s.mi = mi
+
+	// Those tried for the metadata may
+	// be dialed again straight away.
+	for addr := range s.known {
+		s.known[addr] = time.Time{}
+	}
+
+	s.gotMetadata()
+
+	return true
+}
+
+// extended acts on an extension message from a peer:
+// its handshake, or a request for the metadata.
+func (s *Session) extended(pc *peerConn, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	switch p[0] {
+	case peer.ExtendedHandshakeId:
+		var h peer.ExtendedHandshake
+		if err := peer.ParseExtendedHandshake(&h, p[1:]); !err.IsError() {
+			pc.metadataId = h.M[peer.ExtensionMetadata]
+		}
+	case metadataId:
+		var m peer.MetadataMessage
+		if peer.ParseMetadataMessage(&m, p[1:]) != nil || m.Type != peer.MetadataRequest || pc.metadataId == 0 {
+			return
+		}
+
+		// The metadata is had, if peers are connected.
+		info := s.MetaInfo().InfoDict
+
+		reply := peer.MetadataMessage{Type: peer.MetadataReject, Piece: m.Piece}
+
+		// Bounded first, that the offset can't overflow.
+		pieces := (len(info) + peer.MetadataPieceLength - 1) / peer.MetadataPieceLength
+		if m.Piece < pieces {
+			lo := m.Piece * peer.MetadataPieceLength
+			reply.Type = peer.MetadataData
+			reply.TotalSize = len(info)
+			reply.Data = info[lo:min(lo+peer.MetadataPieceLength, len(info))]
+		}
+
+		pc.conn.SendExtended(peer.AppendMetadataMessage(nil, pc.metadataId, &reply))
+	}
+}
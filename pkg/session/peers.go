@@ -0,0 +1,797 @@
+package session
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/peer"
+	"github.com/joelancaster/bytepour/pkg/picker"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+const (
+	// How long to connect and exchange handshakes.
+	handshakeTimeout = 10 * time.Second
+	// How long before an address is dialed again.
+	redialInterval = time.Minute
+	// How often the connector looks for peers to
+	// dial, besides when woken.
+	connectInterval = 5 * time.Second
+	// The most peers' addresses to keep.
+	maxKnown = 1000
+	// Blocks a peer may have waiting to be sent
+	// before its requests are dropped.
+	uploadQueue = 64
+)
+
+// peerConn is a connected peer.
+type peerConn struct {
+	key  int
+	addr netip.AddrPort
+	conn *peer.Conn
+	// The pieces the peer has, as
+	// counted by the picker. Under s.mu.
+	bits peer.Bits
+	// Whether the peer speaks the extension protocol,
+	// and the id it gave metadata exchange.
+	extended   bool
+	metadataId byte
+	// Held while our first messages are sent,
+	// so no have is sent before the bitfield.
+	sendMu sync.Mutex
+	// Blocks picked to request, to reuse.
+	picks   []peer.Block
+	cancels []int
+
+	// Wakes the peer's goroutine to
+	// request more, if it can.
+	wakeUp  chan struct{}
+	uploads chan peer.Block
+
+	mu sync.Mutex
+	// Requests the peer cancelled
+	// after they were queued.
+	cancelled map[peer.Block]bool
+}
+
+func (pc *peerConn) wake() {
+	select {
+	case pc.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// have tells the peer we have piece i.
+func (pc *peerConn) have(i int) {
+	pc.sendMu.Lock()
+	defer pc.sendMu.Unlock()
+
+	pc.conn.Have(uint32(i))
+}
+
+func (pc *peerConn) cancel(b peer.Block) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if len(pc.cancelled) < uploadQueue {
+		pc.cancelled[b] = true
+	}
+}
+
+// wasCancelled reports whether b was cancelled,
+// forgetting that it was.
+func (pc *peerConn) wasCancelled(b peer.Block) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	c := pc.cancelled[b]
+	delete(pc.cancelled, b)
+
+	return c
+}
+
+// peerList is the peers connected. s.mu must be held.
+func (s *Session) peerList() []*peerConn {
+	peers := make([]*peerConn, 0, len(s.peers))
+	for _, pc := range s.peers {
+		peers = append(peers, pc)
+	}
+
+	return peers
+}
+
+// wake wakes the connector.
+func (s *Session) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case s.wakeDial <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) maxPeers() int {
+	if s.opts.MaxPeers == 0 {
+		return defaultMaxPeers
+	}
+
+	return s.opts.MaxPeers
+}
+
+// handshake is ours.
+func (s *Session) handshake() peer.Handshake {
+	h := peer.Handshake{InfoHash: s.infoHash, PeerId: s.peerId}
+	h.Reserved.SetExtended()
+
+	return h
+}
+
+// gather adds the peers trackers give to those known.
+func (s *Session) gather(ctx context.Context, tr *tracker.MultiTracker) {
+	for {
+		select {
+		case peers := <-tr.Peers():
+			s.mu.Lock()
+
+			for _, p := range peers {
+				if _, ok := s.known[p.Addr]; !ok && len(s.known) < maxKnown {
+					s.known[p.Addr] = time.Time{}
+				}
+			}
+
+			s.mu.Unlock()
+
+			s.wake()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// connect dials known peers, or fetches the metadata from
+// them, as there is room for more, until ctx is done.
+func (s *Session) connect(ctx context.Context) {
+	for {
+		s.dialSome(ctx)
+
+		t := s.clk.NewTimer(connectInterval)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-s.wakeDial:
+			t.Stop()
+		case <-t.C():
+		}
+	}
+}
+
+func (s *Session) dialSome(ctx context.Context) {
+	s.mu.Lock()
+
+	fetching := s.mi == nil
+	if !fetching && !s.started {
+		s.mu.Unlock()
+		return
+	}
+
+	limit := s.maxPeers()
+	if fetching {
+		limit = maxFetchers
+	}
+
+	now := s.clk.Now()
+
+	var addrs []netip.AddrPort
+	for addr, last := range s.known {
+		if len(s.peers)+s.dialing >= limit {
+			break
+		}
+
+		if s.connected[addr] || !last.IsZero() && now.Sub(last) < redialInterval {
+			continue
+		}
+
+		if !s.opts.Conns.TryAcquire() {
+			break
+		}
+
+		s.known[addr] = now
+		s.dialing++
+		addrs = append(addrs, addr)
+	}
+
+	metaCtx := s.metaCtx
+
+	s.mu.Unlock()
+
+	for _, addr := range addrs {
+		s.spawn(func() {
+			var added bool
+			if fetching {
+				s.fetchMetadata(metaCtx, addr)
+			} else {
+				added = s.dial(ctx, addr)
+			}
+
+			if !added {
+				s.opts.Conns.Release()
+			}
+
+			s.mu.Lock()
+			s.dialing--
+			s.mu.Unlock()
+
+			s.wake()
+		})
+	}
+}
+
+// dialPeer connects to addr and exchanges handshakes.
+func (s *Session) dialPeer(ctx context.Context, addr netip.AddrPort) (net.Conn, *peer.Handshake, error) {
+	dial := s.opts.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, "tcp", addr.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	ours := s.handshake()
+
+	var theirs peer.Handshake
+	err = peer.ExchangeHandshakes(conn, &ours, &theirs)
+
+	if !stop() || err != nil {
+		conn.Close()
+
+		if err == nil {
+			err = ctx.Err()
+		}
+
+		return nil, nil, err
+	}
+
+	if theirs.InfoHash != s.infoHash {
+		conn.Close()
+		return nil, nil, ErrWrongTorrent
+	}
+
+	return conn, &theirs, nil
+}
+
+// dial connects to the peer at addr, reporting
+// whether it was added.
+func (s *Session) dial(ctx context.Context, addr netip.AddrPort) bool {
+	conn, theirs, err := s.dialPeer(ctx, addr)
+	if err != nil {
+		return false
+	}
+
+	return s.addPeer(ctx, conn, theirs, addr)
+}
+
+// listen accepts connections from Options.Listener.
+func (s *Session) listen(ctx context.Context) {
+	l := s.opts.Listener
+
+	stop := context.AfterFunc(ctx, func() {
+		l.Close()
+	})
+
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.spawn(func() {
+			conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+			var theirs peer.Handshake
+			if err := peer.ReadHandshake(conn, &theirs); err != nil {
+				conn.Close()
+				return
+			}
+
+			conn.SetDeadline(time.Time{})
+
+			s.Accept(conn, &theirs)
+		})
+	}
+}
+
+// Accept takes over conn, a connection from a peer whose
+// handshake, theirs, has been read, for the torrent. Ours
+// is sent in reply. If it isn't taken, conn is closed and
+// why returned.
+func (s *Session) Accept(conn net.Conn, theirs *peer.Handshake) error {
+	ctx, err := s.reserve(theirs)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	go func() {
+		defer s.wg.Done()
+
+		var added bool
+
+		defer func() {
+			if !added {
+				s.opts.Conns.Release()
+			}
+
+			s.mu.Lock()
+			s.dialing--
+			s.mu.Unlock()
+		}()
+
+		var buf peer.HandshakeBuffer
+		ours := s.handshake()
+
+		conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+
+		if _, err := conn.Write(peer.PutHandshake(&buf, &ours)); err != nil {
+			conn.Close()
+			return
+		}
+
+		conn.SetWriteDeadline(time.Time{})
+
+		var addr netip.AddrPort
+		if tcp, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			addr = tcp.AddrPort()
+		}
+
+		added = s.addPeer(ctx, conn, theirs, addr)
+	}()
+
+	return nil
+}
+
+// reserve checks a peer's handshake may be taken, and if
+// so counts it as a peer being connected, taking its slot,
+// and a goroutine Run must wait for, returning the run's
+// context.
+func (s *Session) reserve(theirs *peer.Handshake) (context.Context, error) {
+	if theirs.InfoHash != s.infoHash {
+		return nil, ErrWrongTorrent
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.ctx == nil || s.ctx.Err() != nil:
+		return nil, ErrNotRunning
+	case s.mi == nil:
+		return nil, ErrNoMetadata
+	case !s.started:
+		return nil, ErrNotRunning
+	case len(s.peers)+s.dialing >= s.maxPeers(), !s.opts.Conns.TryAcquire():
+		return nil, ErrTooManyPeers
+	}
+
+	s.dialing++
+	s.wg.Add(1)
+
+	return s.ctx, nil
+}
+
+// addPeer starts talking to the peer on conn, once
+// handshakes are exchanged, until ctx is done. It reports
+// whether the peer was added, taking over its slot.
+func (s *Session) addPeer(ctx context.Context, conn net.Conn, theirs *peer.Handshake, addr netip.AddrPort) bool {
+	if theirs.PeerId == s.peerId {
+		// Ourselves.
+		conn.Close()
+		return false
+	}
+
+	s.mu.Lock()
+
+	if ctx.Err() != nil || addr.IsValid() && s.connected[addr] {
+		s.mu.Unlock()
+		conn.Close()
+
+		return false
+	}
+
+	n := s.picker.NumPieces()
+
+	pc := &peerConn{
+		key:       s.nextKey,
+		addr:      addr,
+		bits:      peer.NewBits(n),
+		extended:  theirs.Reserved.Extended(),
+		wakeUp:    make(chan struct{}, 1),
+		uploads:   make(chan peer.Block, uploadQueue),
+		cancelled: make(map[peer.Block]bool),
+	}
+
+	pc.conn = peer.NewConn(conn, peer.Config{
+		NumPieces:   n,
+		MaxRequests: requestsPerPeer,
+		Clock:       s.clk,
+	})
+
+	s.nextKey++
+	s.peers[pc.key] = pc
+
+	pc.sendMu.Lock()
+
+	if addr.IsValid() {
+		s.connected[addr] = true
+	}
+
+	var bits peer.Bits
+	if s.picker.Bits().Count() != 0 {
+		bits = append(bits, s.picker.Bits()...)
+	}
+
+	var ext []byte
+	if pc.extended {
+		ext = peer.AppendExtendedHandshake(nil, &peer.ExtendedHandshake{
+			M:            map[string]byte{peer.ExtensionMetadata: metadataId},
+			MetadataSize: len(s.mi.InfoDict),
+			V:            clientVersion,
+		})
+	}
+
+	s.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		pc.conn.Close()
+	})
+
+	// Read while our first messages are
+	// sent, so neither side waits on the other.
+	s.spawn(func() {
+		pc.conn.Run()
+		stop()
+	})
+
+	if bits != nil {
+		pc.conn.Bitfield(bits)
+	}
+
+	if ext != nil {
+		pc.conn.SendExtended(ext)
+	}
+
+	pc.sendMu.Unlock()
+
+	s.choker.Add(pc.conn)
+
+	s.spawn(func() { s.handle(ctx, pc) })
+	s.spawn(func() { s.upload(ctx, pc) })
+
+	return true
+}
+
+// removePeer forgets a peer whose connection ended.
+func (s *Session) removePeer(pc *peerConn) {
+	s.mu.Lock()
+
+	delete(s.peers, pc.key)
+
+	if pc.addr.IsValid() {
+		delete(s.connected, pc.addr)
+	}
+
+	s.picker.RemovePeer(pc.key, pc.bits)
+	s.picker.PeerGone(pc.key)
+
+	peers := s.peerList()
+
+	s.mu.Unlock()
+
+	s.choker.Remove(pc.conn)
+	s.verifier.Forget(pc.key)
+	s.opts.Conns.Release()
+
+	// Its requests may go to others,
+	// and another may take its place.
+	for _, other := range peers {
+		other.wake()
+	}
+
+	s.wake()
+}
+
+// handle acts on what the peer does, until it's gone.
+func (s *Session) handle(ctx context.Context, pc *peerConn) {
+	defer s.removePeer(pc)
+
+	events := pc.conn.Events()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if e.Kind == peer.EventBlock {
+				// Reading from the peer stalls as
+				// this waits, which slows it down.
+				s.opts.Download.Wait(ctx, len(e.Data))
+			}
+
+			s.event(pc, &e)
+		case <-pc.wakeUp:
+			s.fill(pc)
+		}
+	}
+}
+
+func (s *Session) event(pc *peerConn, e *peer.Event) {
+	switch e.Kind {
+	case peer.EventBitfield:
+		s.mu.Lock()
+
+		// Any pieces it has since are counted here,
+		// their events skipped when they come, and
+		// any counted before counted again.
+		s.picker.RemovePeer(pc.key, pc.bits)
+		pc.bits = pc.conn.PeerBits(pc.bits[:0])
+		s.picker.AddPeer(pc.key, pc.bits)
+
+		interested := s.wants(pc.bits)
+
+		s.mu.Unlock()
+
+		if interested {
+			pc.conn.Interested()
+		}
+	case peer.EventHave:
+		i := int(e.Index)
+
+		s.mu.Lock()
+
+		if !pc.bits.Has(i) {
+			pc.bits.Set(i)
+			s.picker.PeerHave(pc.key, i)
+		}
+
+		interested := !s.picker.Have(i) && s.picker.Priority(i) != picker.None
+
+		s.mu.Unlock()
+
+		if interested && !pc.conn.AmInterested() {
+			pc.conn.Interested()
+		}
+	case peer.EventUnchoked:
+		s.fill(pc)
+	case peer.EventChoked, peer.EventTimeout:
+		s.mu.Lock()
+
+		for _, b := range e.Blocks {
+			s.picker.Dropped(pc.key, b)
+		}
+
+		s.mu.Unlock()
+
+		s.fill(pc)
+	case peer.EventInterested:
+		// Take up a free slot now, rather
+		// than at the next rechoke.
+		if unchoked, _ := s.choker.Unchoked(pc.conn); !unchoked {
+			s.choker.Fill()
+		}
+	case peer.EventRequest:
+		s.request(pc, e.Block)
+	case peer.EventCancel:
+		pc.cancel(e.Block)
+	case peer.EventBlock:
+		s.received(pc, e.Block, e.Data)
+	case peer.EventExtended:
+		s.extended(pc, e.Data)
+	}
+}
+
+// wants reports whether a peer with bits has a piece we
+// want and don't have. s.mu must be held.
+func (s *Session) wants(bits peer.Bits) bool {
+	for i := 0; i < s.picker.NumPieces(); i++ {
+		if bits.Has(i) && !s.picker.Have(i) && s.picker.Priority(i) != picker.None {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fill requests of the peer what it can give us, up to
+// the requests we keep outstanding with it.
+func (s *Session) fill(pc *peerConn) {
+	c := pc.conn
+
+	s.mu.Lock()
+
+	if s.picker.Complete() {
+		s.mu.Unlock()
+
+		if c.AmInterested() {
+			c.NotInterested()
+		}
+
+		return
+	}
+
+	if c.PeerChoking() || !c.AmInterested() || s.verifying >= maxVerifying {
+		s.mu.Unlock()
+		return
+	}
+
+	pc.picks = s.picker.Pick(pc.picks[:0], pc.key, pc.bits, requestsPerPeer-c.Pending())
+
+	s.mu.Unlock()
+
+	for k, b := range pc.picks {
+		if err := c.Request(b); err != nil {
+			s.mu.Lock()
+
+			for _, b := range pc.picks[k:] {
+				s.picker.Dropped(pc.key, b)
+			}
+
+			s.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+// received stores a block from the peer, if it's still
+// wanted, and submits its piece for verifying once whole.
+func (s *Session) received(pc *peerConn, b peer.Block, data []byte) {
+	s.mu.Lock()
+
+	s.downloaded += uint64(len(data))
+
+	// A block sent twice in end-game is stored once, its
+	// piece not verified until then, so none lands after.
+	if !s.picker.Wanted(b) || s.writing[b] {
+		s.mu.Unlock()
+		return
+	}
+
+	s.writing[b] = true
+	st := s.storage
+
+	s.mu.Unlock()
+
+	_, err := st.WriteAt(data, int(b.Index), b.Begin)
+
+	s.mu.Lock()
+
+	delete(s.writing, b)
+
+	if err != nil {
+		s.mu.Unlock()
+		s.fail(err)
+
+		return
+	}
+
+	// Its piece may have been dropped meanwhile.
+	if !s.picker.Wanted(b) {
+		s.mu.Unlock()
+		return
+	}
+
+	i := int(b.Index)
+
+	var done bool
+	pc.cancels, done = s.picker.Received(pc.cancels[:0], pc.key, b)
+	s.senders[i] = append(s.senders[i], pc.key)
+
+	var others []*peerConn
+	for _, key := range pc.cancels {
+		if other := s.peers[key]; other != nil {
+			others = append(others, other)
+		}
+	}
+
+	var senders []int
+	if done {
+		senders = s.senders[i]
+		delete(s.senders, i)
+		s.verifying++
+	}
+
+	ctx := s.ctx
+
+	s.mu.Unlock()
+
+	for _, other := range others {
+		other.conn.Cancel(b)
+	}
+
+	if done && ctx != nil {
+		s.spawn(func() {
+			s.verifier.Submit(ctx, i, senders)
+		})
+	}
+
+	s.fill(pc)
+}
+
+// request queues a block the peer asked for, if we have it.
+func (s *Session) request(pc *peerConn, b peer.Block) {
+	s.mu.Lock()
+	have := s.picker.Have(int(b.Index))
+	s.mu.Unlock()
+
+	if !have {
+		return
+	}
+
+	pc.wasCancelled(b)
+
+	select {
+	case pc.uploads <- b:
+	default:
+	}
+}
+
+// upload sends the blocks the peer asked for,
+// until it's gone.
+func (s *Session) upload(ctx context.Context, pc *peerConn) {
+	var buf []byte
+
+	for {
+		var b peer.Block
+
+		select {
+		case b = <-pc.uploads:
+		case <-pc.conn.Done():
+			return
+		}
+
+		// We may have choked it since.
+		if pc.wasCancelled(b) || pc.conn.AmChoking() {
+			continue
+		}
+
+		if cap(buf) < int(b.Length) {
+			buf = make([]byte, b.Length)
+		}
+
+		p := buf[:b.Length]
+
+		if _, err := s.storage.ReadAt(p, int(b.Index), b.Begin); err != nil {
+			continue
+		}
+
+		if s.opts.Upload.Wait(ctx, len(p)) != nil {
+			return
+		}
+
+		if err := pc.conn.SendBlock(b, p); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.uploaded += uint64(len(p))
+		s.mu.Unlock()
+	}
+}
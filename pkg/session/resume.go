@@ -0,0 +1,350 @@
+package session
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+	"github.com/joelancaster/bytepour/pkg/storage"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+// ErrBadResume is returned for resume data that is malformed.
+var ErrBadResume = errors.New("session: bad resume data")
+
+// FileState is the size and modification time of a
+// stored file, to tell whether it has changed.
+type FileState struct {
+	// -1 if the file is missing.
+	Size int64
+	// In nanoseconds since the Unix epoch.
+	ModTime int64
+}
+
+// Resume is what a session saves, to be run again without
+// checking what it stored, as when restarted.
+type Resume struct {
+	InfoHash [20]byte
+	// The pieces verified, nil if not known.
+	Have peer.Bits
+	// Each file of the torrent when Have was taken, in order.
+	Files []FileState
+	// The totals announced to trackers.
+	Downloaded, Uploaded uint64
+	// The tracker ID each tracker gave, by announce URL.
+	TrackerIds map[string][]byte
+	// Peers' addresses.
+	Peers []netip.AddrPort
+}
+
+// AppendBencode appends the bencoding of r to dst, the
+// inverse of ParseResume.
+func (r *Resume) AppendBencode(dst []byte) []byte {
+	dst = append(dst, parse.OpenDict)
+
+	// Keys in sorted order.
+	dst = encode.KeyUint(dst, "downloaded", r.Downloaded)
+
+	if r.Have != nil {
+		dst = encode.String(dst, "files")
+		dst = append(dst, parse.OpenList)
+
+		for _, f := range r.Files {
+			dst = append(dst, parse.OpenDict)
+			dst = encode.KeyInt(dst, "mtime", f.ModTime)
+			dst = encode.KeyInt(dst, "size", f.Size)
+			dst = append(dst, parse.EndTerm)
+		}
+
+		dst = append(dst, parse.EndTerm)
+		dst = encode.KeyString(dst, "have", []byte(r.Have))
+	}
+
+	dst = encode.KeyString(dst, "info hash", r.InfoHash[:])
+
+	var peers, peers6 []byte
+	for _, addr := range r.Peers {
+		if addr.Addr().Unmap().Is4() {
+			peers = tracker.AppendCompactPeer(peers, addr)
+		} else {
+			peers6 = tracker.AppendCompactPeer(peers6, addr)
+		}
+	}
+
+	if len(peers) != 0 {
+		dst = encode.KeyString(dst, "peers", peers)
+	}
+
+	if len(peers6) != 0 {
+		dst = encode.KeyString(dst, "peers6", peers6)
+	}
+
+	if len(r.TrackerIds) != 0 {
+		dst = encode.String(dst, "trackers")
+		dst = append(dst, parse.OpenDict)
+
+		urls := make([]string, 0, len(r.TrackerIds))
+		for url := range r.TrackerIds {
+			urls = append(urls, url)
+		}
+
+		slices.Sort(urls)
+
+		for _, url := range urls {
+			dst = encode.KeyString(dst, url, r.TrackerIds[url])
+		}
+
+		dst = append(dst, parse.EndTerm)
+	}
+
+	dst = encode.KeyUint(dst, "uploaded", r.Uploaded)
+
+	return append(dst, parse.EndTerm)
+}
+
+// ParseResume parses bencoded resume data into r. Unknown
+// keys are skipped. r does not refer to p once parsed.
+func ParseResume(r *Resume, p []byte) error {
+	*r = Resume{}
+
+	ok := true
+
+	it := parse.NewDictIter(p)
+	for ok && it.Next() {
+		v := it.Value()
+
+		switch string(it.Key()) {
+		case "downloaded":
+			r.Downloaded, ok = total(v)
+		case "files":
+			r.Files, ok = parseFiles(v)
+		case "have":
+			var have []byte
+			if have, ok = parse.Bytes(v); ok {
+				r.Have = append(peer.Bits{}, have...)
+			}
+		case "info hash":
+			var ih []byte
+			if ih, ok = parse.Bytes(v); ok && len(ih) == len(r.InfoHash) {
+				copy(r.InfoHash[:], ih)
+			} else {
+				ok = false
+			}
+		case "peers", "peers6":
+			decode := tracker.DecodeCompactPeers
+			if string(it.Key()) == "peers6" {
+				decode = tracker.DecodeCompactPeers6
+			}
+
+			var s []byte
+			var peers []tracker.Peer
+			if s, ok = parse.Bytes(v); ok {
+				peers, ok = decode(nil, s)
+			}
+
+			for _, p := range peers {
+				r.Peers = append(r.Peers, p.Addr)
+			}
+		case "trackers":
+			r.TrackerIds = make(map[string][]byte)
+
+			ids := parse.NewDictIter(v)
+			for ok && ids.Next() {
+				var id []byte
+				if id, ok = parse.Bytes(ids.Value()); ok {
+					r.TrackerIds[string(ids.Key())] = append([]byte(nil), id...)
+				}
+			}
+
+			ok = ok && !ids.Err().IsError()
+		case "uploaded":
+			r.Uploaded, ok = total(v)
+		}
+	}
+
+	if !ok || it.Err().IsError() || r.Have != nil && r.Files == nil {
+		return ErrBadResume
+	}
+
+	return nil
+}
+
+// total decodes the non-negative integer p.
+func total(p []byte) (uint64, bool) {
+	n, ok := parse.Integer(p)
+
+	return uint64(n), ok && n >= 0
+}
+
+// parseFiles decodes the list of files' states p.
+func parseFiles(p []byte) ([]FileState, bool) {
+	files := []FileState{}
+
+	l := parse.NewListIter(p)
+	for l.Next() {
+		var f FileState
+		ok := true
+
+		d := parse.NewDictIter(l.Value())
+		for ok && d.Next() {
+			switch string(d.Key()) {
+			case "mtime":
+				f.ModTime, ok = parse.Integer(d.Value())
+			case "size":
+				f.Size, ok = parse.Integer(d.Value())
+			}
+		}
+
+		if !ok || d.Err().IsError() {
+			return nil, false
+		}
+
+		files = append(files, f)
+	}
+
+	return files, !l.Err().IsError()
+}
+
+// statFiles is the state of each file of info, as
+// storage.OpenDir lays them out under the session's dir.
+func (s *Session) statFiles(info *metainfo.Info) []FileState {
+	files, err := storage.Files(info)
+	if err != nil {
+		return nil
+	}
+
+	st := make([]FileState, len(files))
+
+	for i, f := range files {
+		fi, err := os.Stat(filepath.Join(s.dir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			st[i] = FileState{Size: -1}
+			continue
+		}
+
+		st[i] = FileState{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+	}
+
+	return st
+}
+
+// Resume is the session's resume data now. What's had is
+// left out while what's stored is being checked.
+func (s *Session) Resume() *Resume {
+	s.mu.Lock()
+
+	r := &Resume{
+		InfoHash:   s.infoHash,
+		Downloaded: s.downloaded,
+		Uploaded:   s.uploaded,
+		TrackerIds: make(map[string][]byte),
+		Peers:      make([]netip.AddrPort, 0, len(s.known)),
+	}
+
+	for addr := range s.known {
+		r.Peers = append(r.Peers, addr)
+	}
+
+	for url, id := range s.trackerIds {
+		r.TrackerIds[url] = id
+	}
+
+	tr := s.tracker
+	started := s.started
+
+	switch {
+	case started:
+		r.Have = append(peer.Bits{}, s.picker.Bits()...)
+	case s.have != nil:
+		r.Have, r.Files = slices.Clone(s.have), slices.Clone(s.files)
+	}
+
+	mi := s.mi
+
+	s.mu.Unlock()
+
+	slices.SortFunc(r.Peers, netip.AddrPort.Compare)
+
+	// The files as they are now, after what's had. Should
+	// they change in between, what's stored is checked.
+	if started {
+		r.Files = s.statFiles(&mi.Info)
+	}
+
+	if tr != nil {
+		for _, st := range tr.Status() {
+			if len(st.TrackerId) != 0 {
+				r.TrackerIds[string(st.URL)] = st.TrackerId
+			}
+		}
+	}
+
+	return r
+}
+
+// SetResume takes up r, resume data of the torrent from
+// Resume, before the session runs: its totals, tracker
+// IDs and peers, and what's had, unless the files stored
+// have changed since, when they are checked as usual.
+func (s *Session) SetResume(r *Resume) error {
+	if r.InfoHash != s.infoHash {
+		return ErrWrongTorrent
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running.Load() {
+		return ErrRunning
+	}
+
+	s.have, s.files = slices.Clone(r.Have), slices.Clone(r.Files)
+	s.downloaded, s.uploaded = r.Downloaded, r.Uploaded
+
+	for url, id := range r.TrackerIds {
+		s.trackerIds[url] = id
+	}
+
+	for _, addr := range r.Peers {
+		if _, ok := s.known[addr]; !ok && len(s.known) < maxKnown {
+			s.known[addr] = time.Time{}
+		}
+	}
+
+	return nil
+}
+
+// SaveResume writes the session's resume data to the
+// file at path, replacing it whole.
+func (s *Session) SaveResume(path string) error {
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, s.Resume().AppendBencode(nil), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadResume takes up the resume data in the file
+// at path, as SetResume does.
+func (s *Session) LoadResume(path string) error {
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var r Resume
+	if err := ParseResume(&r, p); err != nil {
+		return err
+	}
+
+	return s.SetResume(&r)
+}
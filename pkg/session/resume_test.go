@@ -0,0 +1,164 @@
+package session
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+	"github.com/joelancaster/bytepour/pkg/storage"
+)
+
+func TestResumeBencode(t *testing.T) {
+	r := Resume{
+		InfoHash:   [20]byte{1, 2, 3},
+		Have:       peer.Bits{0xA0},
+		Files:      []FileState{{Size: 10, ModTime: 1700000000123456789}, {Size: -1}},
+		Downloaded: 100,
+		Uploaded:   200,
+		TrackerIds: map[string][]byte{"http://a": []byte("x"), "udp://b": []byte("y")},
+		Peers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:1"), netip.MustParseAddrPort("[::1]:2")},
+	}
+
+	p := r.AppendBencode(nil)
+
+	var got Resume
+	if err := ParseResume(&got, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, r) {
+		t.Fatalf("round trip = %+v, want %+v", got, r)
+	}
+
+	for _, tt := range []struct {
+		name string
+		p    string
+	}{
+		{"not a dict", "le"},
+		{"truncated", string(p[:len(p)-1])},
+		{"short info hash", "d9:info hash3:abce"},
+		{"negative total", "d10:downloadedi-1ee"},
+		{"have without files", "d4:have1:\x80e"},
+		{"bad file", "d5:filesld5:mtime1:xeee"},
+		{"bad peers", "d5:peers5:abcdee"},
+	} {
+		if err := ParseResume(&got, []byte(tt.p)); err != ErrBadResume {
+			t.Fatalf("%s: ParseResume = %v, want %v", tt.name, err, ErrBadResume)
+		}
+	}
+}
+
+// counting counts the reads of storage.
+type counting struct {
+	storage.Storage
+	reads *atomic.Int64
+}
+
+func (c counting) ReadAt(p []byte, index int, begin uint32) (int, error) {
+	c.reads.Add(1)
+	return c.Storage.ReadAt(p, index, begin)
+}
+
+func TestResume(t *testing.T) {
+	mi := newTorrent(t, "", "")
+	dir := t.TempDir()
+	newTorrent(t, dir, "")
+
+	var reads atomic.Int64
+
+	opts := Options{Storage: func(dir string, info *metainfo.Info) (storage.Storage, error) {
+		st, err := storage.OpenDir(dir, info)
+		if err != nil {
+			return nil, err
+		}
+
+		return counting{st, &reads}, nil
+	}}
+
+	// run runs a new session from the resume data in path,
+	// if any, until it is done checking, and saves it again.
+	path := filepath.Join(t.TempDir(), "resume")
+
+	run := func() Progress {
+		t.Helper()
+
+		s, err := Open(mi, dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.LoadResume(path); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+
+		updates, cancel := s.Subscribe()
+		defer cancel()
+
+		reads.Store(0)
+		stop := start(t, s)
+
+		var p Progress
+		for p.State <= StateChecking {
+			p = <-updates
+		}
+
+		stop()
+
+		if err := s.SaveResume(path); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	if p := run(); p.State != StateSeeding || reads.Load() == 0 {
+		t.Fatalf("first run: %v after %d reads", p.State, reads.Load())
+	}
+
+	// What's had is taken from the resume data.
+	if p := run(); p.State != StateSeeding || reads.Load() != 0 {
+		t.Fatalf("resumed run: %v after %d reads", p.State, reads.Load())
+	}
+
+	// A file changed is checked again, and found wanting.
+	name := filepath.Join(dir, "t", "a")
+	if err := os.WriteFile(name, make([]byte, mi.Info.Files[0].Length), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := run(); p.State != StateDownloading || reads.Load() == 0 || p.Have >= p.Pieces {
+		t.Fatalf("run after change: %+v after %d reads", p, reads.Load())
+	}
+
+	// The totals, tracker IDs and peers are kept too.
+	s, err := Open(mi, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Resume{
+		InfoHash:   s.InfoHash(),
+		Downloaded: 1,
+		Uploaded:   2,
+		TrackerIds: map[string][]byte{"http://a": []byte("x")},
+		Peers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:1")},
+	}
+
+	if err := s.SetResume(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.Resume(); !reflect.DeepEqual(*got, r) {
+		t.Fatalf("Resume = %+v, want %+v", got, r)
+	}
+
+	r.InfoHash[0]++
+	if err := s.SetResume(&r); err != ErrWrongTorrent {
+		t.Fatalf("SetResume of another torrent = %v, want %v", err, ErrWrongTorrent)
+	}
+}
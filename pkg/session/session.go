@@ -0,0 +1,745 @@
+// Package session downloads and seeds one torrent, given its
+// metainfo or a magnet link: it announces to the torrent's
+// trackers, connects to peers, downloads pieces into storage,
+// verifies them, then seeds, reporting its progress as it goes.
+package session
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/aot"
+	"github.com/joelancaster/bytepour/pkg/bittorrent"
+	"github.com/joelancaster/bytepour/pkg/choker"
+	"github.com/joelancaster/bytepour/pkg/clock"
+	"github.com/joelancaster/bytepour/pkg/limit"
+	"github.com/joelancaster/bytepour/pkg/magnet"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+	"github.com/joelancaster/bytepour/pkg/picker"
+	"github.com/joelancaster/bytepour/pkg/storage"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+	"github.com/joelancaster/bytepour/pkg/verify"
+)
+
+const (
+	defaultMaxPeers = 50
+
+	// Requests outstanding with each peer.
+	requestsPerPeer = 16
+	// Pieces waiting to be verified before
+	// no more blocks are requested.
+	maxVerifying = 16
+	// How often progress is published.
+	progressInterval = time.Second
+)
+
+var (
+	// ErrRunning is returned when running a
+	// session that is already running.
+	ErrRunning = errors.New("session: already running")
+	// ErrNotRunning is returned when handing a
+	// connection to a session that isn't running.
+	ErrNotRunning = errors.New("session: not running")
+	// ErrNoMetadata is returned when handing a connection
+	// to a session still fetching the metadata of its magnet.
+	ErrNoMetadata = errors.New("session: metadata not yet fetched")
+	// ErrWrongTorrent is returned when handing a
+	// session a connection for another torrent.
+	ErrWrongTorrent = errors.New("session: connection is for another torrent")
+	// ErrTooManyPeers is returned when handing a
+	// session a connection beyond its MaxPeers.
+	ErrTooManyPeers = errors.New("session: too many peers")
+)
+
+// InfoError is returned for metainfo whose info
+// dictionary can't be downloaded.
+type InfoError struct {
+	Finding metainfo.Finding
+}
+
+// Error implements the error interface for InfoError.
+func (e *InfoError) Error() string {
+	return "session: bad metainfo: " + e.Finding.String()
+}
+
+// State is what a session is doing.
+type State byte
+
+const (
+	// Not running.
+	StateStopped = State(iota)
+	// Fetching the metadata of a magnet link from peers.
+	StateMetadata
+	// Hashing what's already stored.
+	StateChecking
+	// Downloading the pieces not had.
+	StateDownloading
+	// Every piece is had, and uploaded to peers.
+	StateSeeding
+)
+
+// String implements the Stringer interface for State.
+func (s State) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateMetadata:
+		return "fetching metadata"
+	case StateChecking:
+		return "checking"
+	case StateDownloading:
+		return "downloading"
+	case StateSeeding:
+		return "seeding"
+	default:
+		return "unknown"
+	}
+}
+
+// Progress is how a session is getting on.
+type Progress struct {
+	State State
+	// The pieces had, and in all; zero
+	// until the metadata is had.
+	Have, Pieces int
+	// Bytes left to download.
+	Left uint64
+	// Block data received and sent.
+	Downloaded, Uploaded uint64
+	// The number of peers connected.
+	Peers int
+	// Why the session last stopped, if it failed.
+	Err error
+}
+
+// Options configures a session. The zero
+// value is the defaults.
+type Options struct {
+	// Our peer ID, bittorrent.IdBP if zero.
+	PeerId [20]byte
+	// The port peers may connect to us on, for trackers.
+	// That of Listener if zero and Listener is TCP.
+	Port uint16
+	// If set, peers' connections are accepted from it, and
+	// it is closed when Run returns. Otherwise they are
+	// only taken through Accept.
+	Listener net.Listener
+	// Peers to connect to, besides those trackers give.
+	Peers []netip.AddrPort
+	// The most peers to be connected to, 50 if zero.
+	MaxPeers int
+	// Shared with other sessions, as by a client: a slot
+	// for each connection, and the rates block data may be
+	// sent and received at. If nil, unlimited.
+	Conns            *limit.Slots
+	Upload, Download *limit.Rate
+	// Whether to download pieces in order,
+	// rather than rarest first.
+	Sequential bool
+	// Opens the storage of info under dir.
+	// If nil, storage.OpenDir.
+	Storage func(dir string, info *metainfo.Info) (storage.Storage, error)
+	// Dials peers. If nil, a net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Announces to trackers. If nil,
+	// a tracker.SchemeAnnouncer.
+	Announcer tracker.Announcer
+	// If nil, the system clock.
+	Clock clock.Clock
+}
+
+// Session is one torrent, to download and seed. Its
+// methods are safe for concurrent use.
+type Session struct {
+	opts     Options
+	dir      string
+	infoHash [20]byte
+	peerId   [20]byte
+	// The name from the magnet link, if any.
+	name  string
+	tiers [][][]byte
+	clk   clock.Clock
+
+	running atomic.Bool
+	wg      sync.WaitGroup
+
+	subMu sync.Mutex
+	subs  map[chan Progress]struct{}
+
+	mu sync.Mutex
+	// Nil until fetched, for a magnet link.
+	mi    *metainfo.MetaInfoPreCompute
+	state State
+	err   error
+	// The pieces had when last stopped, nil
+	// if what's stored must be checked, and
+	// the files as they were then.
+	have       peer.Bits
+	files      []FileState
+	downloaded uint64
+	uploaded   uint64
+	left       uint64
+	// The tracker ID each tracker gave, by URL.
+	trackerIds map[string][]byte
+	// Peers' addresses, and when each was last dialed.
+	known     map[netip.AddrPort]time.Time
+	connected map[netip.AddrPort]bool
+	// Connections being made, or metadata
+	// being fetched, that count as peers.
+	dialing int
+	peers   map[int]*peerConn
+	nextKey int
+
+	// Of the current run, nil when stopped.
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// Done once the metadata is had.
+	metaCtx     context.Context
+	gotMetadata context.CancelFunc
+	// Whether the download has started,
+	// what's stored checked.
+	started bool
+	// Wakes the connector to dial peers.
+	wakeDial chan struct{}
+	tracker  *tracker.MultiTracker
+
+	// Of the current run, or the last.
+	storage  storage.Storage
+	picker   *picker.Picker
+	verifier *verify.Verifier
+	choker   *choker.Choker
+	// The peers that sent blocks of each
+	// piece being downloaded.
+	senders map[int][]int
+	// Blocks being stored, outside the lock.
+	writing   map[peer.Block]bool
+	verifying int
+}
+
+// Open makes a session for the torrent mi, to be stored
+// under dir.
+func Open(mi *metainfo.MetaInfoPreCompute, dir string, opts Options) (*Session, error) {
+	if err := checkInfo(mi); err != nil {
+		return nil, err
+	}
+
+	s := newSession(dir, opts)
+	s.mi = mi
+	s.infoHash = sha1.Sum(mi.InfoDict)
+	s.tiers = mi.Trackers()
+
+	return s, nil
+}
+
+// OpenFile makes a session for the .torrent file at path,
+// to be stored under dir.
+func OpenFile(path, dir string, opts Options) (*Session, error) {
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mi metainfo.MetaInfoPreCompute
+	if err := aot.DecodeMetaInfoFile(&mi, p); err.IsError() {
+		return nil, err
+	}
+
+	return Open(&mi, dir, opts)
+}
+
+// OpenMagnet makes a session for the magnet link uri, to be
+// stored under dir. The metadata is fetched from peers, given
+// by the link's trackers or peers, or by Options.Peers.
+func OpenMagnet(uri, dir string, opts Options) (*Session, error) {
+	m, err := magnet.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSession(dir, opts)
+	s.infoHash = m.InfoHash
+	s.name = m.Name
+
+	// Each tracker a tier of its own, tried in turn.
+	for _, tr := range m.Trackers {
+		s.tiers = append(s.tiers, [][]byte{[]byte(tr)})
+	}
+
+	for _, addr := range m.Peers {
+		s.known[addr] = time.Time{}
+	}
+
+	return s, nil
+}
+
+func newSession(dir string, opts Options) *Session {
+	s := &Session{
+		opts:       opts,
+		dir:        dir,
+		peerId:     opts.PeerId,
+		clk:        opts.Clock,
+		subs:       make(map[chan Progress]struct{}),
+		trackerIds: make(map[string][]byte),
+		known:      make(map[netip.AddrPort]time.Time),
+		connected:  make(map[netip.AddrPort]bool),
+		peers:      make(map[int]*peerConn),
+	}
+
+	if s.peerId == [20]byte{} {
+		s.peerId = bittorrent.IdBP
+	}
+
+	if s.clk == nil {
+		s.clk = clock.System()
+	}
+
+	for _, addr := range opts.Peers {
+		s.known[addr] = time.Time{}
+	}
+
+	return s
+}
+
+// checkInfo reports the first error in the info
+// dictionary of mi, the rest being of no concern.
+func checkInfo(mi *metainfo.MetaInfoPreCompute) error {
+	for _, f := range metainfo.Validate(mi) {
+		if f.Severity == metainfo.SeverityError && strings.HasPrefix(f.Field, "info") {
+			return &InfoError{Finding: f}
+		}
+	}
+
+	return nil
+}
+
+// InfoHash is the info hash of the torrent.
+func (s *Session) InfoHash() [20]byte {
+	return s.infoHash
+}
+
+// Name is the torrent's name, from its metainfo,
+// or from its magnet link until the metadata is had.
+func (s *Session) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mi != nil {
+		return string(s.mi.Info.Name)
+	}
+
+	return s.name
+}
+
+// MetaInfo is the torrent's metainfo, nil until fetched
+// for a magnet link. It must not be modified.
+func (s *Session) MetaInfo() *metainfo.MetaInfoPreCompute {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.mi
+}
+
+// Progress is how the session is getting on now.
+func (s *Session) Progress() Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := Progress{
+		State:      s.state,
+		Downloaded: s.downloaded,
+		Uploaded:   s.uploaded,
+		Peers:      len(s.peers),
+		Err:        s.err,
+	}
+
+	if s.picker != nil {
+		p.Have = s.picker.Bits().Count()
+		p.Pieces = s.picker.NumPieces()
+		p.Left = s.left
+	}
+
+	return p
+}
+
+// Subscribe delivers the session's progress every second
+// while it runs, and whenever its state changes. Should the
+// reader fall behind, it gets only the latest. Calling
+// cancel stops delivery.
+func (s *Session) Subscribe() (updates <-chan Progress, cancel func()) {
+	ch := make(chan Progress, 1)
+
+	s.subMu.Lock()
+	s.subs[ch] = struct{}{}
+	s.subMu.Unlock()
+
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			s.subMu.Lock()
+			delete(s.subs, ch)
+			s.subMu.Unlock()
+		})
+	}
+}
+
+// publish sends the progress now to the subscribers.
+func (s *Session) publish() {
+	p := s.Progress()
+
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for ch := range s.subs {
+		// Replace an update not yet read. Only
+		// publish sends, so there's room after.
+		select {
+		case <-ch:
+		default:
+		}
+
+		ch <- p
+	}
+}
+
+func (s *Session) setState(state State) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+
+	s.publish()
+}
+
+// Run runs the session until ctx is done, or it fails:
+// fetching the metadata if need be, checking what's stored,
+// then downloading and seeding. It returns why it stopped.
+// A session may be run again once Run returns.
+func (s *Session) Run(ctx context.Context) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return ErrRunning
+	}
+
+	defer s.running.Store(false)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	metaCtx, gotMetadata := context.WithCancel(ctx)
+
+	s.mu.Lock()
+	trackerIds := maps.Clone(s.trackerIds)
+	s.mu.Unlock()
+
+	tr := &tracker.MultiTracker{
+		Announcer:  s.opts.Announcer,
+		Tiers:      s.tiers,
+		TrackerIds: trackerIds,
+		Request: tracker.AnnounceRequest{
+			InfoHash: s.infoHash,
+			PeerId:   s.peerId,
+			Port:     uint64(s.port()),
+			NumWant:  defaultMaxPeers,
+			Compact:  true,
+			Key:      rand.Uint32(),
+		},
+		Stats: s.stats,
+		Clock: s.clk,
+	}
+
+	s.mu.Lock()
+	s.ctx, s.cancel = ctx, cancel
+	s.metaCtx, s.gotMetadata = metaCtx, gotMetadata
+	s.wakeDial = make(chan struct{}, 1)
+	s.tracker = tr
+	s.err = nil
+
+	if s.mi != nil {
+		gotMetadata()
+	}
+
+	s.mu.Unlock()
+
+	s.spawn(func() { tr.Run(ctx) })
+	s.spawn(func() { s.gather(ctx, tr) })
+	s.spawn(func() { s.connect(ctx) })
+
+	if s.opts.Listener != nil {
+		s.spawn(func() { s.listen(ctx) })
+	}
+
+	err := s.run(ctx, metaCtx)
+	cancel(err)
+
+	s.mu.Lock()
+	s.ctx = nil
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	gotMetadata()
+
+	err = context.Cause(ctx)
+
+	s.mu.Lock()
+
+	if s.storage != nil {
+		if cerr := s.storage.Close(); cerr != nil && err == context.Canceled {
+			err = cerr
+		}
+
+		s.storage = nil
+	}
+
+	// Unless stopped while checking, what's had is known.
+	if s.started {
+		s.have = append(peer.Bits(nil), s.picker.Bits()...)
+		s.files = s.statFiles(&s.mi.Info)
+	}
+
+	for _, st := range tr.Status() {
+		if len(st.TrackerId) != 0 {
+			s.trackerIds[string(st.URL)] = st.TrackerId
+		}
+	}
+
+	if err != context.Canceled {
+		s.err = err
+	}
+
+	s.state = StateStopped
+	s.started = false
+	s.mu.Unlock()
+
+	s.publish()
+
+	return err
+}
+
+// run fetches the metadata if need be, then starts the
+// download, and publishes progress until ctx is done.
+func (s *Session) run(ctx, metaCtx context.Context) error {
+	if s.MetaInfo() == nil {
+		s.setState(StateMetadata)
+		s.wake()
+
+		<-metaCtx.Done()
+
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+	}
+
+	if err := s.start(ctx); err != nil {
+		return err
+	}
+
+	for {
+		s.publish()
+
+		t := s.clk.NewTimer(progressInterval)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return context.Cause(ctx)
+		case <-t.C():
+		}
+	}
+}
+
+// start opens the storage, checks what of it is had, unless
+// known from the last run, and starts downloading the rest.
+func (s *Session) start(ctx context.Context) error {
+	mi := s.MetaInfo()
+	info := &mi.Info
+
+	open := s.opts.Storage
+	if open == nil {
+		open = openDir
+	}
+
+	st, err := open(s.dir, info)
+	if err != nil {
+		return err
+	}
+
+	p := picker.New(info, nil)
+	p.SetSequential(s.opts.Sequential)
+
+	v := &verify.Verifier{Info: info, Storage: st, Clock: s.clk}
+	ch := &choker.Choker{Clock: s.clk}
+
+	s.mu.Lock()
+	s.storage, s.picker, s.verifier, s.choker = st, p, v, ch
+	s.senders = make(map[int][]int)
+	s.writing = make(map[peer.Block]bool)
+	s.verifying = 0
+	s.left = info.TotalLength()
+	have, files := s.have, s.files
+	s.state = StateChecking
+	s.mu.Unlock()
+
+	s.publish()
+
+	// What was had holds only if the files are as they were.
+	if have != nil && (!peer.ValidBits(have, p.NumPieces()) || !slices.Equal(files, s.statFiles(info))) {
+		have = nil
+	}
+
+	for i := 0; i < p.NumPieces(); i++ {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
+		var ok bool
+		if have != nil {
+			ok = have.Has(i)
+		} else {
+			// Missing data is merely not had.
+			ok, _ = v.Check(i)
+		}
+
+		if ok {
+			s.mu.Lock()
+			p.SetHave(i)
+			s.left -= uint64(p.PieceLength(i))
+			s.mu.Unlock()
+		}
+	}
+
+	s.mu.Lock()
+	complete := p.Complete()
+	s.started = true
+	s.mu.Unlock()
+
+	if complete {
+		s.seeding()
+	} else {
+		s.setState(StateDownloading)
+	}
+
+	s.spawn(func() { v.Run(ctx) })
+	s.spawn(func() { ch.Run(ctx) })
+	s.spawn(s.verified)
+
+	s.wake()
+
+	return nil
+}
+
+func openDir(dir string, info *metainfo.Info) (storage.Storage, error) {
+	return storage.OpenDir(dir, info)
+}
+
+// seeding moves on to seeding, as every piece is had.
+func (s *Session) seeding() {
+	s.tracker.Completed()
+	s.choker.SetSeeding(true)
+	s.setState(StateSeeding)
+}
+
+// fail stops the run, for err.
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel(err)
+	}
+}
+
+// spawn runs f in a goroutine that Run waits for.
+func (s *Session) spawn(f func()) {
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+}
+
+// stats is the totals for trackers.
+func (s *Session) stats() (uploaded, downloaded, left uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	left = s.left
+	if s.mi == nil {
+		// Not zero, or trackers take us for a seed.
+		left = unknownLeft
+	}
+
+	return s.uploaded, s.downloaded, left
+}
+
+// port is the port to announce.
+func (s *Session) port() uint16 {
+	if s.opts.Port != 0 || s.opts.Listener == nil {
+		return s.opts.Port
+	}
+
+	if addr, ok := s.opts.Listener.Addr().(*net.TCPAddr); ok {
+		return uint16(addr.Port)
+	}
+
+	return 0
+}
+
+// verified acts on the outcome of each piece verified.
+func (s *Session) verified() {
+	for r := range s.verifier.Results() {
+		if r.Err != nil {
+			s.fail(r.Err)
+			continue
+		}
+
+		s.mu.Lock()
+
+		s.verifying--
+		s.picker.Verified(r.Index, r.OK)
+
+		var bad []*peerConn
+		if r.OK {
+			s.left -= uint64(s.picker.PieceLength(r.Index))
+		} else {
+			for _, key := range r.Peers {
+				if pc := s.peers[key]; pc != nil && s.verifier.Blame(key).Sole != 0 {
+					bad = append(bad, pc)
+				}
+			}
+		}
+
+		complete := r.OK && s.picker.Complete()
+		peers := s.peerList()
+
+		s.mu.Unlock()
+
+		if r.OK {
+			for _, pc := range peers {
+				pc.have(r.Index)
+			}
+		}
+
+		// Those that sent all of a bad piece are gone.
+		for _, pc := range bad {
+			pc.conn.Close()
+		}
+
+		if complete {
+			s.seeding()
+		}
+
+		for _, pc := range peers {
+			pc.wake()
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/internal/testtorrent"
+	"github.com/joelancaster/bytepour/pkg/bittorrent"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/peer"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+// torrent is the test torrent, of pieces of two blocks.
+var torrent = testtorrent.Torrent{
+	Name:        "t",
+	PieceLength: 2 * peer.BlockLength,
+	Files: []testtorrent.File{
+		{Path: "a", Data: testtorrent.Pattern(100000, 1)},
+		{Path: "d/b", Data: testtorrent.Pattern(3, 2)},
+		{Path: "d/c", Data: testtorrent.Pattern(70001, 3)},
+	},
+}
+
+// newTorrent makes the test torrent, announced to announce
+// if not empty, and writes its files under dir, if not empty.
+func newTorrent(t *testing.T, dir, announce string) *metainfo.MetaInfoPreCompute {
+	t.Helper()
+
+	tt := torrent
+	tt.Announce = announce
+
+	if dir != "" {
+		tt.Write(t, dir)
+	}
+
+	return tt.MetaInfo(t)
+}
+
+func listen(t *testing.T) (net.Listener, netip.AddrPort) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l, l.Addr().(*net.TCPAddr).AddrPort()
+}
+
+// start runs s until the test ends.
+func start(t *testing.T, s *Session) (stop func() error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	var once sync.Once
+	var err error
+
+	stop = func() error {
+		once.Do(func() {
+			cancel()
+			err = <-done
+		})
+
+		return err
+	}
+
+	t.Cleanup(func() { stop() })
+
+	return stop
+}
+
+// waitFor waits for the session to reach state.
+func waitFor(t *testing.T, updates <-chan Progress, state State) Progress {
+	t.Helper()
+
+	timeout := time.After(20 * time.Second)
+
+	for {
+		select {
+		case p := <-updates:
+			if p.Err != nil {
+				t.Fatalf("session failed: %v", p.Err)
+			}
+
+			if p.State == state {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", state)
+		}
+	}
+}
+
+func newSeeder(t *testing.T, mi *metainfo.MetaInfoPreCompute) (*Session, netip.AddrPort) {
+	t.Helper()
+
+	l, addr := listen(t)
+
+	s, err := Open(mi, t.TempDir(), Options{PeerId: bittorrent.NewPeerId(bittorrent.DefaultVersion), Listener: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Its files already in place.
+	newTorrent(t, s.dir, "")
+
+	updates, cancel := s.Subscribe()
+	defer cancel()
+
+	start(t, s)
+	waitFor(t, updates, StateSeeding)
+
+	return s, addr
+}
+
+func TestDownload(t *testing.T) {
+	mi := newTorrent(t, "", "")
+	seeder, addr := newSeeder(t, mi)
+
+	dir := t.TempDir()
+
+	s, err := Open(mi, dir, Options{PeerId: bittorrent.NewPeerId(bittorrent.DefaultVersion), Peers: []netip.AddrPort{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates, cancel := s.Subscribe()
+	defer cancel()
+
+	stop := start(t, s)
+
+	p := waitFor(t, updates, StateSeeding)
+
+	total := mi.Info.TotalLength()
+	if p.Have != p.Pieces || p.Pieces != mi.Info.NumPieces() || p.Left != 0 || p.Downloaded < total {
+		t.Fatalf("progress when done = %+v", p)
+	}
+
+	torrent.Check(t, dir)
+
+	if up := seeder.Progress().Uploaded; up < total {
+		t.Fatalf("seeder uploaded %d, want %d", up, total)
+	}
+
+	if err := stop(); err != context.Canceled {
+		t.Fatalf("Run = %v, want %v", err, context.Canceled)
+	}
+
+	if p := waitFor(t, updates, StateStopped); p.Err != nil || p.Have != p.Pieces {
+		t.Fatalf("progress when stopped = %+v", p)
+	}
+
+	// Run again, what's had is known.
+	start(t, s)
+	waitFor(t, updates, StateSeeding)
+}
+
+func TestDownloadMagnet(t *testing.T) {
+	mi := newTorrent(t, "", "")
+	_, addr := newSeeder(t, mi)
+
+	ih := sha1.Sum(mi.InfoDict)
+	uri := "magnet:?xt=urn:btih:" + hex.EncodeToString(ih[:]) + "&dn=x&x.pe=" + addr.String()
+
+	dir := t.TempDir()
+
+	s, err := OpenMagnet(uri, dir, Options{PeerId: bittorrent.NewPeerId(bittorrent.DefaultVersion)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Name() != "x" || s.MetaInfo() != nil {
+		t.Fatalf("before metadata, Name = %q, MetaInfo = %v", s.Name(), s.MetaInfo())
+	}
+
+	updates, cancel := s.Subscribe()
+	defer cancel()
+
+	start(t, s)
+
+	waitFor(t, updates, StateMetadata)
+	waitFor(t, updates, StateSeeding)
+
+	torrent.Check(t, dir)
+
+	if s.Name() != "t" || !bytes.Equal(s.MetaInfo().InfoDict, mi.InfoDict) {
+		t.Fatalf("after metadata, Name = %q", s.Name())
+	}
+}
+
+func TestDownloadTracker(t *testing.T) {
+	mi := newTorrent(t, "", "http://tracker/announce")
+	_, addr := newSeeder(t, mi)
+
+	a := &testtorrent.Announcer{Peers: []netip.AddrPort{addr}}
+	l, laddr := listen(t)
+
+	s, err := Open(mi, t.TempDir(), Options{PeerId: bittorrent.NewPeerId(bittorrent.DefaultVersion), Listener: l, Announcer: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates, cancel := s.Subscribe()
+	defer cancel()
+
+	stop := start(t, s)
+	waitFor(t, updates, StateSeeding)
+
+	// Completed is announced as soon as it may be.
+	for len(a.Requests()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+
+	reqs := a.Requests()
+
+	events := []tracker.AnnounceEvent{tracker.EventStarted, tracker.EventCompleted, tracker.EventStopped}
+	if len(reqs) != len(events) {
+		t.Fatalf("%d announces, want %d", len(reqs), len(events))
+	}
+
+	total := mi.Info.TotalLength()
+
+	for i, req := range reqs {
+		if req.Event != events[i] || req.InfoHash != s.InfoHash() || req.Port != uint64(laddr.Port()) || !req.Compact {
+			t.Fatalf("announce %d = %+v", i, req)
+		}
+	}
+
+	if first, last := reqs[0], reqs[2]; first.Left != total || last.Left != 0 || last.Downloaded < total {
+		t.Fatalf("announced left %d then %d, downloaded %d", first.Left, last.Left, last.Downloaded)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	mi := newTorrent(t, "", "")
+
+	s, err := Open(mi, t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ours, _ := net.Pipe()
+
+	theirs := peer.Handshake{InfoHash: s.InfoHash()}
+	if err := s.Accept(ours, &theirs); err != ErrNotRunning {
+		t.Fatalf("Accept before Run = %v, want %v", err, ErrNotRunning)
+	}
+
+	theirs.InfoHash[0]++
+	if err := s.Accept(ours, &theirs); err != ErrWrongTorrent {
+		t.Fatalf("Accept of another torrent = %v, want %v", err, ErrWrongTorrent)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	mi := newTorrent(t, "", "")
+	mi.Info.Pieces = mi.Info.Pieces[:20]
+
+	if _, err := Open(mi, t.TempDir(), Options{}); err == nil {
+		t.Fatal("Open of metainfo missing hashes succeeded")
+	}
+
+	if _, err := OpenMagnet("magnet:?dn=x", t.TempDir(), Options{}); err == nil {
+		t.Fatal("OpenMagnet without an info hash succeeded")
+	}
+}
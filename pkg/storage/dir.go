@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+)
+
+// The most files a Dir keeps open at once.
+const maxOpen = 64
+
+// Dir is Storage in files under a directory, laid
+// out as the metainfo says. Missing data reads as
+// io.ErrUnexpectedEOF.
+type Dir struct {
+	root string
+	layout
+
+	mu sync.Mutex
+	// Open files, by index.
+	open map[int]*os.File
+}
+
+// OpenDir opens the storage for info under root, creating
+// the directories and files it needs that are missing.
+// Files are created empty, and grow as written.
+func OpenDir(root string, info *metainfo.Info) (*Dir, error) {
+	l, err := newLayout(info)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dir{root: root, layout: l, open: make(map[int]*os.File)}
+
+	for i := range d.files {
+		path := d.Path(i)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		f.Close()
+	}
+
+	return d, nil
+}
+
+// Files is the files of the torrent.
+// It must not be modified.
+func (d *Dir) Files() []File {
+	return d.files
+}
+
+// Path is the path of file i on disk.
+func (d *Dir) Path(i int) string {
+	return filepath.Join(d.root, filepath.FromSlash(d.files[i].Path))
+}
+
+// Preallocate makes each file its full length, which
+// on most file systems takes no space until written.
+func (d *Dir) Preallocate() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.files {
+		f, err := d.file(i)
+		if err != nil {
+			return err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		if fi.Size() < d.files[i].Length {
+			if err := f.Truncate(d.files[i].Length); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadAt implements Storage for Dir.
+func (d *Dir) ReadAt(p []byte, index int, begin uint32) (int, error) {
+	off, err := d.offset(index, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var n int
+	err = d.each(off, len(p), func(i int, fileOff int64, lo, hi int) error {
+		f, err := d.file(i)
+		if err != nil {
+			return err
+		}
+
+		k, err := f.ReadAt(p[lo:hi], fileOff)
+		n += k
+
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	})
+
+	return n, err
+}
+
+// WriteAt implements Storage for Dir.
+func (d *Dir) WriteAt(p []byte, index int, begin uint32) (int, error) {
+	off, err := d.offset(index, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var n int
+	err = d.each(off, len(p), func(i int, fileOff int64, lo, hi int) error {
+		f, err := d.file(i)
+		if err != nil {
+			return err
+		}
+
+		k, err := f.WriteAt(p[lo:hi], fileOff)
+		n += k
+
+		return err
+	})
+
+	return n, err
+}
+
+// Close closes the open files.
+func (d *Dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errs []error
+	for i, f := range d.open {
+		errs = append(errs, f.Close())
+		delete(d.open, i)
+	}
+
+	return errors.Join(errs...)
+}
+
+// file opens file i, if it isn't, closing another
+// if too many are. d.mu must be held.
+func (d *Dir) file(i int) (*os.File, error) {
+	if f := d.open[i]; f != nil {
+		return f, nil
+	}
+
+	if len(d.open) >= maxOpen {
+		for j, f := range d.open {
+			f.Close()
+			delete(d.open, j)
+			break
+		}
+	}
+
+	f, err := os.OpenFile(d.Path(i), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	d.open[i] = f
+
+	return f, nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+)
+
+// Memory is Storage in memory, for tests
+// and torrents small enough to hold.
+type Memory struct {
+	layout
+
+	mu   sync.RWMutex
+	data []byte
+}
+
+// NewMemory makes the storage for info, all zeros.
+func NewMemory(info *metainfo.Info) (*Memory, error) {
+	l, err := newLayout(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Memory{layout: l, data: make([]byte, l.length)}, nil
+}
+
+// Files is the files of the torrent.
+// It must not be modified.
+func (m *Memory) Files() []File {
+	return m.files
+}
+
+// Bytes is the torrent's data, all its files one after
+// the other. It must not be used while writing.
+func (m *Memory) Bytes() []byte {
+	return m.data
+}
+
+// ReadAt implements Storage for Memory.
+func (m *Memory) ReadAt(p []byte, index int, begin uint32) (int, error) {
+	off, err := m.offset(index, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return copy(p, m.data[off:]), nil
+}
+
+// WriteAt implements Storage for Memory.
+func (m *Memory) WriteAt(p []byte, index int, begin uint32) (int, error) {
+	off, err := m.offset(index, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return copy(m.data[off:], p), nil
+}
+
+// Close implements Storage for Memory.
+func (m *Memory) Close() error {
+	return nil
+}
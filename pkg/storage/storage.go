@@ -0,0 +1,165 @@
+// Package storage keeps the data of a torrent's pieces, in
+// the files the metainfo describes or in memory.
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+)
+
+// ErrOutOfRange is returned when reading or writing
+// past the end of a piece, or of a piece that isn't.
+var ErrOutOfRange = errors.New("storage: out of range of piece")
+
+// PathError is returned for metainfo whose file paths
+// are unsafe to create, as they would leave the directory.
+type PathError struct {
+	Path   string
+	Reason string
+}
+
+// Error implements the error interface for PathError.
+func (e *PathError) Error() string {
+	return "storage: " + strconv.Quote(e.Path) + ": " + e.Reason
+}
+
+// Storage holds the data of a torrent, addressed by piece
+// and offset into it. Reads and writes may span files, and
+// may be made concurrently.
+type Storage interface {
+	// ReadAt reads len(p) bytes of piece index from begin,
+	// as io.ReaderAt does.
+	ReadAt(p []byte, index int, begin uint32) (int, error)
+	// WriteAt writes p to piece index from begin,
+	// as io.WriterAt does.
+	WriteAt(p []byte, index int, begin uint32) (int, error)
+	Close() error
+}
+
+// File is a file of a torrent, and where
+// it lies in the torrent's data.
+type File struct {
+	// Slash separated, starting with the name of the
+	// torrent, which is the whole path of a single file.
+	Path   string
+	Offset int64
+	Length int64
+}
+
+// Files lays out the files of info, in order.
+func Files(info *metainfo.Info) ([]File, error) {
+	if reason := metainfo.CheckPathComponent(info.Name); reason != "" {
+		return nil, &PathError{Path: string(info.Name), Reason: reason}
+	}
+
+	if len(info.Files) == 0 {
+		return []File{{Path: string(info.Name), Length: int64(info.Length)}}, nil
+	}
+
+	files := make([]File, len(info.Files))
+
+	var offset int64
+	for i := range info.Files {
+		f := &info.Files[i]
+
+		path := string(info.Name)
+		for _, c := range f.Path {
+			path += "/" + string(c)
+		}
+
+		if len(f.Path) == 0 {
+			return nil, &PathError{Path: path, Reason: "empty path"}
+		}
+
+		for _, c := range f.Path {
+			if reason := metainfo.CheckPathComponent(c); reason != "" {
+				return nil, &PathError{Path: path, Reason: reason}
+			}
+		}
+
+		files[i] = File{Path: path, Offset: offset, Length: int64(f.Length)}
+		offset += int64(f.Length)
+	}
+
+	return files, nil
+}
+
+// layout maps pieces onto files.
+type layout struct {
+	files       []File
+	pieceLength int64
+	// The length of the last piece.
+	lastLength int64
+	length     int64
+	numPieces  int
+}
+
+func newLayout(info *metainfo.Info) (layout, error) {
+	files, err := Files(info)
+	if err != nil {
+		return layout{}, err
+	}
+
+	l := layout{
+		files:       files,
+		pieceLength: int64(info.PieceLength),
+		length:      int64(info.TotalLength()),
+		numPieces:   info.NumPieces(),
+	}
+
+	if l.numPieces != 0 {
+		l.lastLength = int64(info.PieceSize(l.numPieces - 1))
+	}
+
+	return l, nil
+}
+
+// offset is where n bytes from begin of piece index
+// lie in the torrent's data, if within the piece.
+func (l *layout) offset(index int, begin uint32, n int) (int64, error) {
+	if index < 0 || index >= l.numPieces {
+		return 0, ErrOutOfRange
+	}
+
+	size := l.pieceLength
+	if index == l.numPieces-1 {
+		size = l.lastLength
+	}
+
+	if int64(begin)+int64(n) > size {
+		return 0, ErrOutOfRange
+	}
+
+	return int64(index)*l.pieceLength + int64(begin), nil
+}
+
+// each calls f for each file that the n bytes at off
+// span: with the file's index, the offset into it, and
+// the part of the n bytes in it, stopping at an error.
+func (l *layout) each(off int64, n int, f func(file int, fileOff int64, lo, hi int) error) error {
+	// The first file ending after off, skipping empty ones.
+	i := sort.Search(len(l.files), func(i int) bool {
+		return l.files[i].Offset+l.files[i].Length > off
+	})
+
+	for done := 0; done < n; i++ {
+		file := &l.files[i]
+		if file.Length == 0 {
+			continue
+		}
+
+		fileOff := off + int64(done) - file.Offset
+		k := int(min(int64(n-done), file.Length-fileOff))
+
+		if err := f(i, fileOff, done, done+k); err != nil {
+			return err
+		}
+
+		done += k
+	}
+
+	return nil
+}
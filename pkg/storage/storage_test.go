@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+)
+
+// multi is a torrent of three files, the middle
+// empty, over pieces of 4 bytes: 6, 0 and 5 bytes.
+var multi = metainfo.Info{
+	Name:        []byte("t"),
+	PieceLength: 4,
+	Files: []metainfo.File{
+		{Length: 6, Path: [][]byte{[]byte("a")}},
+		{Length: 0, Path: [][]byte{[]byte("d"), []byte("empty")}},
+		{Length: 5, Path: [][]byte{[]byte("d"), []byte("b")}},
+	},
+}
+
+func TestFiles(t *testing.T) {
+	files, err := Files(&multi)
+	want := []File{{"t/a", 0, 6}, {"t/d/empty", 6, 0}, {"t/d/b", 6, 5}}
+	if err != nil || !reflect.DeepEqual(files, want) {
+		t.Fatalf("Files = %v, %v, want %v", files, err, want)
+	}
+
+	single := metainfo.Info{Name: []byte("f"), Length: 3, PieceLength: 4}
+	if files, err := Files(&single); err != nil || !reflect.DeepEqual(files, []File{{"f", 0, 3}}) {
+		t.Fatalf("Files = %v, %v", files, err)
+	}
+
+	unsafe := []metainfo.Info{
+		{Name: []byte("..")},
+		{Name: []byte("a/b")},
+		{Name: []byte("t"), Files: []metainfo.File{{Length: 1, Path: [][]byte{[]byte(".."), []byte("x")}}}},
+		{Name: []byte("t"), Files: []metainfo.File{{Length: 1}}},
+	}
+
+	for _, info := range unsafe {
+		var perr *PathError
+		if _, err := Files(&info); !errors.As(err, &perr) {
+			t.Fatalf("Files(%q) = %v, want a PathError", info.Name, err)
+		}
+	}
+}
+
+func testStorage(t *testing.T, s Storage) {
+	t.Helper()
+
+	// Pieces "0123", "45|67", "89A".
+	data := []byte("0123456789A")
+	for i := 0; i < 3; i++ {
+		p := data[4*i : min(4*i+4, len(data))]
+		if n, err := s.WriteAt(p, i, 0); n != len(p) || err != nil {
+			t.Fatalf("WriteAt(piece %d) = %d, %v", i, n, err)
+		}
+	}
+
+	buf := make([]byte, 3)
+	if n, err := s.ReadAt(buf, 1, 1); n != 3 || err != nil || string(buf) != "567" {
+		t.Fatalf("ReadAt = %d, %v, %q", n, err, buf)
+	}
+
+	if _, err := s.WriteAt([]byte("xy"), 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ReadAt(buf, 1, 0); err != nil || string(buf) != "4xy" {
+		t.Fatalf("ReadAt = %v, %q", err, buf)
+	}
+
+	tests := []struct {
+		index int
+		begin uint32
+		n     int
+	}{
+		{-1, 0, 1},
+		{3, 0, 1},
+		{0, 2, 3},
+		// The last piece is short.
+		{2, 0, 4},
+	}
+
+	for _, tt := range tests {
+		if _, err := s.ReadAt(make([]byte, tt.n), tt.index, tt.begin); err != ErrOutOfRange {
+			t.Fatalf("ReadAt(%d bytes of piece %d at %d) = %v, want %v", tt.n, tt.index, tt.begin, err, ErrOutOfRange)
+		}
+
+		if _, err := s.WriteAt(make([]byte, tt.n), tt.index, tt.begin); err != ErrOutOfRange {
+			t.Fatalf("WriteAt(%d bytes of piece %d at %d) = %v, want %v", tt.n, tt.index, tt.begin, err, ErrOutOfRange)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m, err := NewMemory(&multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, m)
+
+	if string(m.Bytes()) != "01234xy789A" {
+		t.Fatalf("data = %q", m.Bytes())
+	}
+}
+
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+
+	d, err := OpenDir(root, &multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing data can't be read.
+	if _, err := d.ReadAt(make([]byte, 4), 1, 0); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadAt of unwritten data = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	testStorage(t, d)
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{"t/a": "01234x", "t/d/empty": "", "t/d/b": "y789A"} {
+		got, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil || string(got) != want {
+			t.Fatalf("%s = %q, %v, want %q", path, got, err, want)
+		}
+	}
+
+	if d.Path(2) != filepath.Join(root, "t", "d", "b") {
+		t.Fatalf("Path = %s", d.Path(2))
+	}
+}
+
+func TestDirPreallocate(t *testing.T) {
+	root := t.TempDir()
+
+	d, err := OpenDir(root, &multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.Close()
+
+	if err := d.Preallocate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, f := range d.Files() {
+		fi, err := os.Stat(d.Path(i))
+		if err != nil || fi.Size() != f.Length {
+			t.Fatalf("%s is %v long, %v, want %d", f.Path, fi.Size(), err, f.Length)
+		}
+	}
+
+	// Preallocated data reads as zeros.
+	buf := []byte("xxx")
+	if _, err := d.ReadAt(buf, 2, 0); err != nil || !bytes.Equal(buf, []byte{0, 0, 0}) {
+		t.Fatalf("ReadAt = %v, %q", err, buf)
+	}
+}
+
+func TestDirManyFiles(t *testing.T) {
+	// More files than are kept open, each a piece.
+	info := metainfo.Info{Name: []byte("many"), PieceLength: 2}
+	for i := 0; i < 2*maxOpen; i++ {
+		info.Files = append(info.Files, metainfo.File{Length: 2, Path: [][]byte{[]byte(strconv.Itoa(i))}})
+	}
+
+	d, err := OpenDir(t.TempDir(), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.Close()
+
+	for round := 0; round < 2; round++ {
+		for i := range info.Files {
+			p := []byte{byte(i), byte(round)}
+			if _, err := d.WriteAt(p, i, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	buf := make([]byte, 2)
+	for i := range info.Files {
+		if _, err := d.ReadAt(buf, i, 0); err != nil || buf[0] != byte(i) || buf[1] != 1 {
+			t.Fatalf("ReadAt(piece %d) = %v, %v", i, err, buf)
+		}
+	}
+
+	if len(d.open) > maxOpen {
+		t.Fatalf("%d files open", len(d.open))
+	}
+}
@@ -1,7 +1,9 @@
 package tracker
 
 import (
+	"bytes"
 	"math"
+	"net/netip"
 )
 
 // URLBuffer is a working space
@@ -10,34 +12,43 @@ import (
 // sane url.
 type URLBuffer [2048]byte
 
-// AnnounceEvent is a three state
-// type representing the state
-// the downloader is in.
+// AnnounceEvent is a type representing
+// the state change the downloader is
+// telling the tracker about, if any.
 type AnnounceEvent byte
 
 const (
-	// Must be sent when we quit a download
-	EventStopped = AnnounceEvent(0)
+	// A regular announce made at the tracker's
+	// interval, the event parameter is omitted.
+	EventNone = AnnounceEvent(0)
 	// Must be sent when we first contact the tracker
 	EventStarted = AnnounceEvent(1)
 	// Must be sent when the download has completed
 	// but not if the download is already completed when we contact
 	// the tracker
 	EventCompleted = AnnounceEvent(2)
+	// Must be sent when we quit a download
+	EventStopped = AnnounceEvent(3)
+	// Sent by a partial seed that has stopped
+	// downloading but is still uploading (BEP 21)
+	EventPaused = AnnounceEvent(4)
 )
 
 // String implements the Stringer interface for AnnounceEvent
 // String must inline to avoid heap allocs.
+// EventNone, and any unknown event, is the empty string.
 func (e AnnounceEvent) String() string {
 	switch e {
-	case EventStopped:
-		return "stopped"
 	case EventStarted:
 		return "started"
 	case EventCompleted:
 		return "completed"
-	default:
+	case EventStopped:
 		return "stopped"
+	case EventPaused:
+		return "paused"
+	default:
+		return ""
 	}
 }
 
@@ -53,6 +64,34 @@ type AnnounceRequest struct {
 	Left       uint64
 	NumWant    uint64
 	Event      AnnounceEvent
+	// Ask for peers as a compact string (BEP 23).
+	Compact bool
+	// Tell the tracker we don't need peer IDs
+	// in a dictionary model peer list.
+	NoPeerId bool
+
+	// The parameters below are optional,
+	// and only sent when set.
+
+	// Identifies us to the tracker should our IP change.
+	Key uint32
+	// The tracker id from the tracker's last response.
+	TrackerId []byte
+	// Our address, if the tracker should not
+	// use the address the request came from.
+	IP netip.Addr
+	// Our IPv4 and IPv6 addresses, for dual stack
+	// peers to tell the tracker about both (BEP 7).
+	IPv4 netip.Addr
+	IPv6 netip.Addr
+	// We accept encrypted connections.
+	SupportCrypto bool
+	// We only accept encrypted connections.
+	RequireCrypto bool
+	// Bytes downloaded that failed the hash check.
+	Corrupt uint64
+	// Bytes downloaded that we already had.
+	Redundant uint64
 }
 
 // Build adds required query params to buf, for the announce request
@@ -72,10 +111,37 @@ func Build(buf *URLBuffer, announce []byte, req *AnnounceRequest) []byte {
 		left       = "left"
 		event      = "event"
 		numwant    = "numwant"
+
+		// optional flags, including the leading '&'
+		compactFlag       = "&compact=1"
+		noPeerIdFlag      = "&no_peer_id=1"
+		supportCryptoFlag = "&supportcrypto=1"
+		requireCryptoFlag = "&requirecrypto=1"
+
+		// optional query param keys
+		key       = "key"
+		trackerid = "trackerid"
+		ip        = "ip"
+		ipv4      = "ipv4"
+		ipv6      = "ipv6"
+		corrupt   = "corrupt"
+		redundant = "redundant"
+
+		// the longest textual IP address,
+		// an IPv4 mapped IPv6 address.
+		maxIPLen = len("ffff:ffff:ffff:ffff:ffff:ffff:255.255.255.255")
 	)
 
 	var n int
 
+	// a fragment is never sent to the server,
+	// and the query must come before it anyway.
+	if fragment := bytes.IndexByte(announce, '#'); fragment >= 0 {
+		announce = announce[:fragment]
+	}
+
+	sep := querySep(announce)
+
 	// length of the announce URL info hash, peer id, and all keys are known a priori
 	// we also calculate the length of the integer fields after they're converted to strings
 	// hence we know the length of the entire URL before we construct it
@@ -91,7 +157,16 @@ func Build(buf *URLBuffer, announce []byte, req *AnnounceRequest) []byte {
 		len(port) + 1 /*len(is)*/ + uintLen(req.Port) + 1 /*len(and)*/ +
 		len(left) + 1 /*len(is)*/ + uintLen(req.Left) + 1 /*len(and)*/ +
 		len(numwant) + 1 /*len(is)*/ + uintLen(req.NumWant) + 1 /*len(and)*/ +
-		len(event) + 1 /*len(is)*/ + len("completed")
+		len(event) + 1 /*len(is)*/ + len("completed") +
+		len(compactFlag) + len(noPeerIdFlag) +
+		len(supportCryptoFlag) + len(requireCryptoFlag) +
+		1 /*len(and)*/ + len(key) + 1 /*len(is)*/ + 8 /*hex Key*/ +
+		1 /*len(and)*/ + len(trackerid) + 1 /*len(is)*/ + 3*len(req.TrackerId) +
+		1 /*len(and)*/ + len(corrupt) + 1 /*len(is)*/ + uintLen(req.Corrupt) +
+		1 /*len(and)*/ + len(redundant) + 1 /*len(is)*/ + uintLen(req.Redundant) +
+		1 /*len(and)*/ + len(ip) + 1 /*len(is)*/ + maxIPLen +
+		1 /*len(and)*/ + len(ipv4) + 1 /*len(is)*/ + maxIPLen +
+		1 /*len(and)*/ + len(ipv6) + 1 /*len(is)*/ + maxIPLen
 
 	if lengthUpperBound >= len(buf) {
 		return nil
@@ -101,8 +176,10 @@ func Build(buf *URLBuffer, announce []byte, req *AnnounceRequest) []byte {
 	n += copy(buf[n:], announce)
 
 	// a.com:9000?
-	buf[n] = param
-	n += 1
+	if sep != 0 {
+		buf[n] = sep
+		n += 1
+	}
 
 	// a.com:9000?info_hash
 	n += copy(buf[n:], info_hash)
@@ -205,27 +282,140 @@ func Build(buf *URLBuffer, announce []byte, req *AnnounceRequest) []byte {
 	// uploaded=23&numwant=50&port=6007&left=23904
 	n += putUint(buf[n:], req.Left)
 
-	// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
-	// uploaded=23&numwant=50&port=6007&left=23904&
-	buf[n] = and
-	n += 1
+	// regular announces have no event.
+	if eventName := req.Event.String(); eventName != "" {
+		// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
+		// uploaded=23&numwant=50&port=6007&left=23904&
+		buf[n] = and
+		n += 1
+
+		// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
+		// uploaded=23&numwant=50&port=6007&left=23904&event
+		n += copy(buf[n:], []byte(event))
+
+		// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
+		// uploaded=23&numwant=50&port=6007&left=23904&event=
+		buf[n] = is
+		n += 1
+
+		// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
+		// uploaded=23&numwant=50&port=6007&left=23904&event=completed
+		n += copy(buf[n:], []byte(eventName))
+	}
 
 	// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
-	// uploaded=23&numwant=50&port=6007&left=23904&event
-	n += copy(buf[n:], []byte(event))
+	// uploaded=23&numwant=50&port=6007&left=23904&event=completed&compact=1
+	if req.Compact {
+		n += copy(buf[n:], compactFlag)
+	}
 
 	// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
-	// uploaded=23&numwant=50&port=6007&left=23904&event=
-	buf[n] = is
-	n += 1
+	// uploaded=23&numwant=50&port=6007&left=23904&event=completed&compact=1&no_peer_id=1
+	if req.NoPeerId {
+		n += copy(buf[n:], noPeerIdFlag)
+	}
 
-	// a.com:9000?info_hash=a93ef199cd398209802&peer_id=BitTorrent1234567890&downloaded=93&\
-	// uploaded=23&numwant=50&port=6007&left=23904&event=completed
-	n += copy(buf[n:], []byte(req.Event.String()))
+	// ...&supportcrypto=1
+	if req.SupportCrypto {
+		n += copy(buf[n:], supportCryptoFlag)
+	}
+
+	// ...&requirecrypto=1
+	if req.RequireCrypto {
+		n += copy(buf[n:], requireCryptoFlag)
+	}
+
+	// ...&key=1A2B3C4D
+	if req.Key != 0 {
+		n += putKey(buf[n:], key)
+		n += putHex32(buf[n:], req.Key)
+	}
+
+	// ...&trackerid=abc123
+	if len(req.TrackerId) != 0 {
+		n += putKey(buf[n:], trackerid)
+		n += Escape(buf[n:], req.TrackerId)
+	}
+
+	// ...&ip=192.168.0.1
+	if req.IP.IsValid() {
+		n += putKey(buf[n:], ip)
+		n += putIP(buf[n:], req.IP)
+	}
+
+	// ...&ipv4=192.168.0.1
+	if req.IPv4.IsValid() {
+		n += putKey(buf[n:], ipv4)
+		n += putIP(buf[n:], req.IPv4)
+	}
+
+	// ...&ipv6=2001:db8::1
+	if req.IPv6.IsValid() {
+		n += putKey(buf[n:], ipv6)
+		n += putIP(buf[n:], req.IPv6)
+	}
+
+	// ...&corrupt=16384
+	if req.Corrupt != 0 {
+		n += putKey(buf[n:], corrupt)
+		n += putUint(buf[n:], req.Corrupt)
+	}
+
+	// ...&redundant=16384
+	if req.Redundant != 0 {
+		n += putKey(buf[n:], redundant)
+		n += putUint(buf[n:], req.Redundant)
+	}
 
 	return buf[:n]
 }
 
+// putKey copies "&key=" into the
+// slice at dst.
+func putKey(dst []byte, key string) int {
+	dst[0] = '&'
+	n := 1 + copy(dst[1:], key)
+	dst[n] = '='
+
+	return n + 1
+}
+
+// putHex32 copies x as 8 upper case hex
+// digits into the slice at dst.
+func putHex32(dst []byte, x uint32) int {
+	const upperhex = "0123456789ABCDEF"
+
+	for i := 7; i >= 0; i-- {
+		dst[i] = upperhex[x&15]
+		x >>= 4
+	}
+
+	return 8
+}
+
+// querySep is the separator to put between u and the
+// params added to it, 0 if none is needed. Private
+// trackers put passkeys in the URL's query, our params
+// are added to it rather than starting a new one.
+func querySep(u []byte) byte {
+	if bytes.IndexByte(u, '?') < 0 {
+		return '?'
+	}
+
+	// a.com:9000/announce? or a.com:9000/announce?passkey=abc&
+	if last := u[len(u)-1]; last == '?' || last == '&' {
+		return 0
+	}
+
+	return '&'
+}
+
+// putIP copies the textual form of addr,
+// without any zone, into the slice at dst.
+func putIP(dst []byte, addr netip.Addr) int {
+	return len(addr.WithZone("").AppendTo(dst[:0]))
+}
+
 // uintLen gives the length of 'x' if it was
 // converted to a base-10 string.
 func uintLen(x uint64) int {
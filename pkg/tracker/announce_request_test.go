@@ -2,6 +2,7 @@ package tracker
 
 import (
 	"math/rand"
+	"net/netip"
 	"net/url"
 	"strconv"
 	"testing"
@@ -25,6 +26,243 @@ func TestBuild(t *testing.T) {
 	t.Log(string(url))
 }
 
+func TestBuildEvent(t *testing.T) {
+	tests := []struct {
+		event AnnounceEvent
+		want  string
+	}{
+		{EventNone, ""},
+		{EventStarted, "started"},
+		{EventCompleted, "completed"},
+		{EventStopped, "stopped"},
+		{EventPaused, "paused"},
+		{AnnounceEvent(99), ""},
+	}
+
+	for _, tc := range tests {
+		var b URLBuffer
+
+		u := Build(&b, []byte("http://bbc.co.uk:9000/announce"), &AnnounceRequest{Event: tc.event})
+
+		parsed, err := url.Parse(string(u))
+		if err != nil {
+			t.Fatalf("url.Parse: %v", err)
+		}
+
+		q := parsed.Query()
+
+		if q.Has("event") != (tc.want != "") || q.Get("event") != tc.want {
+			t.Fatalf("event %d: got: %s, want event=%s", tc.event, u, tc.want)
+		}
+
+		if tc.event.String() != tc.want {
+			t.Fatalf("event %d: String got: %s, want: %s", tc.event, tc.event, tc.want)
+		}
+	}
+
+	// A zero value request is a regular announce.
+	var req AnnounceRequest
+	if req.Event != EventNone {
+		t.Fatalf("zero value event is not EventNone")
+	}
+}
+
+func TestBuildFlags(t *testing.T) {
+	var b URLBuffer
+
+	for _, compact := range []bool{false, true} {
+		for _, noPeerId := range []bool{false, true} {
+			u := Build(&b, []byte("http://bbc.co.uk:9000/announce"), &AnnounceRequest{
+				Compact:  compact,
+				NoPeerId: noPeerId,
+			})
+
+			parsed, err := url.Parse(string(u))
+			if err != nil {
+				t.Fatalf("url.Parse: %v", err)
+			}
+
+			q := parsed.Query()
+
+			if q.Has("compact") != compact || (compact && q.Get("compact") != "1") {
+				t.Fatalf("compact: %v, got: %s", compact, u)
+			}
+
+			if q.Has("no_peer_id") != noPeerId || (noPeerId && q.Get("no_peer_id") != "1") {
+				t.Fatalf("no_peer_id: %v, got: %s", noPeerId, u)
+			}
+		}
+	}
+}
+
+func TestBuildOptional(t *testing.T) {
+	var b URLBuffer
+
+	req := AnnounceRequest{
+		Compact:       true,
+		NoPeerId:      true,
+		Key:           0x1A2B3C,
+		TrackerId:     []byte("id &=?/\x00"),
+		IP:            netip.MustParseAddr("192.168.0.1"),
+		IPv4:          netip.MustParseAddr("10.0.0.1"),
+		IPv6:          netip.MustParseAddr("fe80::1%eth0"),
+		SupportCrypto: true,
+		RequireCrypto: true,
+		Corrupt:       16384,
+		Redundant:     32768,
+	}
+
+	u := Build(&b, []byte("http://bbc.co.uk:9000/announce"), &req)
+	if u == nil {
+		t.Fatal("did not build")
+	}
+
+	parsed, err := url.Parse(string(u))
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	want := url.Values{
+		"compact":       {"1"},
+		"no_peer_id":    {"1"},
+		"key":           {"001A2B3C"},
+		"trackerid":     {string(req.TrackerId)},
+		"ip":            {"192.168.0.1"},
+		"ipv4":          {"10.0.0.1"},
+		"ipv6":          {"fe80::1"},
+		"supportcrypto": {"1"},
+		"requirecrypto": {"1"},
+		"corrupt":       {"16384"},
+		"redundant":     {"32768"},
+	}
+
+	q := parsed.Query()
+
+	for k, v := range want {
+		if got := q[k]; len(got) != 1 || got[0] != v[0] {
+			t.Fatalf("%s: got: %q, want: %q (%s)", k, got, v, u)
+		}
+	}
+
+	// None of them are sent when unset.
+	u = Build(&b, []byte("http://bbc.co.uk:9000/announce"), &AnnounceRequest{})
+
+	parsed, err = url.Parse(string(u))
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	for k := range want {
+		if parsed.Query().Has(k) {
+			t.Fatalf("%s sent when unset: %s", k, u)
+		}
+	}
+
+	// Still bounds checked up front.
+	req.TrackerId = make([]byte, 700)
+	if Build(&b, []byte("http://bbc.co.uk:9000/announce"), &req) != nil {
+		t.Fatal("built a URL longer than the buffer")
+	}
+}
+
+func TestBuildExistingQuery(t *testing.T) {
+	req := AnnounceRequest{
+		InfoHash: [20]byte{0xde, 0xad, 0xbe, 0xef, ' ', '&', '=', '?', '#'},
+		PeerId: [20]byte{'B', 'i', 't', 'T', 'o', 'r', 'r', 'e', 'n', 't',
+			'1', '2', '3', '4', '5', '6', '7', '8', '9', '0'},
+		Port:    6007,
+		Left:    234848334,
+		NumWant: 50,
+		Event:   EventStarted,
+	}
+
+	tests := []struct {
+		name     string
+		announce string
+		// what net/url should see besides our params.
+		wantPath  string
+		wantExtra url.Values
+	}{
+		{
+			name:     "NoQuery",
+			announce: "https://t.example/announce",
+			wantPath: "/announce",
+		},
+		{
+			name:      "Passkey",
+			announce:  "https://t.example/announce?passkey=abc",
+			wantPath:  "/announce",
+			wantExtra: url.Values{"passkey": {"abc"}},
+		},
+		{
+			name:      "TwoParams",
+			announce:  "https://t.example/a/announce.php?uid=1&passkey=abc",
+			wantPath:  "/a/announce.php",
+			wantExtra: url.Values{"uid": {"1"}, "passkey": {"abc"}},
+		},
+		{
+			name:     "EmptyQuery",
+			announce: "https://t.example/announce?",
+			wantPath: "/announce",
+		},
+		{
+			name:      "TrailingAnd",
+			announce:  "https://t.example/announce?passkey=abc&",
+			wantPath:  "/announce",
+			wantExtra: url.Values{"passkey": {"abc"}},
+		},
+		{
+			name:     "Fragment",
+			announce: "https://t.example/announce#frag",
+			wantPath: "/announce",
+		},
+		{
+			name:      "QueryAndFragment",
+			announce:  "https://t.example/announce?passkey=abc#frag?x=1",
+			wantPath:  "/announce",
+			wantExtra: url.Values{"passkey": {"abc"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b URLBuffer
+
+			got := Build(&b, []byte(tc.announce), &req)
+			if got == nil {
+				t.Fatal("did not build")
+			}
+
+			parsed, err := url.Parse(string(got))
+			if err != nil {
+				t.Fatalf("url.Parse: %v", err)
+			}
+
+			want := url.Values{}
+			for k, v := range tc.wantExtra {
+				want[k] = v
+			}
+			want.Set("info_hash", string(req.InfoHash[:]))
+			want.Set("peer_id", string(req.PeerId[:]))
+			want.Set("port", strconv.FormatUint(req.Port, 10))
+			want.Set("uploaded", strconv.FormatUint(req.Uploaded, 10))
+			want.Set("downloaded", strconv.FormatUint(req.Downloaded, 10))
+			want.Set("left", strconv.FormatUint(req.Left, 10))
+			want.Set("numwant", strconv.FormatUint(req.NumWant, 10))
+			want.Set("event", "started")
+
+			if parsed.Path != tc.wantPath || parsed.Fragment != "" {
+				t.Fatalf("%s: got path: %s, fragment: %s", got, parsed.Path, parsed.Fragment)
+			}
+
+			// Encode sorts, so the two are comparable.
+			if g, w := parsed.Query().Encode(), want.Encode(); g != w {
+				t.Fatalf("%s:\ngot:  %s\nwant: %s", got, g, w)
+			}
+		})
+	}
+}
+
 func Test_uintLen(t *testing.T) {
 	for i := 0; i < 100000; i++ {
 		n := rand.Uint64()
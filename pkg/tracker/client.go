@@ -0,0 +1,132 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxResponseLength bounds how much of a
+// tracker's response we are willing to read.
+const maxResponseLength = 1 << 20
+
+var (
+	// ErrURLTooLong is returned when the announce
+	// URL does not fit in a URLBuffer.
+	ErrURLTooLong = errors.New("tracker: announce URL too long")
+	// ErrResponseTooLong is returned when the
+	// tracker's response is unreasonably large.
+	ErrResponseTooLong = errors.New("tracker: response too long")
+)
+
+// FailureError is returned when the tracker
+// responds with a failure reason.
+type FailureError struct {
+	Reason string
+}
+
+// Error implements the error interface for FailureError.
+func (e *FailureError) Error() string {
+	return "tracker: announce failed: " + e.Reason
+}
+
+// StatusError is returned when the tracker responds
+// with an HTTP status other than 200.
+type StatusError struct {
+	StatusCode int
+}
+
+// Error implements the error interface for StatusError.
+func (e *StatusError) Error() string {
+	return "tracker: unexpected HTTP status " + strconv.Itoa(e.StatusCode)
+}
+
+// Client performs announces against HTTP trackers.
+// The zero value is ready to use.
+type Client struct {
+	// The client requests are made with.
+	// If nil, http.DefaultClient is used.
+	HTTP *http.Client
+	// Sent with each request, if not empty.
+	UserAgent string
+	// Bounds each request, in addition to
+	// any deadline of its context. Zero
+	// means no additional bound.
+	Timeout time.Duration
+}
+
+// Announce sends req to the tracker at announce and
+// decodes its response. A response with a failure
+// reason is returned along with a *FailureError.
+func (c *Client) Announce(ctx context.Context, announce []byte, req *AnnounceRequest) (*AnnounceResponse, error) {
+	var buf URLBuffer
+
+	u := Build(&buf, announce, req)
+	if u == nil {
+		return nil, ErrURLTooLong
+	}
+
+	body, err := c.get(ctx, string(u))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp AnnounceResponse
+
+	if perr := DecodeAnnounceResponse(&resp, body); perr.IsError() {
+		return nil, perr
+	}
+
+	if len(resp.FailureReason) != 0 {
+		return &resp, &FailureError{Reason: string(resp.FailureReason)}
+	}
+
+	return &resp, nil
+}
+
+// get requests u, yielding the body of the response.
+func (c *Client) get(ctx context.Context, u string) ([]byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.UserAgent != "" {
+		hreq.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	hresp, err := hc.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: hresp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(hresp.Body, maxResponseLength+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxResponseLength {
+		return nil, ErrResponseTooLong
+	}
+
+	return body, nil
+}
@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClientAnnounce(t *testing.T) {
+	req := AnnounceRequest{
+		InfoHash: [20]byte{0xde, 0xad, 0xbe, 0xef, ' ', '&', '=', '?'},
+		PeerId: [20]byte{'B', 'i', 't', 'T', 'o', 'r', 'r', 'e', 'n', 't',
+			'1', '2', '3', '4', '5', '6', '7', '8', '9', '0'},
+		Port:    6881,
+		Left:    1000,
+		NumWant: 50,
+		Event:   EventStarted,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		if r.URL.Path != "/announce" ||
+			q.Get("info_hash") != string(req.InfoHash[:]) ||
+			q.Get("peer_id") != string(req.PeerId[:]) ||
+			q.Get("port") != "6881" || q.Get("left") != "1000" ||
+			q.Get("event") != "started" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+
+		if got := r.Header.Get("User-Agent"); got != "bytepour/test" {
+			t.Errorf("got user agent: %s", got)
+		}
+
+		w.Write([]byte("d8:intervali900e5:peersld2:ip9:127.0.0.14:porti1eeee"))
+	}))
+	defer srv.Close()
+
+	c := Client{UserAgent: "bytepour/test", Timeout: 5 * time.Second}
+
+	resp, err := c.Announce(context.Background(), []byte(srv.URL+"/announce"), &req)
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+
+	if resp.Interval != 15*time.Minute || len(resp.Peers) != 1 ||
+		resp.Peers[0].Addr.String() != "127.0.0.1:1" {
+		t.Fatalf("got: %+v", resp)
+	}
+}
+
+func TestClientAnnounceErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/failure":
+			w.Write([]byte("d14:failure reason6:bannede"))
+		case "/status":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/garbage":
+			w.Write([]byte("<html></html>"))
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c := Client{Timeout: 50 * time.Millisecond}
+	ctx := context.Background()
+
+	var req AnnounceRequest
+
+	var failure *FailureError
+	if _, err := c.Announce(ctx, []byte(srv.URL+"/failure"), &req); !errors.As(err, &failure) || failure.Reason != "banned" {
+		t.Fatalf("failure: got: %v", err)
+	}
+
+	var status *StatusError
+	if _, err := c.Announce(ctx, []byte(srv.URL+"/status"), &req); !errors.As(err, &status) || status.StatusCode != 503 {
+		t.Fatalf("status: got: %v", err)
+	}
+
+	if _, err := c.Announce(ctx, []byte(srv.URL+"/garbage"), &req); err == nil {
+		t.Fatalf("garbage: want error")
+	}
+
+	if _, err := c.Announce(ctx, []byte(srv.URL+"/slow"), &req); !errors.Is(err, context.DeadlineExceeded) {
+		var uerr *url.Error
+		if !errors.As(err, &uerr) || !uerr.Timeout() {
+			t.Fatalf("slow: got: %v", err)
+		}
+	}
+
+	long := make([]byte, len(URLBuffer{}))
+	if _, err := c.Announce(ctx, long, &req); err != ErrURLTooLong {
+		t.Fatalf("long: got: %v, want: %v", err, ErrURLTooLong)
+	}
+}
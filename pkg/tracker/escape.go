@@ -1,18 +1,19 @@
 package tracker
 
-// Escape20 is a specialised form of net/url.escape
-// optimised for info_hash/peer_id.
+// Escape is a specialised form of net/url.escape
+// for query string values such as info_hash, peer_id
+// or a tracker id.
 //
 // In the worst case, if every character of src
 // needed escaping as hex, then we would need
-// three bytes per byte of src.
-// The caller must ensure dst can hold up to
-// 60 bytes.
-func Escape20(dst []byte, src *[20]byte) int {
+// three bytes per byte of src. The caller must
+// ensure dst can hold up to three times len(src)
+// bytes.
+func Escape(dst []byte, src []byte) int {
 	const upperhex = "0123456789ABCDEF"
 
 	j := 0
-	for i := 0; i < 20; i++ {
+	for i := 0; i < len(src); i++ {
 		switch c := src[i]; {
 		case c == ' ':
 			dst[j] = '+'
@@ -31,3 +32,64 @@ func Escape20(dst []byte, src *[20]byte) int {
 
 	return j
 }
+
+// Escape20 is Escape for an info_hash or peer_id.
+// The caller must ensure dst can hold up to
+// 60 bytes.
+func Escape20(dst []byte, src *[20]byte) int {
+	return Escape(dst, src[:])
+}
+
+// Unescape is the inverse of Escape, appending the
+// decoding of the query string value src to dst.
+// Like net/url, '+' decodes to a space. It reports
+// false if a '%' is not followed by two hex digits.
+func Unescape(dst []byte, src []byte) ([]byte, bool) {
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '+':
+			dst = append(dst, ' ')
+		case '%':
+			if i+2 >= len(src) {
+				return dst, false
+			}
+
+			hi, ok1 := unhex(src[i+1])
+			lo, ok2 := unhex(src[i+2])
+			if !ok1 || !ok2 {
+				return dst, false
+			}
+
+			dst = append(dst, hi<<4|lo)
+			i += 2
+		default:
+			dst = append(dst, c)
+		}
+	}
+
+	return dst, true
+}
+
+// Unescape20 is the inverse of Escape20, decoding
+// src into dst. It reports false unless src is
+// the escaping of exactly 20 bytes.
+func Unescape20(dst *[20]byte, src []byte) bool {
+	b, ok := Unescape(dst[:0], src)
+
+	// Unescape would have to grow dst
+	// for more than 20 bytes.
+	return ok && len(b) == 20 && &b[0] == &dst[0]
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	default:
+		return 0, false
+	}
+}
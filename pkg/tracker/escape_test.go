@@ -77,3 +77,66 @@ func BenchmarkEscape20(b *testing.B) {
 		_ = Escape20(dst[:], &corpus[i%corpusSize])
 	}
 }
+
+func TestEscape(t *testing.T) {
+	for i := 0; i < 10000; i++ {
+		src := make([]byte, rand.Intn(64))
+		rand.Read(src)
+
+		dst := make([]byte, 3*len(src))
+
+		n := Escape(dst, src)
+
+		if got, want := string(dst[:n]), url.QueryEscape(string(src)); got != want {
+			t.Fatalf("input: %x: got: %s, want: %s", src, got, want)
+		}
+	}
+}
+
+func TestUnescape20(t *testing.T) {
+	var buf [60]byte
+	var src, got [20]byte
+
+	for i := 0; i < 10000; i++ {
+		if _, err := rand.Read(src[:]); err != nil {
+			t.Fatalf("rand.Read: %v", err)
+		}
+
+		n := Escape20(buf[:], &src)
+		if !Unescape20(&got, buf[:n]) || got != src {
+			t.Fatalf("Unescape20(%s) = %x, want %x", buf[:n], got, src)
+		}
+	}
+
+	for _, bad := range []string{"", "abc", "aaaaaaaaaaaaaaaaaaaaa", "aaaaaaaaaaaaaaaaaaa%", "aaaaaaaaaaaaaaaaaaa%g0"} {
+		if Unescape20(&got, []byte(bad)) {
+			t.Fatalf("Unescape20(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{"a+b", "a b", true},
+		{"%41%2f%2F", "A//", true},
+		{"%", "", false},
+		{"%4", "", false},
+		{"%zz", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := Unescape(nil, []byte(test.in))
+		if ok != test.ok || ok && string(got) != test.want {
+			t.Fatalf("Unescape(%q) = %q, %v, want %q, %v", test.in, got, ok, test.want, test.ok)
+		}
+
+		if want, err := url.QueryUnescape(test.in); (err == nil) != ok || ok && want != string(got) {
+			t.Fatalf("Unescape(%q) disagrees with url.QueryUnescape: %q, %v", test.in, want, err)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+// ErrUnsupportedScheme is returned when announcing
+// to a tracker that is neither HTTP(S) nor UDP.
+var ErrUnsupportedScheme = errors.New("tracker: unsupported announce URL scheme")
+
+// peerWindow is how long a peer given by one
+// tracker is left out of other trackers' batches.
+const peerWindow = 10 * time.Minute
+
+// SchemeAnnouncer announces to HTTP(S) and UDP
+// trackers alike, choosing the client by the
+// announce URL's scheme. The zero value is
+// ready to use.
+type SchemeAnnouncer struct {
+	HTTP Client
+	UDP  UDPClient
+}
+
+// Announce implements Announcer for SchemeAnnouncer.
+func (a *SchemeAnnouncer) Announce(ctx context.Context, announce []byte, req *AnnounceRequest) (*AnnounceResponse, error) {
+	switch {
+	case bytes.HasPrefix(announce, []byte("http://")), bytes.HasPrefix(announce, []byte("https://")):
+		return a.HTTP.Announce(ctx, announce, req)
+	case bytes.HasPrefix(announce, []byte("udp://")):
+		return a.UDP.Announce(ctx, announce, req)
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+}
+
+// MultiTracker announces one torrent to the tiers of
+// trackers of its announce-list (BEP 12).
+//
+// Trackers are shuffled within their tier, then tried in
+// order until one responds, which is moved to the front of
+// its tier. Later tiers are only tried when every tracker
+// in the tiers before has failed, unless AllTiers is set,
+// when each tier is announced to independently. Each group
+// of tiers so tried together is scheduled by a Scheduler.
+//
+// Each tracker is sent started on its first announce and
+// stopped when the MultiTracker is done, if it was started.
+type MultiTracker struct {
+	// Sends the announces. If nil,
+	// a SchemeAnnouncer is used.
+	Announcer Announcer
+	// The tiers of tracker URLs, such as
+	// from metainfo's Trackers.
+	Tiers [][][]byte
+	// Whether to announce to every
+	// tier, rather than the first that
+	// has a tracker that responds.
+	AllTiers bool
+	// The tracker IDs to send each tracker, by URL, until
+	// it gives another, such as those of an earlier run.
+	TrackerIds map[string][]byte
+	// As for Scheduler.
+	Request AnnounceRequest
+	Stats   Stats
+	Clock   clock.Clock
+	Rand    *rand.Rand
+
+	DefaultInterval        time.Duration
+	MinBackoff, MaxBackoff time.Duration
+
+	once      sync.Once
+	announcer Announcer
+	clk       clock.Clock
+	groups    []*trackerGroup
+	peers     chan []Peer
+	completed chan struct{}
+	complete  sync.Once
+
+	mu sync.Mutex
+	// In the order given by Tiers.
+	trackers []*trackerState
+	// When each peer was last handed out.
+	seen map[netip.AddrPort]time.Time
+}
+
+// TrackerStatus is how announces to
+// one tracker have gone so far.
+type TrackerStatus struct {
+	URL []byte
+	// The tracker's index in Tiers.
+	Tier int
+	// The tracker ID it gave, sent back to it.
+	TrackerId []byte
+	SchedulerStatus
+}
+
+// Working reports whether the last announce
+// to the tracker succeeded.
+func (st *TrackerStatus) Working() bool {
+	return !st.LastAnnounce.IsZero() && st.LastError == nil
+}
+
+type trackerState struct {
+	url  []byte
+	tier int
+	// The group the tracker is announced with.
+	group *trackerGroup
+	// Whether the tracker has been
+	// sent started and completed.
+	started, completed bool
+	trackerId          []byte
+	status             SchedulerStatus
+}
+
+// trackerGroup is the tiers of trackers one Scheduler
+// announces to, as the Announcer it is given.
+type trackerGroup struct {
+	m     *MultiTracker
+	tiers [][]*trackerState
+	sched Scheduler
+}
+
+func (m *MultiTracker) init() {
+	m.once.Do(func() {
+		m.announcer = m.Announcer
+		if m.announcer == nil {
+			m.announcer = &SchemeAnnouncer{}
+		}
+
+		m.clk = m.Clock
+		if m.clk == nil {
+			m.clk = clock.System()
+		}
+
+		m.peers = make(chan []Peer)
+		m.completed = make(chan struct{})
+
+		for i := 0; i < len(m.Tiers); i++ {
+			if m.AllTiers || len(m.groups) == 0 {
+				g := &trackerGroup{m: m}
+				g.sched = Scheduler{
+					Announcer:       g,
+					Request:         m.Request,
+					Stats:           m.Stats,
+					Clock:           m.clk,
+					Rand:            m.Rand,
+					DefaultInterval: m.DefaultInterval,
+					MinBackoff:      m.MinBackoff,
+					MaxBackoff:      m.MaxBackoff,
+				}
+
+				m.groups = append(m.groups, g)
+			}
+
+			g := m.groups[len(m.groups)-1]
+
+			tier := make([]*trackerState, len(m.Tiers[i]))
+			for j := 0; j < len(tier); j++ {
+				url := m.Tiers[i][j]
+				tier[j] = &trackerState{url: url, tier: i, group: g, trackerId: m.TrackerIds[string(url)]}
+				m.trackers = append(m.trackers, tier[j])
+			}
+
+			m.shuffle(tier)
+
+			g.tiers = append(g.tiers, tier)
+		}
+	})
+}
+
+// Peers delivers the peers from each successful announce,
+// leaving out those recently given by another. It must be
+// read from for the MultiTracker to make progress.
+func (m *MultiTracker) Peers() <-chan []Peer {
+	m.init()
+
+	return m.peers
+}
+
+// Completed tells the MultiTracker the download has finished,
+// so it sends a completed announce as soon as it may.
+func (m *MultiTracker) Completed() {
+	m.init()
+
+	m.complete.Do(func() {
+		close(m.completed)
+	})
+
+	for _, g := range m.groups {
+		g.sched.Completed()
+	}
+}
+
+// Status reports how announces to each tracker
+// have gone so far, in the order of Tiers.
+func (m *MultiTracker) Status() []TrackerStatus {
+	m.init()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := make([]TrackerStatus, len(m.trackers))
+	for i, t := range m.trackers {
+		st[i] = TrackerStatus{URL: t.url, Tier: t.tier, TrackerId: t.trackerId, SchedulerStatus: t.status}
+		st[i].NextAnnounce = t.group.sched.Status().NextAnnounce
+	}
+
+	return st
+}
+
+// Run announces until ctx is done, when it sends a stopped
+// announce to every tracker that was started. It returns
+// the errors of the stopped announces.
+func (m *MultiTracker) Run(ctx context.Context) error {
+	m.init()
+
+	errs := make([]error, len(m.groups))
+
+	var wg sync.WaitGroup
+	for i, g := range m.groups {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs[i] = m.run(ctx, g)
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// run runs the Scheduler of g until ctx is
+// done, handing on the peers it gives.
+func (m *MultiTracker) run(ctx context.Context, g *trackerGroup) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- g.sched.Run(ctx)
+	}()
+
+	for {
+		select {
+		case peers := <-g.sched.Peers():
+			if peers = m.dedupe(m.clk.Now(), peers); len(peers) != 0 {
+				select {
+				case m.peers <- peers:
+				case <-ctx.Done():
+				}
+			}
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+// Announce implements Announcer for trackerGroup. Stopped is
+// sent to every tracker of the group that was started, other
+// announces to each tracker in turn until one responds, which
+// is moved to the front of its tier.
+func (g *trackerGroup) Announce(ctx context.Context, _ []byte, req *AnnounceRequest) (*AnnounceResponse, error) {
+	if req.Event == EventStopped {
+		if err := g.stop(ctx, req); err != nil {
+			return nil, err
+		}
+
+		return &AnnounceResponse{}, nil
+	}
+
+	var err error
+
+	for _, tier := range g.tiers {
+		for i, t := range tier {
+			var resp *AnnounceResponse
+
+			resp, err = g.m.announce(ctx, t, req, g.m.event(t))
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			if err != nil {
+				continue
+			}
+
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = t
+
+			return resp, nil
+		}
+	}
+
+	return nil, err
+}
+
+// stop sends stopped to every tracker of g that was started.
+func (g *trackerGroup) stop(ctx context.Context, req *AnnounceRequest) error {
+	g.m.mu.Lock()
+
+	var started []*trackerState
+	for _, tier := range g.tiers {
+		for _, t := range tier {
+			if t.started {
+				started = append(started, t)
+			}
+		}
+	}
+
+	g.m.mu.Unlock()
+
+	errs := make([]error, len(started))
+
+	var wg sync.WaitGroup
+	for i, t := range started {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, errs[i] = g.m.announce(ctx, t, req, EventStopped)
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// event is what to tell t on its next announce
+// other than stopped, whatever the Scheduler would
+// tell the tracker that responded last.
+func (m *MultiTracker) event(t *trackerState) AnnounceEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case !t.started:
+		return EventStarted
+	case !t.completed && m.isCompleted():
+		return EventCompleted
+	default:
+		return EventNone
+	}
+}
+
+func (m *MultiTracker) isCompleted() bool {
+	select {
+	case <-m.completed:
+		return true
+	default:
+		return false
+	}
+}
+
+// announce sends req to t with the given event
+// and t's tracker ID, recording how it went.
+func (m *MultiTracker) announce(ctx context.Context, t *trackerState, req *AnnounceRequest, event AnnounceEvent) (*AnnounceResponse, error) {
+	r := *req
+	r.Event = event
+
+	m.mu.Lock()
+	r.TrackerId = t.trackerId
+	// Whether this announce tells the tracker we've
+	// completed, a started one does so by Left.
+	completed := m.isCompleted()
+	m.mu.Unlock()
+
+	now := m.clk.Now()
+
+	resp, err := m.announcer.Announce(ctx, t.url, &r)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.status.record(now, resp, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch event {
+	case EventStarted:
+		t.started = true
+		t.completed = completed
+	case EventCompleted:
+		t.completed = true
+	}
+
+	if len(resp.TrackerId) != 0 {
+		t.trackerId = resp.TrackerId
+	}
+
+	return resp, nil
+}
+
+// dedupe leaves out of peers those that are repeated,
+// or were handed out within the last peerWindow.
+func (m *MultiTracker) dedupe(now time.Time, peers []Peer) []Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.seen == nil {
+		m.seen = make(map[netip.AddrPort]time.Time)
+	}
+
+	for addr, at := range m.seen {
+		if now.Sub(at) >= peerWindow {
+			delete(m.seen, addr)
+		}
+	}
+
+	var fresh []Peer
+	for _, p := range peers {
+		if _, ok := m.seen[p.Addr]; ok {
+			continue
+		}
+
+		m.seen[p.Addr] = now
+		fresh = append(fresh, p)
+	}
+
+	return fresh
+}
+
+func (m *MultiTracker) shuffle(tier []*trackerState) {
+	swap := func(i, j int) {
+		tier[i], tier[j] = tier[j], tier[i]
+	}
+
+	if m.Rand != nil {
+		m.Rand.Shuffle(len(tier), swap)
+	} else {
+		rand.Shuffle(len(tier), swap)
+	}
+}
@@ -0,0 +1,374 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+// waitMultiNext waits for every tracker of m to be scheduled
+// for next, and for n timers to be made.
+func waitMultiNext(t *testing.T, m *MultiTracker, clk *clock.Fake, next time.Time, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		ok := true
+		for _, st := range m.Status() {
+			ok = ok && st.NextAnnounce.Equal(next)
+		}
+
+		if ok {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %+v, want next announce %v", m.Status(), next)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	clk.BlockUntil(n)
+}
+
+func tiers(urls ...[]string) [][][]byte {
+	var ts [][][]byte
+	for _, tier := range urls {
+		var t [][]byte
+		for _, u := range tier {
+			t = append(t, []byte(u))
+		}
+
+		ts = append(ts, t)
+	}
+
+	return ts
+}
+
+func TestMultiTrackerPromote(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	m := &MultiTracker{
+		Announcer: a,
+		Tiers:     tiers([]string{"http://a", "http://b"}, []string{"http://c"}),
+		Clock:     clk,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	p1 := Peer{Addr: netip.MustParseAddrPort("10.0.0.1:1")}
+	p2 := Peer{Addr: netip.MustParseAddrPort("10.0.0.2:2")}
+	p3 := Peer{Addr: netip.MustParseAddrPort("10.0.0.3:3")}
+
+	// The tier is shuffled, so either may be first.
+	first := a.next(t)
+	if first.url != "http://a" && first.url != "http://b" || first.req.Event != EventStarted {
+		t.Fatalf("first announce = %v to %s", first.req.Event, first.url)
+	}
+
+	a.replies <- announceReply{err: errors.New("down")}
+
+	second := a.next(t)
+	if second.url == first.url || second.url == "http://c" || second.req.Event != EventStarted {
+		t.Fatalf("second announce = %v to %s", second.req.Event, second.url)
+	}
+
+	a.replies <- announceReply{resp: &AnnounceResponse{
+		Interval: 5 * time.Minute,
+		Complete: 7,
+		Peers:    []Peer{p1, p2, p1},
+	}}
+
+	if peers := <-m.Peers(); len(peers) != 2 || peers[0] != p1 || peers[1] != p2 {
+		t.Fatalf("peers = %v, want %v", peers, []Peer{p1, p2})
+	}
+
+	waitMultiNext(t, m, clk, t0.Add(5*time.Minute), 1)
+
+	for _, st := range m.Status() {
+		switch url := string(st.URL); {
+		case url == first.url:
+			if st.Working() || st.Failures != 1 || st.Tier != 0 {
+				t.Fatalf("failed tracker status = %+v", st)
+			}
+		case url == second.url:
+			if !st.Working() || st.Complete != 7 || st.Tier != 0 {
+				t.Fatalf("working tracker status = %+v", st)
+			}
+		default:
+			if !st.LastAnnounce.IsZero() || st.Tier != 1 {
+				t.Fatalf("unused tracker status = %+v", st)
+			}
+		}
+	}
+
+	// The tracker that responded is now tried first,
+	// and peers already given are left out.
+	clk.Advance(5 * time.Minute)
+
+	if c := a.next(t); c.url != second.url || c.req.Event != EventNone {
+		t.Fatalf("announce = %v to %s, want none to %s", c.req.Event, c.url, second.url)
+	}
+
+	a.replies <- announceReply{resp: &AnnounceResponse{
+		Interval: 5 * time.Minute,
+		Peers:    []Peer{p2, p3},
+	}}
+
+	if peers := <-m.Peers(); len(peers) != 1 || peers[0] != p3 {
+		t.Fatalf("peers = %v, want %v", peers, []Peer{p3})
+	}
+
+	waitMultiNext(t, m, clk, t0.Add(10*time.Minute), 1)
+	cancel()
+
+	// Only the started tracker is stopped.
+	if c := a.next(t); c.url != second.url || c.req.Event != EventStopped {
+		t.Fatalf("announce = %v to %s, want stopped to %s", c.req.Event, c.url, second.url)
+	}
+
+	a.replies <- announceReply{resp: &AnnounceResponse{}}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+}
+
+func TestMultiTrackerFallback(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	m := &MultiTracker{
+		Announcer:  a,
+		Tiers:      tiers([]string{"http://a"}, []string{"http://b"}),
+		Clock:      clk,
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: 10 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	failed := errors.New("down")
+
+	// Every tier fails, so the round is retried after a backoff.
+	for _, url := range []string{"http://a", "http://b"} {
+		if c := a.next(t); c.url != url {
+			t.Fatalf("announce to %s, want %s", c.url, url)
+		}
+
+		a.replies <- announceReply{err: failed}
+	}
+
+	var next time.Time
+
+	deadline := time.Now().Add(5 * time.Second)
+	for next = m.Status()[0].NextAnnounce; !next.After(t0); next = m.Status()[0].NextAnnounce {
+		if time.Now().After(deadline) {
+			t.Fatalf("no retry scheduled")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if wait := next.Sub(t0); wait < 5*time.Second || wait > 10*time.Second {
+		t.Fatalf("backoff = %v, want within [5s, 10s]", wait)
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(next.Sub(t0))
+
+	// The second tier is used when the first still fails.
+	if c := a.next(t); c.url != "http://a" {
+		t.Fatalf("announce to %s, want http://a", c.url)
+	}
+
+	a.replies <- announceReply{err: failed}
+
+	if c := a.next(t); c.url != "http://b" || c.req.Event != EventStarted {
+		t.Fatalf("announce = %v to %s, want started to http://b", c.req.Event, c.url)
+	}
+
+	a.replies <- announceReply{resp: &AnnounceResponse{Interval: time.Hour}}
+
+	waitMultiNext(t, m, clk, next.Add(time.Hour), 1)
+	cancel()
+
+	if c := a.next(t); c.url != "http://b" || c.req.Event != EventStopped {
+		t.Fatalf("announce = %v to %s, want stopped to http://b", c.req.Event, c.url)
+	}
+
+	a.replies <- announceReply{err: failed}
+
+	if err := <-done; !errors.Is(err, failed) {
+		t.Fatalf("Run = %v, want %v", err, failed)
+	}
+}
+
+func TestMultiTrackerAllTiers(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	m := &MultiTracker{
+		Announcer: a,
+		Tiers:     tiers([]string{"http://a"}, []string{"http://b"}),
+		AllTiers:  true,
+		Clock:     clk,
+	}
+
+	m.Completed()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	// Both tiers are announced to, in either order.
+	var urls []string
+	for i := 0; i < 2; i++ {
+		c := a.next(t)
+		if c.req.Event != EventStarted {
+			t.Fatalf("announce = %v to %s, want started", c.req.Event, c.url)
+		}
+
+		urls = append(urls, c.url)
+		a.replies <- announceReply{resp: &AnnounceResponse{Interval: time.Minute}}
+	}
+
+	sort.Strings(urls)
+	if urls[0] != "http://a" || urls[1] != "http://b" {
+		t.Fatalf("announced to %v", urls)
+	}
+
+	waitMultiNext(t, m, clk, t0.Add(time.Minute), 2)
+
+	// Having started after completing, there
+	// is no need to send completed.
+	clk.Advance(time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if c := a.next(t); c.req.Event != EventNone {
+			t.Fatalf("announce = %v to %s, want none", c.req.Event, c.url)
+		}
+
+		a.replies <- announceReply{resp: &AnnounceResponse{Interval: time.Minute}}
+	}
+
+	waitMultiNext(t, m, clk, t0.Add(2*time.Minute), 2)
+	cancel()
+
+	for i := 0; i < 2; i++ {
+		if c := a.next(t); c.req.Event != EventStopped {
+			t.Fatalf("announce = %v to %s, want stopped", c.req.Event, c.url)
+		}
+
+		a.replies <- announceReply{resp: &AnnounceResponse{}}
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+}
+
+func TestMultiTrackerTrackerIds(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	a := newFakeAnnouncer(clk)
+
+	m := &MultiTracker{
+		Announcer:  a,
+		Tiers:      tiers([]string{"http://a"}),
+		TrackerIds: map[string][]byte{"http://a": []byte("old")},
+		Clock:      clk,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	if c := a.next(t); string(c.req.TrackerId) != "old" {
+		t.Fatalf("first tracker id = %q, want old", c.req.TrackerId)
+	}
+
+	a.replies <- announceReply{resp: &AnnounceResponse{Interval: time.Hour, TrackerId: []byte("new")}}
+
+	waitMultiNext(t, m, clk, clk.Now().Add(time.Hour), 1)
+
+	if st := m.Status(); string(st[0].TrackerId) != "new" {
+		t.Fatalf("status tracker id = %q, want new", st[0].TrackerId)
+	}
+
+	cancel()
+
+	if c := a.next(t); c.req.Event != EventStopped || string(c.req.TrackerId) != "new" {
+		t.Fatalf("announce = %v with tracker id %q, want stopped with new", c.req.Event, c.req.TrackerId)
+	}
+
+	a.replies <- announceReply{resp: &AnnounceResponse{}}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+}
+
+func TestMultiTrackerStopTimeout(t *testing.T) {
+	d := make(deadlines, 4)
+	m := &MultiTracker{Announcer: d, Tiers: tiers([]string{"http://a"}, []string{"http://b"}), AllTiers: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	go func() {
+		for range m.Peers() {
+		}
+	}()
+
+	<-d
+	<-d
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+
+	if !<-d || !<-d {
+		t.Fatal("stopped announce has no deadline")
+	}
+}
+
+func TestSchemeAnnouncerUnsupported(t *testing.T) {
+	var a SchemeAnnouncer
+
+	_, err := a.Announce(context.Background(), []byte("wss://tracker.example"), &AnnounceRequest{})
+	if err != ErrUnsupportedScheme {
+		t.Fatalf("Announce = %v, want %v", err, ErrUnsupportedScheme)
+	}
+}
@@ -0,0 +1,112 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+const (
+	// Length of an IPv4 peer in a compact
+	// peer list, address then port.
+	compactPeerLength = 4 + 2
+	// Length of an IPv6 peer in a compact
+	// peer list, address then port.
+	compactPeer6Length = 16 + 2
+)
+
+// Peer is a peer returned by the tracker.
+type Peer struct {
+	Addr netip.AddrPort
+	// The peer's ID, all zero if
+	// the tracker didn't send it.
+	PeerId [20]byte
+}
+
+// DecodeCompactPeers appends the peers of the compact
+// IPv4 peer string p (BEP 23) to dst. It reports false
+// if p is not a whole number of peers.
+func DecodeCompactPeers(dst []Peer, p []byte) ([]Peer, bool) {
+	if len(p)%compactPeerLength != 0 {
+		return dst, false
+	}
+
+	for i := 0; i < len(p); i += compactPeerLength {
+		ip := netip.AddrFrom4([4]byte(p[i : i+4]))
+		port := binary.BigEndian.Uint16(p[i+4:])
+
+		dst = append(dst, Peer{Addr: netip.AddrPortFrom(ip, port)})
+	}
+
+	return dst, true
+}
+
+// DecodeCompactPeers6 appends the peers of the compact
+// IPv6 peer string p (BEP 7) to dst. It reports false
+// if p is not a whole number of peers.
+func DecodeCompactPeers6(dst []Peer, p []byte) ([]Peer, bool) {
+	if len(p)%compactPeer6Length != 0 {
+		return dst, false
+	}
+
+	for i := 0; i < len(p); i += compactPeer6Length {
+		ip := netip.AddrFrom16([16]byte(p[i : i+16]))
+		port := binary.BigEndian.Uint16(p[i+16:])
+
+		dst = append(dst, Peer{Addr: netip.AddrPortFrom(ip, port)})
+	}
+
+	return dst, true
+}
+
+// DecodePeerList appends the peers of the dictionary
+// model peer list p, which is bencoded, to dst.
+//
+// Peers given by hostname rather than IP address
+// are skipped.
+func DecodePeerList(dst []Peer, p []byte) ([]Peer, parse.Error) {
+	it := parse.NewListIter(p)
+	for it.Next() {
+		var peer Peer
+		var ip netip.Addr
+		var port int64 = -1
+
+		entry := parse.NewDictIter(it.Value())
+		for entry.Next() {
+			switch string(entry.Key()) {
+			case "ip":
+				s, _ := parse.Bytes(entry.Value())
+				ip, _ = netip.ParseAddr(string(s))
+			case "port":
+				port, _ = parse.Integer(entry.Value())
+			case "peer id":
+				id, _ := parse.Bytes(entry.Value())
+				copy(peer.PeerId[:], id)
+			}
+		}
+
+		if err := entry.Err(); err.IsError() {
+			return dst, err
+		}
+
+		if !ip.IsValid() || port < 0 || port > 0xFFFF {
+			continue
+		}
+
+		peer.Addr = netip.AddrPortFrom(ip.Unmap(), uint16(port))
+		dst = append(dst, peer)
+	}
+
+	return dst, it.Err()
+}
+
+// AppendCompactPeer appends the compact form of
+// addr to dst, 6 bytes for IPv4 or 18 for IPv6.
+func AppendCompactPeer(dst []byte, addr netip.AddrPort) []byte {
+	ip := addr.Addr().Unmap()
+
+	dst = append(dst, ip.AsSlice()...)
+
+	return binary.BigEndian.AppendUint16(dst, addr.Port())
+}
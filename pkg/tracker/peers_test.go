@@ -0,0 +1,83 @@
+package tracker
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestDecodeCompactPeers(t *testing.T) {
+	p := []byte{127, 0, 0, 1, 0x1A, 0xE1, 10, 1, 2, 3, 0, 80}
+
+	got, ok := DecodeCompactPeers(nil, p)
+	if !ok {
+		t.Fatalf("not ok")
+	}
+
+	want := []string{"127.0.0.1:6881", "10.1.2.3:80"}
+
+	if len(got) != len(want) {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+
+	for i := range want {
+		if got[i].Addr.String() != want[i] {
+			t.Fatalf("peer %d: got: %s, want: %s", i, got[i].Addr, want[i])
+		}
+	}
+
+	if _, ok := DecodeCompactPeers(nil, p[:7]); ok {
+		t.Fatalf("ragged input accepted")
+	}
+}
+
+func TestDecodeCompactPeers6(t *testing.T) {
+	want := []netip.AddrPort{
+		netip.MustParseAddrPort("[2001:db8::1]:6881"),
+		netip.MustParseAddrPort("[::1]:1"),
+	}
+
+	var p []byte
+	for _, a := range want {
+		p = AppendCompactPeer(p, a)
+	}
+
+	if len(p) != 2*compactPeer6Length {
+		t.Fatalf("got length: %d", len(p))
+	}
+
+	got, ok := DecodeCompactPeers6(nil, p)
+	if !ok || len(got) != len(want) {
+		t.Fatalf("got: %v, %v", got, ok)
+	}
+
+	for i := range want {
+		if got[i].Addr != want[i] {
+			t.Fatalf("peer %d: got: %s, want: %s", i, got[i].Addr, want[i])
+		}
+	}
+
+	if _, ok := DecodeCompactPeers6(nil, p[:17]); ok {
+		t.Fatalf("ragged input accepted")
+	}
+}
+
+func TestDecodeAnnounceResponseCompact(t *testing.T) {
+	body := "d8:intervali60e5:peers6:\x7f\x00\x00\x01\x1a\xe1" +
+		"6:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x50e"
+
+	var resp AnnounceResponse
+
+	if err := DecodeAnnounceResponse(&resp, []byte(body)); err.IsError() {
+		t.Fatalf("error: %s", err)
+	}
+
+	if len(resp.Peers) != 2 ||
+		resp.Peers[0].Addr.String() != "127.0.0.1:6881" ||
+		resp.Peers[1].Addr.String() != "[2001:db8::1]:80" {
+		t.Fatalf("got: %v", resp.Peers)
+	}
+
+	if err := DecodeAnnounceResponse(&resp, []byte("d5:peers5:abcdee")); !err.IsError() {
+		t.Fatalf("ragged compact peers accepted")
+	}
+}
@@ -0,0 +1,218 @@
+package tracker
+
+import (
+	"bytes"
+	"net/netip"
+	"strconv"
+)
+
+// QueryError is returned when an announce or scrape
+// query has a missing or malformed parameter.
+type QueryError struct {
+	Key string
+}
+
+// Error implements the error interface for QueryError.
+func (e *QueryError) Error() string {
+	return "tracker: missing or malformed " + e.Key + " in query"
+}
+
+// ParseAnnounceQuery parses the query string of an announce
+// request, the inverse of Build, into req. The info hash,
+// peer id and port are required, unknown parameters are
+// ignored, as are the hints clients send in many forms,
+// the key and IP addresses, when they don't parse. The
+// query must not include the leading '?'.
+func ParseAnnounceQuery(req *AnnounceRequest, query []byte) error {
+	var hasInfoHash, hasPeerId, hasPort bool
+
+	for len(query) != 0 {
+		var k, v []byte
+		k, v, query = nextParam(query)
+
+		var ok bool
+
+		switch string(k) {
+		case "info_hash":
+			ok = Unescape20(&req.InfoHash, v)
+			hasInfoHash = ok
+		case "peer_id":
+			ok = Unescape20(&req.PeerId, v)
+			hasPeerId = ok
+		case "port":
+			req.Port, ok = parseUint(v)
+			ok = ok && req.Port <= 0xFFFF
+			hasPort = ok
+		case "uploaded":
+			req.Uploaded, ok = parseUint(v)
+		case "downloaded":
+			req.Downloaded, ok = parseUint(v)
+		case "left":
+			req.Left, ok = parseUint(v)
+		case "numwant":
+			req.NumWant, ok = parseUint(v)
+		case "event":
+			req.Event, ok = parseEvent(v)
+		case "compact":
+			req.Compact, ok = parseFlag(v)
+		case "no_peer_id":
+			req.NoPeerId, ok = parseFlag(v)
+		case "supportcrypto":
+			req.SupportCrypto, ok = parseFlag(v)
+		case "requirecrypto":
+			req.RequireCrypto, ok = parseFlag(v)
+		case "key":
+			// Hints, kept only when they parse.
+			if key, ok := parseHex32(v); ok {
+				req.Key = uint32(key)
+			}
+
+			ok = true
+		case "trackerid":
+			req.TrackerId, ok = Unescape(nil, v)
+		case "ip":
+			// Possibly a DNS name, which isn't kept.
+			if ip, ok := parseIP(v); ok {
+				req.IP = ip
+			}
+
+			ok = true
+		case "ipv4":
+			if ip, ok := parseIP(v); ok && ip.Is4() {
+				req.IPv4 = ip
+			}
+
+			ok = true
+		case "ipv6":
+			if ip, ok := parseIP(v); ok && ip.Is6() {
+				req.IPv6 = ip
+			}
+
+			ok = true
+		case "corrupt":
+			req.Corrupt, ok = parseUint(v)
+		case "redundant":
+			req.Redundant, ok = parseUint(v)
+		default:
+			ok = true
+		}
+
+		if !ok {
+			return &QueryError{Key: string(k)}
+		}
+	}
+
+	switch {
+	case !hasInfoHash:
+		return &QueryError{Key: "info_hash"}
+	case !hasPeerId:
+		return &QueryError{Key: "peer_id"}
+	case !hasPort:
+		return &QueryError{Key: "port"}
+	}
+
+	return nil
+}
+
+// ParseScrapeQuery appends the info hashes of the query
+// string of a scrape request, the inverse of BuildScrape,
+// to dst. Other parameters are ignored.
+func ParseScrapeQuery(dst [][20]byte, query []byte) ([][20]byte, error) {
+	for len(query) != 0 {
+		var k, v []byte
+		k, v, query = nextParam(query)
+
+		if string(k) != "info_hash" {
+			continue
+		}
+
+		var h [20]byte
+		if !Unescape20(&h, v) {
+			return dst, &QueryError{Key: "info_hash"}
+		}
+
+		dst = append(dst, h)
+	}
+
+	return dst, nil
+}
+
+// nextParam splits the first key and
+// value off the query string query.
+func nextParam(query []byte) (k, v, rest []byte) {
+	param := query
+	if i := bytes.IndexByte(query, '&'); i >= 0 {
+		param, rest = query[:i], query[i+1:]
+	}
+
+	k = param
+	if i := bytes.IndexByte(param, '='); i >= 0 {
+		k, v = param[:i], param[i+1:]
+	}
+
+	return k, v, rest
+}
+
+func parseUint(v []byte) (uint64, bool) {
+	n, err := strconv.ParseUint(string(v), 10, 64)
+
+	return n, err == nil
+}
+
+// parseHex32 parses a key, which clients send
+// as up to 8 hex digits.
+func parseHex32(v []byte) (uint64, bool) {
+	n, err := strconv.ParseUint(string(v), 16, 32)
+
+	return n, err == nil
+}
+
+func parseFlag(v []byte) (bool, bool) {
+	switch string(v) {
+	case "0":
+		return false, true
+	case "1":
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+func parseEvent(v []byte) (AnnounceEvent, bool) {
+	switch string(v) {
+	case "", "empty":
+		return EventNone, true
+	case "started":
+		return EventStarted, true
+	case "completed":
+		return EventCompleted, true
+	case "stopped":
+		return EventStopped, true
+	case "paused":
+		return EventPaused, true
+	default:
+		return EventNone, false
+	}
+}
+
+// parseIP parses an IP address, or the address of
+// an endpoint, "1.2.3.4:6881" or "[::1]:6881" (BEP 7).
+func parseIP(v []byte) (netip.Addr, bool) {
+	var buf [64]byte
+
+	s, ok := Unescape(buf[:0], v)
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	if ip, err := netip.ParseAddr(string(s)); err == nil {
+		return ip, true
+	}
+
+	addr, err := netip.ParseAddrPort(string(s))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Addr(), true
+}
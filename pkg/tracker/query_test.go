@@ -0,0 +1,119 @@
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseAnnounceQuery(t *testing.T) {
+	want := AnnounceRequest{
+		InfoHash:      [20]byte{0xde, 0xad, 0xbe, 0xef, ' ', '&', '=', '?', '#', '+', '%'},
+		PeerId:        [20]byte([]byte("-BP0100-abcdefghijkl")),
+		Port:          6881,
+		Uploaded:      1,
+		Downloaded:    2,
+		Left:          3,
+		NumWant:       50,
+		Event:         EventPaused,
+		Compact:       true,
+		NoPeerId:      true,
+		Key:           0x1A2B3C,
+		TrackerId:     []byte("id &=?/\x00"),
+		IP:            netip.MustParseAddr("192.168.0.1"),
+		IPv4:          netip.MustParseAddr("10.0.0.1"),
+		IPv6:          netip.MustParseAddr("2001:db8::1"),
+		SupportCrypto: true,
+		RequireCrypto: true,
+		Corrupt:       16384,
+		Redundant:     32768,
+	}
+
+	var b URLBuffer
+
+	u := Build(&b, []byte("http://t.example/announce?passkey=x"), &want)
+	query := u[bytes.IndexByte(u, '?')+1:]
+
+	var got AnnounceRequest
+	if err := ParseAnnounceQuery(&got, query); err != nil {
+		t.Fatalf("ParseAnnounceQuery(%s): %v", query, err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:  %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestParseAnnounceQueryErrors(t *testing.T) {
+	const (
+		hash = "info_hash=aaaaaaaaaaaaaaaaaaaa"
+		id   = "&peer_id=bbbbbbbbbbbbbbbbbbbb"
+		port = "&port=6881"
+	)
+
+	tests := []struct {
+		query string
+		key   string
+	}{
+		{id + port, "info_hash"},
+		{hash + port, "peer_id"},
+		{hash + id, "port"},
+		{"info_hash=short" + id + port, "info_hash"},
+		{hash + id + "&port=65536", "port"},
+		{hash + id + port + "&left=-1", "left"},
+		{hash + id + port + "&event=bored", "event"},
+		{hash + id + port + "&compact=yes", "compact"},
+		{hash + id + port + "&trackerid=%zz", "trackerid"},
+	}
+
+	for _, test := range tests {
+		var req AnnounceRequest
+
+		var qerr *QueryError
+		if err := ParseAnnounceQuery(&req, []byte(test.query)); !errors.As(err, &qerr) || qerr.Key != test.key {
+			t.Fatalf("ParseAnnounceQuery(%s) = %v, want bad %s", test.query, err, test.key)
+		}
+	}
+
+	// Unknown parameters are fine.
+	var req AnnounceRequest
+	if err := ParseAnnounceQuery(&req, []byte(hash+id+port+"&passkey=abc&flag")); err != nil {
+		t.Fatalf("ParseAnnounceQuery: %v", err)
+	}
+
+	// As are hints that don't parse, which are left out.
+	req = AnnounceRequest{}
+	if err := ParseAnnounceQuery(&req, []byte(hash+id+port+"&key=not-hex&ip=peer.example&ipv4=::1&ipv6=1.2.3.4")); err != nil ||
+		req.Key != 0 || req.IP.IsValid() || req.IPv4.IsValid() || req.IPv6.IsValid() {
+		t.Fatalf("ParseAnnounceQuery with bad hints = %+v, %v", req, err)
+	}
+
+	// Addresses may be given as endpoints (BEP 7).
+	req = AnnounceRequest{}
+	if err := ParseAnnounceQuery(&req, []byte(hash+id+port+"&ipv4=10.0.0.1:6881&ipv6=%5B2001:db8::1%5D:6881")); err != nil ||
+		req.IPv4 != netip.MustParseAddr("10.0.0.1") || req.IPv6 != netip.MustParseAddr("2001:db8::1") {
+		t.Fatalf("ParseAnnounceQuery with endpoints = %+v, %v", req, err)
+	}
+}
+
+func TestParseScrapeQuery(t *testing.T) {
+	hashes := [][20]byte{{1, 2, 3}, {'&', '%', ' '}}
+
+	var b URLBuffer
+
+	u, n := BuildScrape(&b, []byte("http://t.example/scrape?passkey=x"), hashes)
+	if n != 2 {
+		t.Fatalf("BuildScrape fit %d", n)
+	}
+
+	got, err := ParseScrapeQuery(nil, u[bytes.IndexByte(u, '?')+1:])
+	if err != nil || len(got) != 2 || got[0] != hashes[0] || got[1] != hashes[1] {
+		t.Fatalf("ParseScrapeQuery = %x, %v, want %x", got, err, hashes)
+	}
+
+	if _, err := ParseScrapeQuery(nil, []byte("info_hash=abc")); err == nil {
+		t.Fatal("parsed a short info hash")
+	}
+}
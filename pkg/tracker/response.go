@@ -0,0 +1,208 @@
+package tracker
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+// AnnounceResponse is the tracker's
+// reply to an announce request.
+type AnnounceResponse struct {
+	// If not empty, the announce failed
+	// and no other fields are set.
+	FailureReason []byte
+	// A warning that should be shown, the
+	// announce is otherwise successful.
+	WarningMessage []byte
+	// How long to wait before announcing again.
+	Interval time.Duration
+	// If non-zero, we must not announce
+	// again more often than this.
+	MinInterval time.Duration
+	// Should be echoed back in later announces.
+	TrackerId []byte
+	// The number of seeders.
+	Complete uint64
+	// The number of leechers.
+	Incomplete uint64
+	// Peers to connect to.
+	Peers []Peer
+}
+
+// DecodeAnnounceResponse parses the bencoded body of
+// a tracker's response into resp. Peers, from both
+// "peers" and "peers6", are appended to resp.Peers.
+//
+// Peers given by hostname rather than IP address
+// are skipped.
+func DecodeAnnounceResponse(resp *AnnounceResponse, p []byte) parse.Error {
+	it := parse.NewDictIter(p)
+	for it.Next() {
+		v := it.Value()
+
+		switch string(it.Key()) {
+		case "failure reason":
+			resp.FailureReason, _ = parse.Bytes(v)
+		case "warning message":
+			resp.WarningMessage, _ = parse.Bytes(v)
+		case "interval":
+			resp.Interval = seconds(v)
+		case "min interval":
+			resp.MinInterval = seconds(v)
+		case "tracker id":
+			resp.TrackerId, _ = parse.Bytes(v)
+		case "complete":
+			n, _ := parse.Integer(v)
+			resp.Complete = nonNegative(n)
+		case "incomplete":
+			n, _ := parse.Integer(v)
+			resp.Incomplete = nonNegative(n)
+		case "peers":
+			var err parse.Error
+
+			// Either a compact string, or the
+			// dictionary model list.
+			if s, ok := parse.Bytes(v); ok {
+				resp.Peers, ok = DecodeCompactPeers(resp.Peers, s)
+				if !ok {
+					return parse.MakeError(parse.ErrUnexpectedEndOfTerm, uint32(it.Offset()), parse.String)
+				}
+
+				break
+			}
+
+			resp.Peers, err = DecodePeerList(resp.Peers, v)
+			if err.IsError() {
+				return err
+			}
+		case "peers6":
+			s, ok := parse.Bytes(v)
+			if ok {
+				resp.Peers, ok = DecodeCompactPeers6(resp.Peers, s)
+			}
+
+			if !ok {
+				return parse.MakeError(parse.ErrUnexpectedEndOfTerm, uint32(it.Offset()), parse.String)
+			}
+		}
+	}
+
+	return it.Err()
+}
+
+// AppendBencode appends the bencoding of resp to dst, the
+// inverse of DecodeAnnounceResponse. If compact, peers are
+// written as compact strings, IPv4 in "peers" and IPv6 in
+// "peers6", otherwise as a dictionary model list, without
+// peer IDs if noPeerId. A response with a failure reason
+// has only that.
+func (resp *AnnounceResponse) AppendBencode(dst []byte, compact, noPeerId bool) []byte {
+	dst = append(dst, parse.OpenDict)
+
+	if len(resp.FailureReason) != 0 {
+		dst = encode.KeyString(dst, "failure reason", resp.FailureReason)
+
+		return append(dst, parse.EndTerm)
+	}
+
+	// Keys in sorted order.
+	dst = encode.KeyUint(dst, "complete", resp.Complete)
+	dst = encode.KeyUint(dst, "incomplete", resp.Incomplete)
+	dst = encode.KeyUint(dst, "interval", uint64(resp.Interval/time.Second))
+
+	if resp.MinInterval != 0 {
+		dst = encode.KeyUint(dst, "min interval", uint64(resp.MinInterval/time.Second))
+	}
+
+	if compact {
+		dst = appendCompactPeers(dst, "peers", resp.Peers, false)
+		dst = appendCompactPeers(dst, "peers6", resp.Peers, true)
+	} else {
+		dst = appendPeerList(dst, resp.Peers, noPeerId)
+	}
+
+	if len(resp.TrackerId) != 0 {
+		dst = encode.KeyString(dst, "tracker id", resp.TrackerId)
+	}
+
+	if len(resp.WarningMessage) != 0 {
+		dst = encode.KeyString(dst, "warning message", resp.WarningMessage)
+	}
+
+	return append(dst, parse.EndTerm)
+}
+
+// appendCompactPeers appends the entry key with the
+// compact string of the IPv4, or IPv6, peers to dst.
+// IPv6 peers are left out when there are none.
+func appendCompactPeers(dst []byte, key string, peers []Peer, v6 bool) []byte {
+	var n int
+	for i := 0; i < len(peers); i++ {
+		if peers[i].Addr.Addr().Unmap().Is6() == v6 {
+			n++
+		}
+	}
+
+	if v6 && n == 0 {
+		return dst
+	}
+
+	length := compactPeerLength
+	if v6 {
+		length = compactPeer6Length
+	}
+
+	dst = encode.String(dst, key)
+	dst = strconv.AppendInt(dst, int64(n*length), 10)
+	dst = append(dst, ':')
+
+	for i := 0; i < len(peers); i++ {
+		if peers[i].Addr.Addr().Unmap().Is6() == v6 {
+			dst = AppendCompactPeer(dst, peers[i].Addr)
+		}
+	}
+
+	return dst
+}
+
+// appendPeerList appends the peers entry as a
+// dictionary model list to dst.
+func appendPeerList(dst []byte, peers []Peer, noPeerId bool) []byte {
+	dst = encode.String(dst, "peers")
+	dst = append(dst, parse.OpenList)
+
+	var buf [64]byte
+
+	for i := 0; i < len(peers); i++ {
+		dst = append(dst, parse.OpenDict)
+		dst = encode.KeyString(dst, "ip", peers[i].Addr.Addr().Unmap().AppendTo(buf[:0]))
+
+		if !noPeerId {
+			dst = encode.KeyString(dst, "peer id", peers[i].PeerId[:])
+		}
+
+		dst = encode.KeyUint(dst, "port", uint64(peers[i].Addr.Port()))
+		dst = append(dst, parse.EndTerm)
+	}
+
+	return append(dst, parse.EndTerm)
+}
+
+// seconds decodes a bencoded integer
+// number of seconds.
+func seconds(p []byte) time.Duration {
+	n, _ := parse.Integer(p)
+
+	return time.Duration(nonNegative(n)) * time.Second
+}
+
+func nonNegative(n int64) uint64 {
+	if n < 0 {
+		return 0
+	}
+
+	return uint64(n)
+}
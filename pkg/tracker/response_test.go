@@ -0,0 +1,123 @@
+package tracker
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestDecodeAnnounceResponse(t *testing.T) {
+	const body = "d8:completei10e10:incompletei3e8:intervali1800e12:min intervali60e" +
+		"5:peersl" +
+		"d2:ip9:127.0.0.17:peer id20:-BP0100-abcdefghijkl4:porti6881ee" +
+		"d2:ip3:::14:porti51413ee" +
+		"d2:ip15:tracker.example4:porti1ee" +
+		"d2:ip8:10.0.0.14:porti99999ee" +
+		"e" +
+		"10:tracker id3:xyz15:warning message4:slowe"
+
+	var resp AnnounceResponse
+
+	if err := DecodeAnnounceResponse(&resp, []byte(body)); err.IsError() {
+		t.Fatalf("error: %s", err)
+	}
+
+	if resp.Complete != 10 || resp.Incomplete != 3 ||
+		resp.Interval != 30*time.Minute || resp.MinInterval != time.Minute {
+		t.Fatalf("got: %+v", resp)
+	}
+
+	if string(resp.TrackerId) != "xyz" || string(resp.WarningMessage) != "slow" {
+		t.Fatalf("got tracker id: %s, warning: %s", resp.TrackerId, resp.WarningMessage)
+	}
+
+	want := []Peer{
+		{Addr: netip.MustParseAddrPort("127.0.0.1:6881")},
+		{Addr: netip.MustParseAddrPort("[::1]:51413")},
+	}
+	copy(want[0].PeerId[:], "-BP0100-abcdefghijkl")
+
+	if len(resp.Peers) != len(want) {
+		t.Fatalf("got peers: %v, want: %v", resp.Peers, want)
+	}
+
+	for i := range want {
+		if resp.Peers[i] != want[i] {
+			t.Fatalf("peer %d: got: %v, want: %v", i, resp.Peers[i], want[i])
+		}
+	}
+}
+
+func TestDecodeAnnounceResponseFailure(t *testing.T) {
+	var resp AnnounceResponse
+
+	err := DecodeAnnounceResponse(&resp, []byte("d14:failure reason17:torrent not founde"))
+	if err.IsError() {
+		t.Fatalf("error: %s", err)
+	}
+
+	if string(resp.FailureReason) != "torrent not found" {
+		t.Fatalf("got failure reason: %s", resp.FailureReason)
+	}
+
+	for _, bad := range []string{"", "d8:intervali1800e", "<html>", "d5:peersld2:ip"} {
+		var resp AnnounceResponse
+
+		if err := DecodeAnnounceResponse(&resp, []byte(bad)); !err.IsError() {
+			t.Fatalf("%q: want error", bad)
+		}
+	}
+}
+
+func TestAppendBencode(t *testing.T) {
+	want := AnnounceResponse{
+		WarningMessage: []byte("slow"),
+		Interval:       30 * time.Minute,
+		MinInterval:    time.Minute,
+		TrackerId:      []byte("xyz"),
+		Complete:       10,
+		Incomplete:     3,
+		Peers: []Peer{
+			{Addr: netip.MustParseAddrPort("127.0.0.1:6881"), PeerId: [20]byte{1}},
+			{Addr: netip.MustParseAddrPort("[::1]:51413"), PeerId: [20]byte{2}},
+		},
+	}
+
+	for _, compact := range []bool{false, true} {
+		p := want.AppendBencode(nil, compact, false)
+
+		var got AnnounceResponse
+		if err := DecodeAnnounceResponse(&got, p); err.IsError() {
+			t.Fatalf("decode %s: %s", p, err)
+		}
+
+		if got.Complete != want.Complete || got.Incomplete != want.Incomplete ||
+			got.Interval != want.Interval || got.MinInterval != want.MinInterval ||
+			string(got.TrackerId) != "xyz" || string(got.WarningMessage) != "slow" ||
+			len(got.Peers) != 2 {
+			t.Fatalf("compact %v: got %+v from %s", compact, got, p)
+		}
+
+		for i, peer := range want.Peers {
+			// Compact peers have no IDs.
+			if compact {
+				peer.PeerId = [20]byte{}
+			}
+
+			if got.Peers[i] != peer {
+				t.Fatalf("compact %v: peer %d: got %v, want %v", compact, i, got.Peers[i], peer)
+			}
+		}
+	}
+
+	if got := string(want.AppendBencode(nil, false, true)); got != "d8:completei10e10:incompletei3e8:intervali1800e12:min intervali60e"+
+		"5:peersld2:ip9:127.0.0.14:porti6881eed2:ip3:::14:porti51413eee"+
+		"10:tracker id3:xyz15:warning message4:slowe" {
+		t.Fatalf("no peer id: got %s", got)
+	}
+
+	failed := AnnounceResponse{FailureReason: []byte("no"), Complete: 1}
+	if got := string(failed.AppendBencode(nil, true, false)); got != "d14:failure reason2:noe" {
+		t.Fatalf("failure: got %s", got)
+	}
+}
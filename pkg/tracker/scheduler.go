@@ -0,0 +1,360 @@
+package tracker
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+// Announcer sends announces to a tracker, it is
+// implemented by Client and UDPClient.
+type Announcer interface {
+	Announce(ctx context.Context, announce []byte, req *AnnounceRequest) (*AnnounceResponse, error)
+}
+
+// Stats reports a torrent's transfer totals,
+// to be sent with each announce.
+type Stats func() (uploaded, downloaded, left uint64)
+
+const (
+	// Used when the tracker doesn't give an interval.
+	defaultInterval = 30 * time.Minute
+	// Backoff after the first failed announce,
+	// doubling with each failure after.
+	defaultMinBackoff = 15 * time.Second
+	// Backoff doesn't grow beyond this.
+	defaultMaxBackoff = 30 * time.Minute
+	// How long the stopped announce may take, once done,
+	// that an unresponsive tracker doesn't hold up exit.
+	stopTimeout = 10 * time.Second
+)
+
+// Scheduler announces one torrent to one tracker over the
+// torrent's lifetime: started when it runs, regularly at
+// the tracker's interval, completed when told the download
+// has finished and stopped when it is done.
+//
+// Failed announces are retried with jittered exponential
+// backoff, and announces are never sent more often than
+// the tracker's min interval allows.
+type Scheduler struct {
+	// Sends the announces.
+	Announcer Announcer
+	// The tracker's announce URL.
+	URL []byte
+	// The fields of each announce other than the
+	// event, totals and tracker id, which are filled
+	// in by the Scheduler.
+	Request AnnounceRequest
+	// Gives the totals for each announce.
+	// If nil they are all zero.
+	Stats Stats
+	// If nil, the system clock.
+	Clock clock.Clock
+	// If nil, the global random source.
+	// Used to jitter backoff.
+	Rand *rand.Rand
+
+	// Interval used when the tracker doesn't send
+	// one, 30 minutes if zero.
+	DefaultInterval time.Duration
+	// The first and largest backoff after failure,
+	// 15 seconds and 30 minutes if zero.
+	MinBackoff, MaxBackoff time.Duration
+
+	once      sync.Once
+	peers     chan []Peer
+	completed chan struct{}
+	complete  sync.Once
+
+	mu     sync.Mutex
+	status SchedulerStatus
+}
+
+// SchedulerStatus is how the announces
+// of a Scheduler have gone so far.
+type SchedulerStatus struct {
+	// When the last announce was sent,
+	// and what became of it.
+	LastAnnounce time.Time
+	LastError    error
+	// Consecutive failed announces.
+	Failures int
+	// When the next announce is due.
+	NextAnnounce time.Time
+	// The last successful response.
+	Interval, MinInterval time.Duration
+	Complete, Incomplete  uint64
+}
+
+func (s *Scheduler) init() {
+	s.once.Do(func() {
+		s.peers = make(chan []Peer)
+		s.completed = make(chan struct{})
+	})
+}
+
+// Peers delivers the peers from each successful announce.
+// It must be read from for the Scheduler to make progress.
+func (s *Scheduler) Peers() <-chan []Peer {
+	s.init()
+
+	return s.peers
+}
+
+// Completed tells the Scheduler the download has finished,
+// so it sends a completed announce as soon as it may.
+func (s *Scheduler) Completed() {
+	s.init()
+
+	s.complete.Do(func() {
+		close(s.completed)
+	})
+}
+
+func (s *Scheduler) isCompleted() bool {
+	select {
+	case <-s.completed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status reports how announces have gone so far.
+func (s *Scheduler) Status() SchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+// Run announces until ctx is done, when it sends a
+// stopped announce if the tracker knows about us.
+// It returns the error of the stopped announce.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.init()
+
+	clk := s.Clock
+	if clk == nil {
+		clk = clock.System()
+	}
+
+	req := s.Request
+	req.Event = EventStarted
+
+	// Whether the tracker knows of us, i.e.
+	// a started announce has succeeded.
+	var started bool
+	// Whether the tracker knows we've completed,
+	// by a completed announce or starting after.
+	var told bool
+
+	completed := s.completed
+
+	var last time.Time
+	var minInterval time.Duration
+
+	next := clk.Now()
+
+	for {
+		s.setNext(next)
+
+		timer := clk.NewTimer(next.Sub(clk.Now()))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			if !started {
+				return nil
+			}
+
+			req.Event = EventStopped
+
+			stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stopTimeout)
+			defer cancel()
+
+			_, err := s.announce(stopCtx, clk, &req)
+
+			return err
+		case <-completed:
+			timer.Stop()
+
+			completed = nil
+
+			// Only the started announce is more important,
+			// otherwise announce now if the tracker allows.
+			if started && !told {
+				req.Event = EventCompleted
+				next = laterOf(clk.Now(), last.Add(minInterval))
+			}
+
+			continue
+		case <-timer.C():
+		}
+
+		last = clk.Now()
+
+		// Whether this announce is sent after completing,
+		// which a started one tells the tracker by left.
+		done := s.isCompleted()
+
+		resp, err := s.announce(ctx, clk, &req)
+		if err != nil {
+			next = last.Add(s.backoff(s.Status().Failures))
+			continue
+		}
+
+		switch req.Event {
+		case EventStarted:
+			started, told = true, done
+		case EventCompleted:
+			told = true
+		}
+
+		req.Event = EventNone
+		if done && !told {
+			req.Event = EventCompleted
+		}
+
+		if len(resp.TrackerId) != 0 {
+			req.TrackerId = resp.TrackerId
+		}
+
+		minInterval = resp.MinInterval
+
+		next = last.Add(interval(resp, s.DefaultInterval))
+		if req.Event == EventCompleted {
+			next = last.Add(minInterval)
+		}
+
+		if len(resp.Peers) == 0 {
+			continue
+		}
+
+		select {
+		case s.peers <- resp.Peers:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// announce sends req with up to date totals,
+// recording how it went.
+func (s *Scheduler) announce(ctx context.Context, clk clock.Clock, req *AnnounceRequest) (*AnnounceResponse, error) {
+	if s.Stats != nil {
+		req.Uploaded, req.Downloaded, req.Left = s.Stats()
+	}
+
+	now := clk.Now()
+
+	resp, err := s.Announcer.Announce(ctx, s.URL, req)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.record(now, resp, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// record notes the outcome of an announce sent at now.
+func (st *SchedulerStatus) record(now time.Time, resp *AnnounceResponse, err error) {
+	st.LastAnnounce = now
+	st.LastError = err
+
+	if err != nil {
+		st.Failures++
+		return
+	}
+
+	st.Failures = 0
+	st.Interval = resp.Interval
+	st.MinInterval = resp.MinInterval
+	st.Complete = resp.Complete
+	st.Incomplete = resp.Incomplete
+}
+
+func (s *Scheduler) setNext(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.NextAnnounce = next
+}
+
+// backoff is how long to wait after the given number of
+// consecutive failures.
+func (s *Scheduler) backoff(failures int) time.Duration {
+	return backoff(s.MinBackoff, s.MaxBackoff, failures, s.Rand)
+}
+
+// backoff doubles lo for each failure after the first,
+// up to hi, then jitters it to between half and all of
+// that. Zero lo and hi are the defaults, nil r the
+// global random source.
+func backoff(lo, hi time.Duration, failures int, r *rand.Rand) time.Duration {
+	if lo == 0 {
+		lo = defaultMinBackoff
+	}
+
+	if hi == 0 {
+		hi = defaultMaxBackoff
+	}
+
+	d := lo
+	for i := 1; i < failures && d < hi; i++ {
+		d *= 2
+	}
+
+	if d > hi {
+		d = hi
+	}
+
+	var jitter int64
+	if r != nil {
+		jitter = r.Int64N(int64(d/2) + 1)
+	} else {
+		jitter = rand.Int64N(int64(d/2) + 1)
+	}
+
+	return d/2 + time.Duration(jitter)
+}
+
+// interval is how long to wait after a successful
+// announce, at least the tracker's min interval.
+func interval(resp *AnnounceResponse, fallback time.Duration) time.Duration {
+	d := resp.Interval
+	if d == 0 {
+		d = fallback
+	}
+
+	if d == 0 {
+		d = defaultInterval
+	}
+
+	return laterDuration(d, resp.MinInterval)
+}
+
+func laterOf(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+func laterDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
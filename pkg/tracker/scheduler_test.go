@@ -0,0 +1,368 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+)
+
+// fakeAnnouncer hands each announce to the test,
+// which replies to it in lockstep.
+type fakeAnnouncer struct {
+	clock   clock.Clock
+	calls   chan announceCall
+	replies chan announceReply
+}
+
+type announceCall struct {
+	url string
+	at  time.Time
+	req AnnounceRequest
+}
+
+type announceReply struct {
+	resp *AnnounceResponse
+	err  error
+}
+
+func newFakeAnnouncer(clk clock.Clock) *fakeAnnouncer {
+	return &fakeAnnouncer{
+		clock:   clk,
+		calls:   make(chan announceCall),
+		replies: make(chan announceReply),
+	}
+}
+
+func (a *fakeAnnouncer) Announce(ctx context.Context, announce []byte, req *AnnounceRequest) (*AnnounceResponse, error) {
+	a.calls <- announceCall{url: string(announce), at: a.clock.Now(), req: *req}
+	r := <-a.replies
+
+	return r.resp, r.err
+}
+
+// expect waits for an announce, checks it and replies.
+func (a *fakeAnnouncer) expect(t *testing.T, at time.Time, event AnnounceEvent, reply announceReply) AnnounceRequest {
+	t.Helper()
+
+	c := a.next(t)
+	if c.req.Event != event || !c.at.Equal(at) {
+		t.Fatalf("announce = %v at %v, want %v at %v", c.req.Event, c.at, event, at)
+	}
+
+	a.replies <- reply
+
+	return c.req
+}
+
+// next waits for an announce, which must be replied to.
+func (a *fakeAnnouncer) next(t *testing.T) announceCall {
+	t.Helper()
+
+	select {
+	case c := <-a.calls:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no announce")
+	}
+
+	panic("unreachable")
+}
+
+// waitNext waits for s to schedule its next announce at
+// next, and for the timer for it to be made.
+func waitNext(t *testing.T, s *Scheduler, clk *clock.Fake, next time.Time) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Status().NextAnnounce.Equal(next) {
+		if time.Now().After(deadline) {
+			t.Fatalf("next announce = %v, want %v", s.Status().NextAnnounce, next)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	clk.BlockUntil(1)
+}
+
+func TestScheduler(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	s := &Scheduler{
+		Announcer: a,
+		URL:       []byte("http://tracker.example/announce"),
+		Request:   AnnounceRequest{Port: 6881},
+		Stats: func() (uint64, uint64, uint64) {
+			return 1, 2, 3
+		},
+		Clock: clk,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	peer := Peer{Addr: netip.MustParseAddrPort("10.0.0.1:6881")}
+
+	req := a.expect(t, t0, EventStarted, announceReply{resp: &AnnounceResponse{
+		Interval:    10 * time.Minute,
+		MinInterval: time.Minute,
+		TrackerId:   []byte("abc"),
+		Complete:    5,
+		Peers:       []Peer{peer},
+	}})
+
+	if req.Port != 6881 || req.Uploaded != 1 || req.Downloaded != 2 || req.Left != 3 {
+		t.Fatalf("started request = %+v", req)
+	}
+
+	if peers := <-s.Peers(); len(peers) != 1 || peers[0] != peer {
+		t.Fatalf("peers = %v, want %v", peers, peer)
+	}
+
+	if st := s.Status(); st.Complete != 5 || st.Interval != 10*time.Minute || st.Failures != 0 {
+		t.Fatalf("status = %+v", st)
+	}
+
+	// Regular announces at the interval echo the tracker id.
+	waitNext(t, s, clk, t0.Add(10*time.Minute))
+	clk.Advance(10 * time.Minute)
+
+	req = a.expect(t, t0.Add(10*time.Minute), EventNone, announceReply{resp: &AnnounceResponse{
+		Interval:    10 * time.Minute,
+		MinInterval: time.Minute,
+	}})
+
+	if string(req.TrackerId) != "abc" {
+		t.Fatalf("tracker id = %q, want abc", req.TrackerId)
+	}
+
+	// Completed waits out the min interval.
+	waitNext(t, s, clk, t0.Add(20*time.Minute))
+	s.Completed()
+	waitNext(t, s, clk, t0.Add(11*time.Minute))
+	clk.Advance(time.Minute)
+
+	a.expect(t, t0.Add(11*time.Minute), EventCompleted, announceReply{resp: &AnnounceResponse{
+		Interval: 10 * time.Minute,
+	}})
+
+	waitNext(t, s, clk, t0.Add(21*time.Minute))
+	cancel()
+
+	a.expect(t, t0.Add(11*time.Minute), EventStopped, announceReply{resp: &AnnounceResponse{}})
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	s := &Scheduler{
+		Announcer:  a,
+		Clock:      clk,
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: 40 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	failed := errors.New("unreachable")
+	now := t0
+
+	// The started announce is retried until it succeeds,
+	// backing off exponentially up to the maximum.
+	for _, max := range []time.Duration{10, 20, 40, 40} {
+		max *= time.Second
+
+		a.expect(t, now, EventStarted, announceReply{err: failed})
+
+		var st SchedulerStatus
+
+		deadline := time.Now().Add(5 * time.Second)
+		for st = s.Status(); !st.NextAnnounce.After(now); st = s.Status() {
+			if time.Now().After(deadline) {
+				t.Fatalf("no retry scheduled")
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+
+		if st.LastError != failed {
+			t.Fatalf("last error = %v, want %v", st.LastError, failed)
+		}
+
+		wait := st.NextAnnounce.Sub(now)
+		if wait < max/2 || wait > max {
+			t.Fatalf("backoff = %v, want within [%v, %v]", wait, max/2, max)
+		}
+
+		clk.BlockUntil(1)
+		clk.Advance(wait)
+		now = now.Add(wait)
+	}
+
+	a.expect(t, now, EventStarted, announceReply{resp: &AnnounceResponse{Interval: time.Hour}})
+	waitNext(t, s, clk, now.Add(time.Hour))
+
+	if st := s.Status(); st.Failures != 0 || st.LastError != nil {
+		t.Fatalf("status after success = %+v", st)
+	}
+
+	cancel()
+
+	a.expect(t, now, EventStopped, announceReply{err: failed})
+
+	if err := <-done; err != failed {
+		t.Fatalf("Run = %v, want %v", err, failed)
+	}
+}
+
+func TestSchedulerNeverStarted(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	s := &Scheduler{Announcer: a, Clock: clk}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	a.expect(t, t0, EventStarted, announceReply{err: errors.New("unreachable")})
+
+	clk.BlockUntil(1)
+	cancel()
+
+	// The tracker doesn't know of us, so isn't told we've stopped.
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run = %v", err)
+		}
+	case c := <-a.calls:
+		t.Fatalf("unexpected %v announce", c.req.Event)
+	}
+}
+
+func TestSchedulerCompletedFirst(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	s := &Scheduler{Announcer: a, Clock: clk}
+	s.Completed()
+	s.Completed()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	a.expect(t, t0, EventStarted, announceReply{resp: &AnnounceResponse{Interval: time.Minute}})
+
+	// Having started after completing,
+	// there is no need to send completed.
+	waitNext(t, s, clk, t0.Add(time.Minute))
+	clk.Advance(time.Minute)
+
+	a.expect(t, t0.Add(time.Minute), EventNone, announceReply{resp: &AnnounceResponse{Interval: time.Minute}})
+
+	waitNext(t, s, clk, t0.Add(2*time.Minute))
+	cancel()
+
+	a.expect(t, t0.Add(time.Minute), EventStopped, announceReply{resp: &AnnounceResponse{}})
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+}
+
+func TestSchedulerMinInterval(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	clk := clock.NewFake(t0)
+	a := newFakeAnnouncer(clk)
+
+	s := &Scheduler{Announcer: a, Clock: clk}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// An interval shorter than the min interval is raised to it.
+	a.expect(t, t0, EventStarted, announceReply{resp: &AnnounceResponse{
+		Interval:    time.Second,
+		MinInterval: time.Minute,
+	}})
+
+	waitNext(t, s, clk, t0.Add(time.Minute))
+
+	// Without an interval, the default is used.
+	clk.Advance(time.Minute)
+	a.expect(t, t0.Add(time.Minute), EventNone, announceReply{resp: &AnnounceResponse{}})
+
+	waitNext(t, s, clk, t0.Add(time.Minute+defaultInterval))
+	cancel()
+
+	a.expect(t, t0.Add(time.Minute), EventStopped, announceReply{resp: &AnnounceResponse{}})
+	<-done
+}
+
+// deadlines records whether each announce had a deadline.
+type deadlines chan bool
+
+func (d deadlines) Announce(ctx context.Context, announce []byte, req *AnnounceRequest) (*AnnounceResponse, error) {
+	_, ok := ctx.Deadline()
+	d <- ok
+
+	return &AnnounceResponse{Interval: time.Hour}, nil
+}
+
+func TestSchedulerStopTimeout(t *testing.T) {
+	d := make(deadlines, 2)
+	s := &Scheduler{Announcer: d, URL: []byte("http://a")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	<-d
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+
+	if !<-d {
+		t.Fatal("stopped announce has no deadline")
+	}
+}
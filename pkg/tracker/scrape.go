@@ -0,0 +1,228 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+// ErrNoScrape is returned when a tracker's announce URL
+// does not follow the scrape convention, so we can't
+// tell where its scrape URL is.
+var ErrNoScrape = errors.New("tracker: announce URL has no scrape URL")
+
+// ScrapeStats is what a tracker knows
+// about the swarm of one torrent.
+type ScrapeStats struct {
+	// The number of seeders.
+	Complete uint64
+	// The number of times the torrent
+	// has been downloaded.
+	Downloaded uint64
+	// The number of leechers.
+	Incomplete uint64
+}
+
+// ScrapeURL derives the scrape URL from an announce URL.
+// By convention, if the last segment of its path starts with
+// "announce", it is replaced with "scrape". Otherwise the
+// tracker doesn't support scraping and false is returned.
+func ScrapeURL(announce []byte) ([]byte, bool) {
+	const (
+		from = "announce"
+		to   = "scrape"
+	)
+
+	path := announce
+	if i := bytes.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+
+	// The path starts after the scheme and authority, if any.
+	var start int
+	if i := bytes.Index(path, []byte("://")); i >= 0 {
+		start = i + len("://")
+
+		slash := bytes.IndexByte(path[start:], '/')
+		if slash < 0 {
+			return nil, false
+		}
+
+		start += slash
+	}
+
+	slash := bytes.LastIndexByte(path[start:], '/')
+	if slash < 0 || !bytes.HasPrefix(path[start+slash+1:], []byte(from)) {
+		return nil, false
+	}
+
+	slash += start
+
+	u := make([]byte, 0, len(announce)-len(from)+len(to))
+	u = append(u, announce[:slash+1]...)
+	u = append(u, to...)
+
+	return append(u, announce[slash+1+len(from):]...), true
+}
+
+// BuildScrape writes the scrape URL for as many of
+// infoHashes as fit into buf. It yields the URL and
+// the number of info hashes in it, which is zero if
+// not even one fits.
+func BuildScrape(buf *URLBuffer, scrape []byte, infoHashes [][20]byte) ([]byte, int) {
+	const info_hash = "info_hash="
+
+	if fragment := bytes.IndexByte(scrape, '#'); fragment >= 0 {
+		scrape = scrape[:fragment]
+	}
+
+	n := copy(buf[:], scrape)
+	if n < len(scrape) {
+		return nil, 0
+	}
+
+	sep := querySep(scrape)
+
+	var i int
+	for ; i < len(infoHashes); i++ {
+		// separator, key, and the worst case escaping.
+		if n+1+len(info_hash)+60 > len(buf) {
+			break
+		}
+
+		if sep != 0 {
+			buf[n] = sep
+			n += 1
+		}
+
+		sep = '&'
+
+		n += copy(buf[n:], info_hash)
+		n += Escape20(buf[n:], &infoHashes[i])
+	}
+
+	if i == 0 {
+		return nil, 0
+	}
+
+	return buf[:n], i
+}
+
+// DecodeScrapeResponse parses the bencoded body of a
+// tracker's scrape response, adding the stats of each
+// torrent to stats. A failure reason is returned as
+// a *FailureError.
+func DecodeScrapeResponse(stats map[[20]byte]ScrapeStats, p []byte) error {
+	it := parse.NewDictIter(p)
+	for it.Next() {
+		switch string(it.Key()) {
+		case "failure reason":
+			reason, _ := parse.Bytes(it.Value())
+			return &FailureError{Reason: string(reason)}
+		case "files":
+			files := parse.NewDictIter(it.Value())
+			for files.Next() {
+				if len(files.Key()) != 20 {
+					continue
+				}
+
+				stats[[20]byte(files.Key())] = decodeScrapeStats(files.Value())
+			}
+
+			if err := files.Err(); err.IsError() {
+				return err
+			}
+		}
+	}
+
+	if err := it.Err(); err.IsError() {
+		return err
+	}
+
+	return nil
+}
+
+// AppendScrapeResponse appends the bencoded scrape response
+// for the stats of each of infoHashes to dst, the inverse of
+// DecodeScrapeResponse. infoHashes must be sorted.
+func AppendScrapeResponse(dst []byte, infoHashes [][20]byte, stats []ScrapeStats) []byte {
+	dst = append(dst, parse.OpenDict)
+	dst = encode.String(dst, "files")
+	dst = append(dst, parse.OpenDict)
+
+	for i := 0; i < len(infoHashes); i++ {
+		dst = encode.String(dst, infoHashes[i][:])
+		dst = append(dst, parse.OpenDict)
+		dst = encode.KeyUint(dst, "complete", stats[i].Complete)
+		dst = encode.KeyUint(dst, "downloaded", stats[i].Downloaded)
+		dst = encode.KeyUint(dst, "incomplete", stats[i].Incomplete)
+		dst = append(dst, parse.EndTerm)
+	}
+
+	dst = append(dst, parse.EndTerm)
+
+	return append(dst, parse.EndTerm)
+}
+
+func decodeScrapeStats(p []byte) ScrapeStats {
+	var s ScrapeStats
+
+	it := parse.NewDictIter(p)
+	for it.Next() {
+		n, _ := parse.Integer(it.Value())
+
+		switch string(it.Key()) {
+		case "complete":
+			s.Complete = nonNegative(n)
+		case "downloaded":
+			s.Downloaded = nonNegative(n)
+		case "incomplete":
+			s.Incomplete = nonNegative(n)
+		}
+	}
+
+	return s
+}
+
+// Scrape asks the HTTP tracker at announce for the stats
+// of each of infoHashes, batching as many into each request
+// as fit in a URLBuffer. The stats are returned in the same
+// order, torrents the tracker doesn't know have zero stats.
+func (c *Client) Scrape(ctx context.Context, announce []byte, infoHashes [][20]byte) ([]ScrapeStats, error) {
+	scrape, ok := ScrapeURL(announce)
+	if !ok {
+		return nil, ErrNoScrape
+	}
+
+	byHash := make(map[[20]byte]ScrapeStats, len(infoHashes))
+
+	for rest := infoHashes; len(rest) != 0; {
+		var buf URLBuffer
+
+		u, n := BuildScrape(&buf, scrape, rest)
+		if n == 0 {
+			return nil, ErrURLTooLong
+		}
+
+		rest = rest[n:]
+
+		body, err := c.get(ctx, string(u))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := DecodeScrapeResponse(byHash, body); err != nil {
+			return nil, err
+		}
+	}
+
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i := 0; i < len(infoHashes); i++ {
+		stats[i] = byHash[infoHashes[i]]
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,203 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/bencode/encode"
+	"github.com/joelancaster/bytepour/pkg/bencode/parse"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		announce string
+		want     string
+	}{
+		{"http://example.com/announce", "http://example.com/scrape"},
+		{"http://example.com/x/announce", "http://example.com/x/scrape"},
+		{"http://example.com/announce.php", "http://example.com/scrape.php"},
+		{"http://example.com/a", ""},
+		{"http://example.com/announce?x2%0644", "http://example.com/scrape?x2%0644"},
+		{"http://example.com/announce?x=2/4", "http://example.com/scrape?x=2/4"},
+		{"http://example.com/x%064announce", ""},
+		{"http://example.com/a/announce#frag", "http://example.com/a/scrape#frag"},
+		{"http://example.com", ""},
+		{"http://announce.example.com", ""},
+		{"http://announce.example.com?x=/announce", ""},
+		{"udp://announce.example.com:80/announce", "udp://announce.example.com:80/scrape"},
+	}
+
+	for _, tc := range tests {
+		got, ok := ScrapeURL([]byte(tc.announce))
+
+		if ok != (tc.want != "") || string(got) != tc.want {
+			t.Fatalf("%s: got: %q (%v), want: %q", tc.announce, got, ok, tc.want)
+		}
+	}
+}
+
+func TestBuildScrape(t *testing.T) {
+	hashes := make([][20]byte, 200)
+	for i := range hashes {
+		rand.Read(hashes[i][:])
+	}
+
+	scrape := []byte("http://example.com/scrape?passkey=abc#frag")
+
+	var got [][20]byte
+
+	for rest := hashes; len(rest) != 0; {
+		var buf URLBuffer
+
+		u, n := BuildScrape(&buf, scrape, rest)
+		if n == 0 {
+			t.Fatalf("nothing fit")
+		}
+
+		if len(u) > len(buf) {
+			t.Fatalf("url too long: %d", len(u))
+		}
+
+		rest = rest[n:]
+
+		parsed, err := url.Parse(string(u))
+		if err != nil {
+			t.Fatalf("url.Parse: %v", err)
+		}
+
+		q := parsed.Query()
+
+		if q.Get("passkey") != "abc" || parsed.Fragment != "" {
+			t.Fatalf("lost existing query: %s", u)
+		}
+
+		if len(q["info_hash"]) != n {
+			t.Fatalf("got %d info hashes, want: %d", len(q["info_hash"]), n)
+		}
+
+		for _, h := range q["info_hash"] {
+			got = append(got, [20]byte([]byte(h)))
+		}
+	}
+
+	if len(got) != len(hashes) {
+		t.Fatalf("got %d hashes, want: %d", len(got), len(hashes))
+	}
+
+	for i := range hashes {
+		if got[i] != hashes[i] {
+			t.Fatalf("hash %d: got: %x, want: %x", i, got[i], hashes[i])
+		}
+	}
+}
+
+func TestBuildScrapeQuery(t *testing.T) {
+	h := [20]byte{'a'}
+	want := "info_hash=a" + strings.Repeat("%00", 19)
+
+	for _, tc := range []struct {
+		scrape string
+		want   string
+	}{
+		{"http://t/scrape", "http://t/scrape?" + want},
+		{"http://t/scrape?", "http://t/scrape?" + want},
+		{"http://t/scrape?k=v", "http://t/scrape?k=v&" + want},
+		{"http://t/scrape?k=v&", "http://t/scrape?k=v&" + want},
+	} {
+		var buf URLBuffer
+
+		if u, _ := BuildScrape(&buf, []byte(tc.scrape), [][20]byte{h, h}); string(u) != tc.want+"&"+want {
+			t.Fatalf("%s: got: %s", tc.scrape, u)
+		}
+	}
+}
+
+func TestClientScrape(t *testing.T) {
+	hashes := make([][20]byte, 100)
+	for i := range hashes {
+		rand.Read(hashes[i][:])
+	}
+
+	var requests int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" {
+			w.Write([]byte("d14:failure reason9:not founde"))
+			return
+		}
+
+		requests++
+
+		b := []byte("d5:filesd")
+		for _, h := range r.URL.Query()["info_hash"] {
+			// Pretend not to know one torrent.
+			if h == string(hashes[3][:]) {
+				continue
+			}
+
+			b = encode.String(b, h)
+			b = append(b, parse.OpenDict)
+			b = encode.KeyUint(b, "complete", uint64(h[0]))
+			b = encode.KeyUint(b, "downloaded", 5)
+			b = encode.KeyUint(b, "incomplete", uint64(h[1]))
+			b = append(b, parse.EndTerm)
+		}
+		b = append(b, "ee"...)
+
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	var c Client
+
+	stats, err := c.Scrape(context.Background(), []byte(srv.URL+"/announce"), hashes)
+	if err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+
+	if requests < 2 {
+		t.Fatalf("expected batching over several requests, got: %d", requests)
+	}
+
+	for i := range hashes {
+		want := ScrapeStats{Complete: uint64(hashes[i][0]), Downloaded: 5, Incomplete: uint64(hashes[i][1])}
+		if i == 3 {
+			want = ScrapeStats{}
+		}
+
+		if stats[i] != want {
+			t.Fatalf("stats %d: got: %+v, want: %+v", i, stats[i], want)
+		}
+	}
+
+	if _, err := c.Scrape(context.Background(), []byte(srv.URL+"/a"), hashes); err != ErrNoScrape {
+		t.Fatalf("got: %v, want: %v", err, ErrNoScrape)
+	}
+
+	var failure *FailureError
+	if _, err := c.Scrape(context.Background(), []byte(srv.URL+"/x/announce"), hashes[:1]); !errors.As(err, &failure) {
+		t.Fatalf("got: %v, want failure", err)
+	}
+}
+
+func TestAppendScrapeResponse(t *testing.T) {
+	hashes := [][20]byte{{1}, {2}}
+	stats := []ScrapeStats{{Complete: 1, Downloaded: 2, Incomplete: 3}, {Complete: 4}}
+
+	p := AppendScrapeResponse(nil, hashes, stats)
+
+	got := make(map[[20]byte]ScrapeStats)
+	if err := DecodeScrapeResponse(got, p); err != nil {
+		t.Fatalf("decode %q: %v", p, err)
+	}
+
+	if len(got) != 2 || got[hashes[0]] != stats[0] || got[hashes[1]] != stats[1] {
+		t.Fatalf("got %v from %q", got, p)
+	}
+}
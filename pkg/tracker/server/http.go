@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+// ServeHTTP implements http.Handler for Server. Requests
+// whose last path segment starts with "announce" are
+// announces, and with "scrape" are scrapes, following
+// the scrape convention. A scrape without info hashes
+// is of every torrent.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segment := r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:]
+
+	var body []byte
+
+	switch {
+	case strings.HasPrefix(segment, "announce"):
+		body = s.serveAnnounce(r)
+	case strings.HasPrefix(segment, "scrape"):
+		body = s.serveScrape(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(body)
+}
+
+func (s *Server) serveAnnounce(r *http.Request) []byte {
+	var req tracker.AnnounceRequest
+
+	if err := tracker.ParseAnnounceQuery(&req, []byte(r.URL.RawQuery)); err != nil {
+		return failure(err)
+	}
+
+	from, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return failure(err)
+	}
+
+	resp, err := s.Announce(&req, from.Addr())
+	if err != nil {
+		return failure(err)
+	}
+
+	return resp.AppendBencode(nil, req.Compact, req.NoPeerId)
+}
+
+func (s *Server) serveScrape(r *http.Request) []byte {
+	hashes, err := tracker.ParseScrapeQuery(nil, []byte(r.URL.RawQuery))
+	if err != nil {
+		return failure(err)
+	}
+
+	if len(hashes) == 0 {
+		hashes = s.InfoHashes(nil)
+	}
+
+	// The files dictionary's keys must be
+	// sorted, and can't be repeated.
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	n := 0
+	for i := 0; i < len(hashes); i++ {
+		if i == 0 || hashes[i] != hashes[n-1] {
+			hashes[n] = hashes[i]
+			n++
+		}
+	}
+
+	hashes = hashes[:n]
+
+	return tracker.AppendScrapeResponse(nil, hashes, s.Scrape(nil, hashes))
+}
+
+// failure is the response to a request that failed with err.
+func failure(err error) []byte {
+	resp := tracker.AnnounceResponse{FailureReason: failureReason(err)}
+
+	return resp.AppendBencode(nil, false, false)
+}
+
+// failureReason is why a request failed with err,
+// in words fit for peers.
+func failureReason(err error) []byte {
+	var qerr *tracker.QueryError
+
+	switch {
+	case errors.As(err, &qerr):
+		return []byte("invalid " + qerr.Key)
+	case err == ErrNotAllowed:
+		return []byte("torrent not allowed")
+	default:
+		return []byte(err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+func TestServeHTTP(t *testing.T) {
+	s := &Server{
+		Allowed: func(h [20]byte) bool {
+			return h != [20]byte{}
+		},
+	}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	var c tracker.Client
+
+	ctx := context.Background()
+	announceURL := []byte(srv.URL + "/announce?passkey=abc")
+
+	for i, compact := range []bool{true, false} {
+		req := tracker.AnnounceRequest{
+			InfoHash: testHash,
+			PeerId:   [20]byte{byte(i + 1)},
+			Port:     uint64(6881 + i),
+			Left:     1,
+			Event:    tracker.EventStarted,
+			Compact:  compact,
+		}
+
+		resp, err := c.Announce(ctx, announceURL, &req)
+		if err != nil {
+			t.Fatalf("Announce: %v", err)
+		}
+
+		if resp.Incomplete != uint64(i+1) || len(resp.Peers) != i {
+			t.Fatalf("announce %d: %+v", i, resp)
+		}
+
+		// The second, non-compact, announce is given the first peer's ID.
+		if i == 1 && (resp.Peers[0].PeerId != [20]byte{1} || resp.Peers[0].Addr.Port() != 6881) {
+			t.Fatalf("peers = %v", resp.Peers)
+		}
+	}
+
+	stats, err := c.Scrape(ctx, announceURL, [][20]byte{testHash, {1}})
+	if err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+
+	if len(stats) != 2 || stats[0] != (tracker.ScrapeStats{Incomplete: 2}) || stats[1] != (tracker.ScrapeStats{}) {
+		t.Fatalf("Scrape = %+v", stats)
+	}
+
+	// Not allowed.
+	var ferr *tracker.FailureError
+
+	_, err = c.Announce(ctx, announceURL, &tracker.AnnounceRequest{Port: 1})
+	if !errors.As(err, &ferr) || ferr.Reason != "torrent not allowed" {
+		t.Fatalf("Announce = %v, want not allowed", err)
+	}
+
+	// Malformed.
+	_, err = c.Announce(ctx, []byte(srv.URL+"/announce?info_hash=short"), &tracker.AnnounceRequest{})
+	if !errors.As(err, &ferr) || ferr.Reason != "invalid info_hash" {
+		t.Fatalf("Announce = %v, want invalid info_hash", err)
+	}
+
+	r, err := http.Get(srv.URL + "/elsewhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+
+	if r.StatusCode != http.StatusNotFound {
+		t.Fatalf("elsewhere: status = %d", r.StatusCode)
+	}
+}
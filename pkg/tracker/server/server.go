@@ -0,0 +1,301 @@
+// Package server is a BitTorrent tracker that can be
+// embedded in other programs, serving announces and
+// scrapes over HTTP (BEP 3, 23, 48) and UDP (BEP 15).
+//
+// Peers are kept in memory, and forgotten when they
+// haven't announced for a while.
+package server
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+const (
+	defaultInterval   = 30 * time.Minute
+	defaultNumWant    = 50
+	defaultMaxNumWant = 200
+	// How often expired peers are looked for.
+	sweepInterval = time.Minute
+)
+
+// ErrNotAllowed is returned when announcing
+// a torrent that isn't in the allowlist.
+var ErrNotAllowed = errors.New("server: torrent not allowed")
+
+// Server tracks the peers of torrents. The zero value
+// is an open tracker, ready to use.
+type Server struct {
+	// How often peers should announce, 30 minutes if zero.
+	Interval time.Duration
+	// If non-zero, peers must not announce
+	// more often than this.
+	MinInterval time.Duration
+	// How long a peer is remembered after its last
+	// announce, twice the interval if zero.
+	PeerTTL time.Duration
+	// How many peers to give when the announce
+	// doesn't say, 50 if zero.
+	DefaultNumWant int
+	// The most peers to give, 200 if zero.
+	MaxNumWant int
+	// Reports whether a torrent may be tracked.
+	// If nil, every torrent is.
+	Allowed func(infoHash [20]byte) bool
+	// Whether to believe the address a peer claims
+	// in its announce, rather than using the
+	// address the announce came from.
+	TrustIP bool
+	// If nil, the system clock.
+	Clock clock.Clock
+
+	mu        sync.Mutex
+	swarms    map[[20]byte]*swarm
+	lastSweep time.Time
+
+	// For UDP connection IDs.
+	secretOnce sync.Once
+	secret     [32]byte
+}
+
+type swarm struct {
+	peers   map[netip.AddrPort]*peer
+	seeders int
+	// Times the torrent has been completed.
+	downloaded uint64
+}
+
+type peer struct {
+	id      [20]byte
+	seeder  bool
+	expires time.Time
+}
+
+// Announce records the announce req, which came from the
+// address from, and yields the response to it. A peer that
+// has stopped is forgotten.
+func (s *Server) Announce(req *tracker.AnnounceRequest, from netip.Addr) (*tracker.AnnounceResponse, error) {
+	if s.Allowed != nil && !s.Allowed(req.InfoHash) {
+		return nil, ErrNotAllowed
+	}
+
+	ip := from
+	if s.TrustIP && req.IP.IsValid() {
+		ip = req.IP
+	}
+
+	addr := netip.AddrPortFrom(ip.Unmap(), uint16(req.Port))
+
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	sw := s.swarms[req.InfoHash]
+	if sw == nil {
+		if req.Event == tracker.EventStopped {
+			return s.response(sw, nil), nil
+		}
+
+		if s.swarms == nil {
+			s.swarms = make(map[[20]byte]*swarm)
+		}
+
+		sw = &swarm{peers: make(map[netip.AddrPort]*peer)}
+		s.swarms[req.InfoHash] = sw
+	}
+
+	p := sw.peers[addr]
+
+	if req.Event == tracker.EventStopped {
+		if p != nil {
+			sw.remove(addr, p)
+		}
+
+		return s.response(sw, nil), nil
+	}
+
+	if p == nil {
+		p = &peer{}
+		sw.peers[addr] = p
+	}
+
+	seeder := req.Left == 0
+	if req.Event == tracker.EventCompleted && !p.seeder {
+		sw.downloaded++
+	}
+
+	if seeder != p.seeder {
+		if seeder {
+			sw.seeders++
+		} else {
+			sw.seeders--
+		}
+	}
+
+	p.id = req.PeerId
+	p.seeder = seeder
+	p.expires = now.Add(s.peerTTL())
+
+	return s.response(sw, sw.pick(addr, seeder, s.numWant(req.NumWant))), nil
+}
+
+// Scrape appends the stats of each of infoHashes
+// to dst. Torrents that aren't tracked have none.
+func (s *Server) Scrape(dst []tracker.ScrapeStats, infoHashes [][20]byte) []tracker.ScrapeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(s.now())
+
+	for _, h := range infoHashes {
+		var st tracker.ScrapeStats
+
+		if sw := s.swarms[h]; sw != nil && (s.Allowed == nil || s.Allowed(h)) {
+			st = tracker.ScrapeStats{
+				Complete:   uint64(sw.seeders),
+				Downloaded: sw.downloaded,
+				Incomplete: uint64(len(sw.peers) - sw.seeders),
+			}
+		}
+
+		dst = append(dst, st)
+	}
+
+	return dst
+}
+
+// InfoHashes appends the info hashes of
+// the torrents being tracked to dst.
+func (s *Server) InfoHashes(dst [][20]byte) [][20]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for h := range s.swarms {
+		if s.Allowed == nil || s.Allowed(h) {
+			dst = append(dst, h)
+		}
+	}
+
+	return dst
+}
+
+// response is the response to an announce to sw,
+// which is nil if the torrent isn't tracked.
+func (s *Server) response(sw *swarm, peers []tracker.Peer) *tracker.AnnounceResponse {
+	resp := &tracker.AnnounceResponse{
+		Interval:    s.interval(),
+		MinInterval: s.MinInterval,
+		Peers:       peers,
+	}
+
+	if sw != nil {
+		resp.Complete = uint64(sw.seeders)
+		resp.Incomplete = uint64(len(sw.peers) - sw.seeders)
+	}
+
+	return resp
+}
+
+// pick chooses up to n peers for the peer at self. Seeders
+// are only given leechers. Map iteration order makes the
+// choice random enough.
+func (sw *swarm) pick(self netip.AddrPort, seeder bool, n int) []tracker.Peer {
+	var peers []tracker.Peer
+
+	for addr, p := range sw.peers {
+		if len(peers) == n {
+			break
+		}
+
+		if addr == self || seeder && p.seeder {
+			continue
+		}
+
+		peers = append(peers, tracker.Peer{Addr: addr, PeerId: p.id})
+	}
+
+	return peers
+}
+
+func (sw *swarm) remove(addr netip.AddrPort, p *peer) {
+	if p.seeder {
+		sw.seeders--
+	}
+
+	delete(sw.peers, addr)
+}
+
+// sweep forgets expired peers, and torrents
+// without peers, if it hasn't done so lately.
+func (s *Server) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+
+	s.lastSweep = now
+
+	for h, sw := range s.swarms {
+		for addr, p := range sw.peers {
+			if !now.Before(p.expires) {
+				sw.remove(addr, p)
+			}
+		}
+
+		if len(sw.peers) == 0 {
+			delete(s.swarms, h)
+		}
+	}
+}
+
+func (s *Server) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+
+	return s.Clock.Now()
+}
+
+func (s *Server) interval() time.Duration {
+	if s.Interval == 0 {
+		return defaultInterval
+	}
+
+	return s.Interval
+}
+
+func (s *Server) peerTTL() time.Duration {
+	if s.PeerTTL == 0 {
+		return 2 * s.interval()
+	}
+
+	return s.PeerTTL
+}
+
+// numWant bounds the number of peers asked for.
+func (s *Server) numWant(n uint64) int {
+	max := s.MaxNumWant
+	if max == 0 {
+		max = defaultMaxNumWant
+	}
+
+	if n == 0 {
+		n = uint64(s.DefaultNumWant)
+		if n == 0 {
+			n = defaultNumWant
+		}
+	}
+
+	if n > uint64(max) {
+		return max
+	}
+
+	return int(n)
+}
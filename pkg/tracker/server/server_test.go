@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+var testHash = [20]byte{0xab}
+
+func announce(t *testing.T, s *Server, ip string, port uint64, left uint64, event tracker.AnnounceEvent) *tracker.AnnounceResponse {
+	t.Helper()
+
+	req := tracker.AnnounceRequest{
+		InfoHash: testHash,
+		PeerId:   [20]byte{byte(port)},
+		Port:     port,
+		Left:     left,
+		Event:    event,
+	}
+
+	resp, err := s.Announce(&req, netip.MustParseAddr(ip))
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+
+	return resp
+}
+
+func TestAnnounce(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	s := &Server{Clock: clk, Interval: time.Minute, MinInterval: time.Second}
+
+	resp := announce(t, s, "10.0.0.1", 1, 100, tracker.EventStarted)
+	if len(resp.Peers) != 0 || resp.Incomplete != 1 || resp.Complete != 0 ||
+		resp.Interval != time.Minute || resp.MinInterval != time.Second {
+		t.Fatalf("first announce: %+v", resp)
+	}
+
+	// A seeder is given the leecher.
+	resp = announce(t, s, "10.0.0.2", 2, 0, tracker.EventStarted)
+	if len(resp.Peers) != 1 || resp.Peers[0].Addr != netip.MustParseAddrPort("10.0.0.1:1") ||
+		resp.Peers[0].PeerId != [20]byte{1} || resp.Complete != 1 || resp.Incomplete != 1 {
+		t.Fatalf("seeder announce: %+v", resp)
+	}
+
+	// Another seeder is given no seeders.
+	resp = announce(t, s, "10.0.0.3", 3, 0, tracker.EventNone)
+	if len(resp.Peers) != 1 || resp.Peers[0].Addr.Port() != 1 {
+		t.Fatalf("second seeder announce: %+v", resp)
+	}
+
+	// The leecher completes.
+	resp = announce(t, s, "10.0.0.1", 1, 0, tracker.EventCompleted)
+	if resp.Complete != 3 || resp.Incomplete != 0 || len(resp.Peers) != 0 {
+		t.Fatalf("completed announce: %+v", resp)
+	}
+
+	if st := s.Scrape(nil, [][20]byte{testHash, {1}}); st[0] != (tracker.ScrapeStats{Complete: 3, Downloaded: 1}) || st[1] != (tracker.ScrapeStats{}) {
+		t.Fatalf("Scrape = %+v", st)
+	}
+
+	// Stopped peers are forgotten.
+	resp = announce(t, s, "10.0.0.3", 3, 0, tracker.EventStopped)
+	if resp.Complete != 2 {
+		t.Fatalf("stopped announce: %+v", resp)
+	}
+
+	// As are peers that don't announce. The sweep
+	// happens up to a minute after they expire.
+	clk.Advance(90 * time.Second)
+	announce(t, s, "10.0.0.4", 4, 10, tracker.EventStarted)
+	clk.Advance(time.Minute)
+
+	if st := s.Scrape(nil, [][20]byte{testHash}); st[0] != (tracker.ScrapeStats{Downloaded: 1, Incomplete: 1}) {
+		t.Fatalf("Scrape after expiry = %+v", st)
+	}
+
+	if hashes := s.InfoHashes(nil); len(hashes) != 1 || hashes[0] != testHash {
+		t.Fatalf("InfoHashes = %x", hashes)
+	}
+
+	// Torrents without peers are forgotten too.
+	clk.Advance(5 * time.Minute)
+	s.Scrape(nil, nil)
+
+	if hashes := s.InfoHashes(nil); len(hashes) != 0 {
+		t.Fatalf("InfoHashes after expiry = %x", hashes)
+	}
+}
+
+func TestAnnounceNumWant(t *testing.T) {
+	s := &Server{DefaultNumWant: 3, MaxNumWant: 5}
+
+	for i := 1; i <= 10; i++ {
+		announce(t, s, "10.0.0.1", uint64(i), 1, tracker.EventStarted)
+	}
+
+	for _, test := range []struct{ numWant, want uint64 }{{0, 3}, {1, 1}, {5, 5}, {100, 5}} {
+		req := tracker.AnnounceRequest{InfoHash: testHash, Port: 1, Left: 1, NumWant: test.numWant}
+
+		resp, err := s.Announce(&req, netip.MustParseAddr("10.0.0.1"))
+		if err != nil || uint64(len(resp.Peers)) != test.want {
+			t.Fatalf("numwant %d: got %d peers, %v, want %d", test.numWant, len(resp.Peers), err, test.want)
+		}
+
+		for _, p := range resp.Peers {
+			if p.Addr.Port() == 1 {
+				t.Fatalf("given itself: %v", resp.Peers)
+			}
+		}
+	}
+}
+
+func TestAnnounceAllowed(t *testing.T) {
+	s := &Server{
+		Allowed: func(h [20]byte) bool {
+			return h == testHash
+		},
+		TrustIP: true,
+	}
+
+	req := tracker.AnnounceRequest{InfoHash: [20]byte{1}, Port: 1}
+	if _, err := s.Announce(&req, netip.MustParseAddr("10.0.0.1")); err != ErrNotAllowed {
+		t.Fatalf("Announce = %v, want %v", err, ErrNotAllowed)
+	}
+
+	// The claimed address is used when trusted.
+	req = tracker.AnnounceRequest{InfoHash: testHash, Port: 1, Left: 1, IP: netip.MustParseAddr("192.168.0.1")}
+	if _, err := s.Announce(&req, netip.MustParseAddr("10.0.0.1")); err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+
+	resp := announce(t, s, "10.0.0.2", 2, 1, tracker.EventNone)
+	if len(resp.Peers) != 1 || resp.Peers[0].Addr != netip.MustParseAddrPort("192.168.0.1:1") {
+		t.Fatalf("peers = %v", resp.Peers)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+// UDP tracker protocol (BEP 15) constants.
+const (
+	udpProtocolId = 0x41727101980
+
+	udpActionConnect  = uint32(0)
+	udpActionAnnounce = uint32(1)
+	udpActionScrape   = uint32(2)
+	udpActionError    = uint32(3)
+
+	udpHeaderLength   = 8 + 4 + 4
+	udpAnnounceLength = udpHeaderLength + 20 + 20 + 8 + 8 + 8 + 4 + 4 + 4 + 4 + 2
+
+	// Connection IDs are good for one
+	// or two of these, in seconds.
+	udpConnectionIdEpoch = 60
+
+	udpMaxScrape = 74
+	udpMaxPacket = 8192
+)
+
+// ServeUDP answers UDP tracker requests read from
+// conn until reading fails, which it returns. It
+// returns nil once conn is closed.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	var buf, out [udpMaxPacket]byte
+
+	for {
+		n, from, err := conn.ReadFrom(buf[:])
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		udpFrom, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		resp := s.handleUDP(out[:0], buf[:n], udpFrom.AddrPort())
+		if len(resp) == 0 {
+			continue
+		}
+
+		// The peer will retransmit if this fails.
+		conn.WriteTo(resp, from)
+	}
+}
+
+// handleUDP appends the response to the request packet p,
+// from addr, to dst. Requests that don't deserve a response
+// give nothing.
+func (s *Server) handleUDP(dst, p []byte, addr netip.AddrPort) []byte {
+	if len(p) < udpHeaderLength {
+		return dst
+	}
+
+	action := binary.BigEndian.Uint32(p[8:])
+	tid := binary.BigEndian.Uint32(p[12:])
+
+	if action == udpActionConnect {
+		if binary.BigEndian.Uint64(p) != udpProtocolId {
+			return dst
+		}
+
+		dst = binary.BigEndian.AppendUint32(dst, udpActionConnect)
+		dst = binary.BigEndian.AppendUint32(dst, tid)
+
+		return binary.BigEndian.AppendUint64(dst, s.connectionId(addr.Addr(), s.epoch()))
+	}
+
+	// The connection ID guards against spoofed addresses.
+	if id := binary.BigEndian.Uint64(p); id != s.connectionId(addr.Addr(), s.epoch()) &&
+		id != s.connectionId(addr.Addr(), s.epoch()-1) {
+		return udpError(dst, tid, "invalid connection id")
+	}
+
+	switch action {
+	case udpActionAnnounce:
+		return s.udpAnnounce(dst, p, tid, addr)
+	case udpActionScrape:
+		return s.udpScrape(dst, p, tid)
+	default:
+		return udpError(dst, tid, "unknown action")
+	}
+}
+
+func (s *Server) udpAnnounce(dst, p []byte, tid uint32, addr netip.AddrPort) []byte {
+	if len(p) < udpAnnounceLength {
+		return udpError(dst, tid, "announce too short")
+	}
+
+	req := tracker.AnnounceRequest{
+		InfoHash:   [20]byte(p[16:36]),
+		PeerId:     [20]byte(p[36:56]),
+		Downloaded: binary.BigEndian.Uint64(p[56:]),
+		Left:       binary.BigEndian.Uint64(p[64:]),
+		Uploaded:   binary.BigEndian.Uint64(p[72:]),
+		Event:      udpEvent(binary.BigEndian.Uint32(p[80:])),
+		IP:         netip.AddrFrom4([4]byte(p[84:88])),
+		Key:        binary.BigEndian.Uint32(p[88:]),
+		Port:       uint64(binary.BigEndian.Uint16(p[96:])),
+	}
+
+	// -1, or any other negative number, is the default.
+	if numWant := int32(binary.BigEndian.Uint32(p[92:])); numWant > 0 {
+		req.NumWant = uint64(numWant)
+	}
+
+	// Zero for the address the announce came from.
+	if req.IP.IsUnspecified() {
+		req.IP = netip.Addr{}
+	}
+
+	resp, err := s.Announce(&req, addr.Addr())
+	if err != nil {
+		return udpError(dst, tid, string(failureReason(err)))
+	}
+
+	dst = binary.BigEndian.AppendUint32(dst, udpActionAnnounce)
+	dst = binary.BigEndian.AppendUint32(dst, tid)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(resp.Interval.Seconds()))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(resp.Incomplete))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(resp.Complete))
+
+	// Peers must be the same family as the
+	// address the announce came from.
+	v6 := addr.Addr().Unmap().Is6()
+
+	for _, peer := range resp.Peers {
+		if len(dst)+18 > udpMaxPacket {
+			break
+		}
+
+		if peer.Addr.Addr().Is6() == v6 {
+			dst = tracker.AppendCompactPeer(dst, peer.Addr)
+		}
+	}
+
+	return dst
+}
+
+func (s *Server) udpScrape(dst, p []byte, tid uint32) []byte {
+	hashes := make([][20]byte, 0, udpMaxScrape)
+	for i := udpHeaderLength; i+20 <= len(p) && len(hashes) < udpMaxScrape; i += 20 {
+		hashes = append(hashes, [20]byte(p[i:i+20]))
+	}
+
+	dst = binary.BigEndian.AppendUint32(dst, udpActionScrape)
+	dst = binary.BigEndian.AppendUint32(dst, tid)
+
+	for _, st := range s.Scrape(nil, hashes) {
+		dst = binary.BigEndian.AppendUint32(dst, uint32(st.Complete))
+		dst = binary.BigEndian.AppendUint32(dst, uint32(st.Downloaded))
+		dst = binary.BigEndian.AppendUint32(dst, uint32(st.Incomplete))
+	}
+
+	return dst
+}
+
+func udpError(dst []byte, tid uint32, reason string) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, udpActionError)
+	dst = binary.BigEndian.AppendUint32(dst, tid)
+
+	return append(dst, reason...)
+}
+
+// connectionId is the connection ID given to ip during
+// epoch, derived from it so that none need be remembered.
+// Clients may use it from any port.
+func (s *Server) connectionId(ip netip.Addr, epoch int64) uint64 {
+	s.secretOnce.Do(func() {
+		rand.Read(s.secret[:])
+	})
+
+	mac := hmac.New(sha256.New, s.secret[:])
+
+	var b [16 + 8]byte
+	a16 := ip.Unmap().As16()
+	copy(b[:], a16[:])
+	binary.BigEndian.PutUint64(b[16:], uint64(epoch))
+	mac.Write(b[:])
+
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+func (s *Server) epoch() int64 {
+	return s.now().Unix() / udpConnectionIdEpoch
+}
+
+// udpEvent gives the event for the UDP
+// protocol's number for it.
+func udpEvent(e uint32) tracker.AnnounceEvent {
+	switch e {
+	case 1:
+		return tracker.EventCompleted
+	case 2:
+		return tracker.EventStarted
+	case 3:
+		return tracker.EventStopped
+	default:
+		return tracker.EventNone
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/tracker"
+)
+
+func TestServeUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Allowed: func(h [20]byte) bool {
+			return h != [20]byte{}
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.ServeUDP(conn)
+	}()
+
+	c := tracker.UDPClient{Timeout: time.Second, MaxRetries: 1}
+
+	ctx := context.Background()
+	announceURL := []byte("udp://" + conn.LocalAddr().String())
+
+	for i := 0; i < 2; i++ {
+		req := tracker.AnnounceRequest{
+			InfoHash: testHash,
+			PeerId:   [20]byte{byte(i + 1)},
+			Port:     uint64(6881 + i),
+			Left:     uint64(i),
+			NumWant:  10,
+			Event:    tracker.EventStarted,
+		}
+
+		resp, err := c.Announce(ctx, announceURL, &req)
+		if err != nil {
+			t.Fatalf("Announce: %v", err)
+		}
+
+		if resp.Complete != 1 || resp.Incomplete != uint64(i) || len(resp.Peers) != i {
+			t.Fatalf("announce %d: %+v", i, resp)
+		}
+
+		if i == 1 && resp.Peers[0].Addr != netip.MustParseAddrPort("127.0.0.1:6881") {
+			t.Fatalf("peers = %v", resp.Peers)
+		}
+	}
+
+	stats, err := c.Scrape(ctx, announceURL, [][20]byte{testHash, {1}})
+	if err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+
+	if len(stats) != 2 || stats[0] != (tracker.ScrapeStats{Complete: 1, Incomplete: 1}) || stats[1] != (tracker.ScrapeStats{}) {
+		t.Fatalf("Scrape = %+v", stats)
+	}
+
+	var ferr *tracker.FailureError
+
+	_, err = c.Announce(ctx, announceURL, &tracker.AnnounceRequest{Port: 1})
+	if !errors.As(err, &ferr) || ferr.Reason != "torrent not allowed" {
+		t.Fatalf("Announce = %v, want not allowed", err)
+	}
+
+	conn.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("ServeUDP = %v", err)
+	}
+}
+
+func TestHandleUDPConnectionId(t *testing.T) {
+	var s Server
+
+	addr := netip.MustParseAddrPort("10.0.0.1:1")
+
+	// Not a connect request.
+	var p [udpHeaderLength]byte
+	if resp := s.handleUDP(nil, p[:], addr); len(resp) != 0 {
+		t.Fatalf("responded to a bad connect: %x", resp)
+	}
+
+	binary.BigEndian.PutUint64(p[:], udpProtocolId)
+	binary.BigEndian.PutUint32(p[12:], 7)
+
+	resp := s.handleUDP(nil, p[:], addr)
+	if len(resp) != 16 || binary.BigEndian.Uint32(resp) != udpActionConnect || binary.BigEndian.Uint32(resp[4:]) != 7 {
+		t.Fatalf("connect response = %x", resp)
+	}
+
+	id := binary.BigEndian.Uint64(resp[8:])
+
+	// The ID is only good for the IP it was given to.
+	var scrape [udpHeaderLength + 20]byte
+	binary.BigEndian.PutUint64(scrape[:], id)
+	binary.BigEndian.PutUint32(scrape[8:], udpActionScrape)
+
+	if resp := s.handleUDP(nil, scrape[:], addr); binary.BigEndian.Uint32(resp) != udpActionScrape || len(resp) != 8+12 {
+		t.Fatalf("scrape response = %x", resp)
+	}
+
+	if resp := s.handleUDP(nil, scrape[:], netip.MustParseAddrPort("10.0.0.2:1")); binary.BigEndian.Uint32(resp) != udpActionError {
+		t.Fatalf("scrape from elsewhere = %x", resp)
+	}
+}
@@ -0,0 +1,385 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// UDP tracker protocol (BEP 15) constants.
+const (
+	udpProtocolId = 0x41727101980
+
+	udpActionConnect  = uint32(0)
+	udpActionAnnounce = uint32(1)
+	udpActionScrape   = uint32(2)
+	udpActionError    = uint32(3)
+
+	// Every request starts with the connection ID,
+	// action and transaction ID.
+	udpHeaderLength   = 8 + 4 + 4
+	udpAnnounceLength = udpHeaderLength + 20 + 20 + 8 + 8 + 8 + 4 + 4 + 4 + 4 + 2
+
+	// How long a client may use a connection ID for.
+	udpConnectionIdLifetime = time.Minute
+
+	// The most info hashes that fit in one scrape.
+	udpMaxScrape = 74
+
+	// Large enough for any sane response.
+	udpMaxPacket = 8192
+)
+
+var (
+	// ErrNotUDP is returned when the UDP client is
+	// given an announce URL that isn't udp://host:port.
+	ErrNotUDP = errors.New("tracker: not a udp://host:port URL")
+	// ErrUDPResponse is returned when a UDP tracker's
+	// response is malformed.
+	ErrUDPResponse = errors.New("tracker: malformed UDP tracker response")
+	// ErrUDPTimeout is returned when a UDP tracker
+	// does not respond after every retransmission.
+	ErrUDPTimeout = errors.New("tracker: UDP tracker did not respond")
+)
+
+// UDPClient performs announces and scrapes against
+// UDP trackers (BEP 15). The zero value is ready to use.
+type UDPClient struct {
+	// How long to wait for the first response
+	// before retransmitting, the n-th retransmission
+	// waits Timeout·2^n. 15 seconds if zero.
+	Timeout time.Duration
+	// How many times to retransmit before
+	// giving up. 8 if zero.
+	MaxRetries int
+
+	mu sync.Mutex
+	// Connection IDs, by tracker host:port.
+	conns map[string]udpConnection
+}
+
+type udpConnection struct {
+	id      uint64
+	expires time.Time
+}
+
+// Announce sends req to the UDP tracker at announce
+// and decodes its response. An error response from
+// the tracker is returned as a *FailureError.
+func (c *UDPClient) Announce(ctx context.Context, announce []byte, req *AnnounceRequest) (*AnnounceResponse, error) {
+	host, err := udpHost(announce)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, stop, err := dialUDP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	var packet [udpAnnounceLength]byte
+	putAnnounce(packet[:], req)
+
+	var buf [udpMaxPacket]byte
+
+	resp, err := c.do(ctx, conn, host, packet[:], buf[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// interval, leechers, seeders, then peers.
+	if len(resp) < 20 {
+		return nil, ErrUDPResponse
+	}
+
+	ar := AnnounceResponse{
+		Interval:   time.Duration(binary.BigEndian.Uint32(resp[8:])) * time.Second,
+		Incomplete: uint64(binary.BigEndian.Uint32(resp[12:])),
+		Complete:   uint64(binary.BigEndian.Uint32(resp[16:])),
+	}
+
+	// Peers are the same family as the tracker.
+	var ok bool
+	if remote, isUDP := conn.RemoteAddr().(*net.UDPAddr); isUDP && remote.IP.To4() == nil {
+		ar.Peers, ok = DecodeCompactPeers6(nil, resp[20:])
+	} else {
+		ar.Peers, ok = DecodeCompactPeers(nil, resp[20:])
+	}
+
+	if !ok {
+		return nil, ErrUDPResponse
+	}
+
+	return &ar, nil
+}
+
+// Scrape asks the UDP tracker at announce for the
+// stats of each of infoHashes. The stats are returned
+// in the same order.
+func (c *UDPClient) Scrape(ctx context.Context, announce []byte, infoHashes [][20]byte) ([]ScrapeStats, error) {
+	host, err := udpHost(announce)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, stop, err := dialUDP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	stats := make([]ScrapeStats, 0, len(infoHashes))
+
+	var packet [udpHeaderLength + 20*udpMaxScrape]byte
+	var buf [udpMaxPacket]byte
+
+	for len(infoHashes) != 0 {
+		batch := infoHashes
+		if len(batch) > udpMaxScrape {
+			batch = batch[:udpMaxScrape]
+		}
+
+		infoHashes = infoHashes[len(batch):]
+
+		binary.BigEndian.PutUint32(packet[8:], udpActionScrape)
+		for i := 0; i < len(batch); i++ {
+			copy(packet[udpHeaderLength+20*i:], batch[i][:])
+		}
+
+		resp, err := c.do(ctx, conn, host, packet[:udpHeaderLength+20*len(batch)], buf[:])
+		if err != nil {
+			return nil, err
+		}
+
+		// seeders, completed, leechers for each hash.
+		if len(resp) < 8+12*len(batch) {
+			return nil, ErrUDPResponse
+		}
+
+		for i := 0; i < len(batch); i++ {
+			p := resp[8+12*i:]
+
+			stats = append(stats, ScrapeStats{
+				Complete:   uint64(binary.BigEndian.Uint32(p)),
+				Downloaded: uint64(binary.BigEndian.Uint32(p[4:])),
+				Incomplete: uint64(binary.BigEndian.Uint32(p[8:])),
+			})
+		}
+	}
+
+	return stats, nil
+}
+
+// do sends packet, whose action is already set, to the tracker
+// at host until it gets a response, connecting first if we
+// don't have a connection ID. It retransmits with exponential
+// backoff, reconnecting if the connection ID expires meanwhile.
+func (c *UDPClient) do(ctx context.Context, conn net.Conn, host string, packet, buf []byte) ([]byte, error) {
+	var connect [udpHeaderLength]byte
+
+	for n := 0; n <= c.maxRetries(); n++ {
+		req := packet
+
+		id, ok := c.connectionId(host)
+		if ok {
+			binary.BigEndian.PutUint64(req, id)
+		} else {
+			req = connect[:]
+			binary.BigEndian.PutUint64(req, udpProtocolId)
+			binary.BigEndian.PutUint32(req[8:], udpActionConnect)
+		}
+
+		resp, err := c.send(ctx, conn, req, buf, c.timeout()<<n)
+		if err == ErrUDPTimeout {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return resp, nil
+		}
+
+		if len(resp) < 16 {
+			return nil, ErrUDPResponse
+		}
+
+		c.setConnectionId(host, binary.BigEndian.Uint64(resp[8:]))
+
+		// Connecting wasn't a retransmission.
+		n--
+	}
+
+	return nil, ErrUDPTimeout
+}
+
+// send writes req with a fresh transaction ID and waits up to
+// timeout for the matching response, which is read into buf.
+func (c *UDPClient) send(ctx context.Context, conn net.Conn, req, buf []byte, timeout time.Duration) ([]byte, error) {
+	action := binary.BigEndian.Uint32(req[8:])
+	tid := rand.Uint32()
+	binary.BigEndian.PutUint32(req[12:], tid)
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	for {
+		n, err := conn.Read(buf)
+
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return nil, ErrUDPTimeout
+		}
+
+		if err != nil {
+			return nil, ctxErr(ctx, err)
+		}
+
+		// Something else's response, or garbage.
+		if n < 8 || binary.BigEndian.Uint32(buf[4:]) != tid {
+			continue
+		}
+
+		switch got := binary.BigEndian.Uint32(buf); {
+		case got == udpActionError:
+			return nil, &FailureError{Reason: string(buf[8:n])}
+		case got != action:
+			return nil, ErrUDPResponse
+		}
+
+		return buf[:n], nil
+	}
+}
+
+func (c *UDPClient) connectionId(host string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, ok := c.conns[host]
+	if !ok || time.Now().After(conn.expires) {
+		return 0, false
+	}
+
+	return conn.id, true
+}
+
+func (c *UDPClient) setConnectionId(host string, id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conns == nil {
+		c.conns = make(map[string]udpConnection)
+	}
+
+	c.conns[host] = udpConnection{id: id, expires: time.Now().Add(udpConnectionIdLifetime)}
+}
+
+func (c *UDPClient) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 15 * time.Second
+	}
+
+	return c.Timeout
+}
+
+func (c *UDPClient) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return 8
+	}
+
+	return c.MaxRetries
+}
+
+// putAnnounce writes the body of an announce
+// request into p, leaving the header's connection
+// and transaction IDs for later.
+func putAnnounce(p []byte, req *AnnounceRequest) {
+	binary.BigEndian.PutUint32(p[8:], udpActionAnnounce)
+	copy(p[16:], req.InfoHash[:])
+	copy(p[36:], req.PeerId[:])
+	binary.BigEndian.PutUint64(p[56:], req.Downloaded)
+	binary.BigEndian.PutUint64(p[64:], req.Left)
+	binary.BigEndian.PutUint64(p[72:], req.Uploaded)
+	binary.BigEndian.PutUint32(p[80:], udpEvent(req.Event))
+	// ip, zero for the sender's address.
+	if ip := req.IP.Unmap(); ip.Is4() {
+		copy(p[84:88], ip.AsSlice())
+	}
+	binary.BigEndian.PutUint32(p[88:], req.Key)
+	binary.BigEndian.PutUint32(p[92:], uint32(req.NumWant))
+	binary.BigEndian.PutUint16(p[96:], uint16(req.Port))
+}
+
+// udpEvent gives the UDP protocol's
+// number for an event.
+func udpEvent(e AnnounceEvent) uint32 {
+	switch e {
+	case EventCompleted:
+		return 1
+	case EventStarted:
+		return 2
+	case EventStopped:
+		return 3
+	default:
+		// Including EventPaused, which
+		// the UDP protocol doesn't have.
+		return 0
+	}
+}
+
+// udpHost gives the host:port of a
+// udp:// announce URL.
+func udpHost(announce []byte) (string, error) {
+	u, err := url.Parse(string(announce))
+	if err != nil || u.Scheme != "udp" || u.Port() == "" {
+		return "", ErrNotUDP
+	}
+
+	return u.Host, nil
+}
+
+// dialUDP connects to host, closing the connection
+// if ctx is done. stop must be called when finished.
+func dialUDP(ctx context.Context, host string) (net.Conn, func(), error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "udp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unwatch := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	stop := func() {
+		unwatch()
+		conn.Close()
+	}
+
+	return conn, stop, nil
+}
+
+// ctxErr prefers the context's error to err,
+// which is usually a result of the context
+// closing the connection.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
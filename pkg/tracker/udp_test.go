@@ -0,0 +1,271 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// udpTracker is an in-process UDP tracker
+// speaking just enough of BEP 15 for tests.
+type udpTracker struct {
+	conn net.PacketConn
+
+	mu sync.Mutex
+	// Packets to ignore, to force retransmission.
+	drop int
+	// If set, announces are answered with an error.
+	failure string
+
+	connects, announces, scrapes int
+	lastAnnounce                 []byte
+}
+
+func newUDPTracker(t *testing.T) *udpTracker {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &udpTracker{conn: conn}
+	t.Cleanup(func() { conn.Close() })
+
+	go tr.serve()
+
+	return tr
+}
+
+func (tr *udpTracker) announceURL() []byte {
+	return []byte("udp://" + tr.conn.LocalAddr().String() + "/announce")
+}
+
+func (tr *udpTracker) serve() {
+	const connectionId = 0x1122334455667788
+
+	buf := make([]byte, 2048)
+
+	for {
+		n, addr, err := tr.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		p := buf[:n]
+		action := binary.BigEndian.Uint32(p[8:])
+		tid := p[12:16]
+
+		tr.mu.Lock()
+
+		if tr.drop > 0 {
+			tr.drop--
+			tr.mu.Unlock()
+			continue
+		}
+
+		var resp []byte
+		resp = binary.BigEndian.AppendUint32(resp, action)
+		resp = append(resp, tid...)
+
+		switch {
+		case action == udpActionConnect:
+			tr.connects++
+			resp = binary.BigEndian.AppendUint64(resp, connectionId)
+		case binary.BigEndian.Uint64(p) != connectionId:
+			resp = binary.BigEndian.AppendUint32(nil, udpActionError)
+			resp = append(resp, tid...)
+			resp = append(resp, "bad connection id"...)
+		case action == udpActionAnnounce && tr.failure != "":
+			tr.announces++
+			resp = binary.BigEndian.AppendUint32(nil, udpActionError)
+			resp = append(resp, tid...)
+			resp = append(resp, tr.failure...)
+		case action == udpActionAnnounce:
+			tr.announces++
+			tr.lastAnnounce = append([]byte(nil), p...)
+			resp = binary.BigEndian.AppendUint32(resp, 1800)
+			resp = binary.BigEndian.AppendUint32(resp, 2)
+			resp = binary.BigEndian.AppendUint32(resp, 7)
+			resp = append(resp, 127, 0, 0, 1, 0x1A, 0xE1)
+		case action == udpActionScrape:
+			tr.scrapes++
+			for i := udpHeaderLength; i+20 <= len(p); i += 20 {
+				// stats derived from the hash so
+				// the order can be checked.
+				resp = binary.BigEndian.AppendUint32(resp, uint32(p[i]))
+				resp = binary.BigEndian.AppendUint32(resp, 0)
+				resp = binary.BigEndian.AppendUint32(resp, uint32(p[i+1]))
+			}
+		}
+
+		tr.mu.Unlock()
+
+		tr.conn.WriteTo(resp, addr)
+	}
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	tr := newUDPTracker(t)
+
+	c := UDPClient{Timeout: 20 * time.Millisecond}
+	ctx := context.Background()
+
+	req := AnnounceRequest{
+		InfoHash: [20]byte{1, 2, 3},
+		PeerId:   [20]byte{'-', 'B', 'P'},
+		Port:     6881,
+		Left:     1000,
+		NumWant:  50,
+		Event:    EventStarted,
+		Key:      0xDEADBEEF,
+		IP:       netip.MustParseAddr("10.1.2.3"),
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Announce(ctx, tr.announceURL(), &req)
+		if err != nil {
+			t.Fatalf("Announce: %v", err)
+		}
+
+		if resp.Interval != 30*time.Minute || resp.Incomplete != 2 || resp.Complete != 7 ||
+			len(resp.Peers) != 1 || resp.Peers[0].Addr.String() != "127.0.0.1:6881" {
+			t.Fatalf("got: %+v", resp)
+		}
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.connects != 1 || tr.announces != 2 {
+		t.Fatalf("connection ID not cached: %d connects, %d announces", tr.connects, tr.announces)
+	}
+
+	p := tr.lastAnnounce
+	if len(p) != udpAnnounceLength ||
+		[20]byte(p[16:36]) != req.InfoHash ||
+		binary.BigEndian.Uint64(p[64:]) != 1000 ||
+		binary.BigEndian.Uint32(p[80:]) != 2 ||
+		[4]byte(p[84:88]) != [4]byte{10, 1, 2, 3} ||
+		binary.BigEndian.Uint32(p[88:]) != 0xDEADBEEF ||
+		binary.BigEndian.Uint16(p[96:]) != 6881 {
+		t.Fatalf("bad announce packet: %x", p)
+	}
+}
+
+func Test_udpEvent(t *testing.T) {
+	want := map[AnnounceEvent]uint32{
+		EventNone:      0,
+		EventCompleted: 1,
+		EventStarted:   2,
+		EventStopped:   3,
+		EventPaused:    0,
+	}
+
+	for e, w := range want {
+		if got := udpEvent(e); got != w {
+			t.Fatalf("%q: got: %d, want: %d", e, got, w)
+		}
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	tr := newUDPTracker(t)
+
+	tr.mu.Lock()
+	tr.drop = 3
+	tr.mu.Unlock()
+
+	c := UDPClient{Timeout: 5 * time.Millisecond}
+
+	start := time.Now()
+
+	if _, err := c.Announce(context.Background(), tr.announceURL(), &AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+
+	// 5 + 10 + 20 milliseconds of backoff.
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("retransmitted too quickly: %s", elapsed)
+	}
+
+	tr.mu.Lock()
+	tr.drop = 100
+	tr.mu.Unlock()
+
+	c = UDPClient{Timeout: time.Millisecond, MaxRetries: 3}
+
+	if _, err := c.Announce(context.Background(), tr.announceURL(), &AnnounceRequest{}); err != ErrUDPTimeout {
+		t.Fatalf("got: %v, want: %v", err, ErrUDPTimeout)
+	}
+}
+
+func TestUDPErrors(t *testing.T) {
+	tr := newUDPTracker(t)
+
+	tr.mu.Lock()
+	tr.failure = "unregistered torrent"
+	tr.mu.Unlock()
+
+	c := UDPClient{Timeout: 20 * time.Millisecond}
+
+	var failure *FailureError
+	if _, err := c.Announce(context.Background(), tr.announceURL(), &AnnounceRequest{}); !errors.As(err, &failure) ||
+		failure.Reason != "unregistered torrent" {
+		t.Fatalf("got: %v", err)
+	}
+
+	if _, err := c.Announce(context.Background(), []byte("http://a/announce"), &AnnounceRequest{}); err != ErrNotUDP {
+		t.Fatalf("got: %v, want: %v", err, ErrNotUDP)
+	}
+
+	tr.mu.Lock()
+	tr.drop = 100
+	tr.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	c = UDPClient{Timeout: time.Second}
+
+	if _, err := c.Announce(ctx, tr.announceURL(), &AnnounceRequest{}); err != context.DeadlineExceeded {
+		t.Fatalf("got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestUDPScrape(t *testing.T) {
+	tr := newUDPTracker(t)
+
+	c := UDPClient{Timeout: 20 * time.Millisecond}
+
+	hashes := make([][20]byte, 100)
+	for i := range hashes {
+		hashes[i][0] = byte(i)
+		hashes[i][1] = byte(2 * i)
+	}
+
+	stats, err := c.Scrape(context.Background(), tr.announceURL(), hashes)
+	if err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+
+	if len(stats) != len(hashes) {
+		t.Fatalf("got %d stats, want: %d", len(stats), len(hashes))
+	}
+
+	for i := range stats {
+		if stats[i].Complete != uint64(i) || stats[i].Incomplete != uint64(byte(2*i)) {
+			t.Fatalf("stats %d: got: %+v", i, stats[i])
+		}
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.scrapes != 2 {
+		t.Fatalf("want 2 scrape requests, got: %d", tr.scrapes)
+	}
+}
@@ -0,0 +1,328 @@
+// Package verify hashes downloaded pieces against the metainfo
+// in a pool of workers, and keeps track of which peers sent
+// pieces that failed.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/joelancaster/bytepour/pkg/clock"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/storage"
+)
+
+// ErrStopped is returned when submitting
+// to a Verifier that is no longer running.
+var ErrStopped = errors.New("verify: verifier stopped")
+
+// Result is the outcome of verifying a piece.
+type Result struct {
+	Index int
+	// Whether the piece matched its hash.
+	OK bool
+	// Why the piece couldn't be read, if it
+	// couldn't. OK is then false.
+	Err error
+	// The peers that sent blocks of the piece.
+	Peers []int
+}
+
+// Blame is the part a peer had in the pieces verified.
+type Blame struct {
+	// Pieces it sent blocks of that passed, and that failed.
+	Good, Bad int
+	// Failed pieces it sent every block of,
+	// which were surely its fault.
+	Sole int
+}
+
+// Stats are totals of the pieces verified.
+type Stats struct {
+	Pieces, Failed int
+	// Bytes hashed, and the time spent on them,
+	// reading them included, across workers.
+	Bytes    uint64
+	HashTime time.Duration
+}
+
+// Throughput is the bytes hashed per second of
+// hashing, by each worker, zero if none yet.
+func (s Stats) Throughput() float64 {
+	if s.HashTime <= 0 {
+		return 0
+	}
+
+	return float64(s.Bytes) / s.HashTime.Seconds()
+}
+
+// Verifier hashes pieces of Info in Storage as they
+// are submitted, in Workers goroutines, delivering
+// the results in the order they finish.
+type Verifier struct {
+	Info    *metainfo.Info
+	Storage storage.Storage
+	// The number of pieces to hash at
+	// once, GOMAXPROCS if zero.
+	Workers int
+	// The number of pieces that may wait to be
+	// hashed before Submit blocks, twice the
+	// number of workers if zero.
+	Queue int
+	// If nil, the system clock.
+	Clock clock.Clock
+
+	once    sync.Once
+	jobs    chan job
+	results chan Result
+	stopped chan struct{}
+
+	mu    sync.Mutex
+	blame map[int]*Blame
+	stats Stats
+}
+
+type job struct {
+	index int
+	peers []int
+}
+
+func (v *Verifier) init() {
+	v.once.Do(func() {
+		queue := v.Queue
+		if queue == 0 {
+			queue = 2 * v.workers()
+		}
+
+		v.jobs = make(chan job, queue)
+		v.results = make(chan Result)
+		v.stopped = make(chan struct{})
+		v.blame = make(map[int]*Blame)
+	})
+}
+
+func (v *Verifier) workers() int {
+	if v.Workers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return v.Workers
+}
+
+// Results delivers the outcome of each piece submitted.
+// It must be read, or the workers stop. It is closed
+// when Run returns.
+func (v *Verifier) Results() <-chan Result {
+	v.init()
+
+	return v.results
+}
+
+// Submit queues piece index, all of whose blocks are in
+// Storage, to be verified, with the peers that sent them.
+// When the queue is full it blocks, so the downloader
+// slows to the speed of hashing, until ctx is done.
+func (v *Verifier) Submit(ctx context.Context, index int, peers []int) error {
+	v.init()
+
+	j := job{index: index, peers: append([]int(nil), peers...)}
+
+	select {
+	case <-v.stopped:
+		return ErrStopped
+	default:
+	}
+
+	select {
+	case v.jobs <- j:
+		return nil
+	case <-v.stopped:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run hashes submitted pieces until ctx is done, when
+// pieces waiting are dropped, and Results closed.
+func (v *Verifier) Run(ctx context.Context) error {
+	v.init()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < v.workers(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			v.work(ctx)
+		}()
+	}
+
+	<-ctx.Done()
+	close(v.stopped)
+
+	wg.Wait()
+	close(v.results)
+
+	return ctx.Err()
+}
+
+func (v *Verifier) work(ctx context.Context) {
+	buf := make([]byte, v.Info.PieceLength)
+
+	for {
+		var j job
+
+		select {
+		case j = <-v.jobs:
+		case <-ctx.Done():
+			return
+		}
+
+		ok, err := v.check(j.index, buf)
+
+		// Peers aren't to blame for a failed read.
+		if err == nil {
+			v.record(j.peers, ok)
+		}
+
+		select {
+		case v.results <- Result{Index: j.index, OK: ok, Err: err, Peers: j.peers}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check hashes piece index now, reporting whether
+// it matches, or why it couldn't be read. It is for
+// checking what's stored, as when resuming, so
+// doesn't blame anyone.
+func (v *Verifier) Check(index int) (bool, error) {
+	v.init()
+
+	return v.check(index, make([]byte, v.Info.PieceLength))
+}
+
+func (v *Verifier) check(index int, buf []byte) (bool, error) {
+	clk := v.Clock
+	if clk == nil {
+		clk = clock.System()
+	}
+
+	if index < 0 || index >= v.Info.NumPieces() || len(v.Info.Pieces) < 20*(index+1) {
+		return false, storage.ErrOutOfRange
+	}
+
+	start := clk.Now()
+
+	length := v.Info.PieceSize(index)
+	p := buf[:length]
+
+	if _, err := v.Storage.ReadAt(p, index, 0); err != nil {
+		return false, err
+	}
+
+	sum := sha1.Sum(p)
+	ok := bytes.Equal(sum[:], v.Info.Pieces[20*index:20*(index+1)])
+
+	v.mu.Lock()
+	v.stats.Pieces++
+	if !ok {
+		v.stats.Failed++
+	}
+	v.stats.Bytes += length
+	v.stats.HashTime += clk.Now().Sub(start)
+	v.mu.Unlock()
+
+	return ok, nil
+}
+
+// record blames peers for a piece.
+func (v *Verifier) record(peers []int, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	sole := allSame(peers)
+
+	for k, key := range peers {
+		// Each peer once, though it sent many blocks.
+		if containsBefore(peers, k) {
+			continue
+		}
+
+		b := v.blame[key]
+		if b == nil {
+			b = &Blame{}
+			v.blame[key] = b
+		}
+
+		switch {
+		case ok:
+			b.Good++
+		case sole:
+			b.Bad++
+			b.Sole++
+		default:
+			b.Bad++
+		}
+	}
+}
+
+// Blame is the record of the peer key.
+func (v *Verifier) Blame(key int) Blame {
+	v.init()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if b := v.blame[key]; b != nil {
+		return *b
+	}
+
+	return Blame{}
+}
+
+// Forget forgets the record of the peer key, as it left.
+func (v *Verifier) Forget(key int) {
+	v.init()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.blame, key)
+}
+
+// Stats is the totals of pieces verified so far.
+func (v *Verifier) Stats() Stats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.stats
+}
+
+func containsBefore(keys []int, k int) bool {
+	for _, key := range keys[:k] {
+		if key == keys[k] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func allSame(keys []int) bool {
+	for _, key := range keys {
+		if key != keys[0] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,189 @@
+package verify
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/joelancaster/bytepour/internal/testtorrent"
+	"github.com/joelancaster/bytepour/pkg/metainfo"
+	"github.com/joelancaster/bytepour/pkg/storage"
+)
+
+// newTorrent makes a torrent of n pieces of 8 bytes, the
+// last short, in memory, with piece bad corrupted.
+func newTorrent(t *testing.T, n, bad int) (*metainfo.Info, *storage.Memory) {
+	tt := testtorrent.Torrent{Name: "t", PieceLength: 8, Files: []testtorrent.File{{Data: testtorrent.Pattern(8*n-3, 0)}}}
+	info := tt.Info()
+
+	m, err := storage.NewMemory(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copy(m.Bytes(), tt.Data())
+	m.Bytes()[8*bad] ^= 0xFF
+
+	return info, m
+}
+
+func TestVerifier(t *testing.T) {
+	info, m := newTorrent(t, 4, 1)
+	v := &Verifier{Info: info, Storage: m, Workers: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- v.Run(ctx)
+	}()
+
+	// Peer 1 sent the bad piece alone, and
+	// peer 2 had a hand in it too, and a good one.
+	peers := [][]int{{1, 2}, {1, 1}, {2}, {3, 1}}
+	go func() {
+		for i, ps := range peers {
+			if err := v.Submit(ctx, i, ps); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	var results []Result
+	for range peers {
+		results = append(results, <-v.Results())
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Index < results[j].Index
+	})
+
+	for i, r := range results {
+		if r.Index != i || r.OK != (i != 1) || r.Err != nil || !reflect.DeepEqual(r.Peers, peers[i]) {
+			t.Fatalf("result %d = %+v", i, r)
+		}
+	}
+
+	blames := map[int]Blame{
+		1: {Good: 2, Bad: 1, Sole: 1},
+		2: {Good: 2},
+		3: {Good: 1},
+	}
+
+	for key, want := range blames {
+		if got := v.Blame(key); got != want {
+			t.Fatalf("Blame(%d) = %+v, want %+v", key, got, want)
+		}
+	}
+
+	v.Forget(1)
+	if got := v.Blame(1); got != (Blame{}) {
+		t.Fatalf("Blame after Forget = %+v", got)
+	}
+
+	if s := v.Stats(); s.Pieces != 4 || s.Failed != 1 || s.Bytes != info.Length {
+		t.Fatalf("Stats = %+v", s)
+	}
+
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Run = %v", err)
+	}
+
+	if _, ok := <-v.Results(); ok {
+		t.Fatal("results not closed")
+	}
+
+	if err := v.Submit(context.Background(), 0, nil); err != ErrStopped {
+		t.Fatalf("Submit after Run = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestVerifierBackPressure(t *testing.T) {
+	info, m := newTorrent(t, 3, 0)
+	v := &Verifier{Info: info, Storage: m, Workers: 1, Queue: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go v.Run(ctx)
+
+	// One piece hashed, waiting on Results
+	// to be read, and one queued.
+	for i := 0; i < 2; i++ {
+		if err := v.Submit(ctx, i, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+
+	if err := v.Submit(short, 2, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Submit to a full queue = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if r := <-v.Results(); r.Index != 0 || r.OK {
+		t.Fatalf("result = %+v", r)
+	}
+
+	if err := v.Submit(ctx, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	info, m := newTorrent(t, 2, 0)
+	v := &Verifier{Info: info, Storage: m}
+
+	if ok, err := v.Check(1); !ok || err != nil {
+		t.Fatalf("Check(1) = %v, %v", ok, err)
+	}
+
+	if ok, err := v.Check(0); ok || err != nil {
+		t.Fatalf("Check(0) = %v, %v", ok, err)
+	}
+
+	if _, err := v.Check(2); err != storage.ErrOutOfRange {
+		t.Fatalf("Check(2) = %v, want %v", err, storage.ErrOutOfRange)
+	}
+
+	if s := v.Stats(); s.Pieces != 2 || s.Failed != 1 || s.Bytes != 13 || s.Throughput() <= 0 {
+		t.Fatalf("Stats = %+v, throughput %v", s, s.Throughput())
+	}
+}
+
+func BenchmarkVerifier(b *testing.B) {
+	const n, pieceLength = 64, 256 << 10
+
+	info := &metainfo.Info{Name: []byte("t"), Length: n * pieceLength, PieceLength: pieceLength}
+	info.Pieces = make([]byte, 20*n)
+
+	m, err := storage.NewMemory(info)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	v := &Verifier{Info: info, Storage: m}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go v.Run(ctx)
+
+	b.SetBytes(pieceLength)
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			v.Submit(ctx, i%n, nil)
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		<-v.Results()
+	}
+}